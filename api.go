@@ -1,34 +1,136 @@
 package zsched
 
 import (
+	"errors"
 	"maps"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/auth"
+	"github.com/vlourme/zsched/pkg/blob"
 	"github.com/vlourme/zsched/pkg/storage"
+	"go.opentelemetry.io/otel/propagation"
 )
 
-func newRouter[T any](tasks map[string]*Task[T], storage storage.Storage) *gin.Engine {
+func newRouter[T any](e *Engine[T]) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
 	router.Use(func(ctx *gin.Context) {
-		ctx.Set("tasks", tasks)
-		ctx.Set("storage", storage)
+		ctx.Set("tasks", e.tasks)
+		ctx.Set("storage", e.storage)
+		ctx.Set("events", e.events)
+		ctx.Set("engine", e)
 	})
 
+	// Probes are registered before the authentication middleware
+	router.GET("/healthz", GetHealthz[T])
+	router.GET("/readyz", GetReadyz[T])
+
+	if len(e.authenticators) > 0 {
+		router.Use(authenticate(e.authenticators))
+	}
+
 	router.GET("/tasks", GetTasks[T])
 	router.GET("/tasks/:name", GetTask[T])
 	router.POST("/tasks/:name", PostTask[T])
+	router.POST("/tasks/:name/batch", PostTaskBatch[T])
+
+	router.GET("/executions", GetExecutions[T])
+	router.GET("/executions/:id", GetExecutionByID[T])
+	router.GET("/executions/:id/logs", GetExecutionLogs[T])
+	router.GET("/executions/:id/logs/stream", GetExecutionLogsStream[T])
+	router.POST("/executions/:id/retry", PostExecutionRetry[T])
+
+	router.GET("/events", GetEvents[T])
+	router.GET("/engine", GetEngine[T])
+	router.GET("/nodes", GetNodes[T])
+
+	for _, hook := range e.hooks {
+		if h, ok := hook.(MetricsHook); ok && h.MetricsHandler() != nil {
+			router.GET("/metrics", GetMetrics(h.MetricsHandler()))
+			break
+		}
+	}
+
+	var spec map[string]any
+	router.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	})
+	spec = newOpenAPISpec(router.Routes(), e.tasks, len(e.authenticators) > 0)
 
 	return router
 }
 
+// authenticate rejects requests that are not authenticated by any of the authenticators
+func authenticate(authenticators []auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.Authenticate(c.Request, authenticators...)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		c.Set("principal", principal)
+		c.Next()
+	}
+}
+
+// can returns true if the caller has the permission on the task,
+// every caller has every permission when authentication is disabled
+func can[T any](c *gin.Context, permission auth.Permission, taskName string) bool {
+	principal, ok := c.Get("principal")
+	if !ok {
+		return true
+	}
+
+	var tags []string
+	if t, ok := c.MustGet("tasks").(map[string]*Task[T])[taskName]; ok {
+		tags = t.Tags
+	}
+
+	return principal.(*auth.Principal).Can(permission, taskName, tags)
+}
+
+// canAll returns true if the caller has the permission on every task
+func canAll(c *gin.Context, permission auth.Permission) bool {
+	principal, ok := c.Get("principal")
+	if !ok {
+		return true
+	}
+
+	return principal.(*auth.Principal).CanAll(permission)
+}
+
+// authorize aborts the request with a 403 if the caller lacks the permission on the task
+func authorize[T any](c *gin.Context, permission auth.Permission, taskName string) bool {
+	if can[T](c, permission, taskName) {
+		return true
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+	return false
+}
+
 // GetTasks returns all tasks
 func GetTasks[T any](c *gin.Context) {
 	tasks := c.MustGet("tasks").(map[string]*Task[T])
-	c.JSON(http.StatusOK, slices.Collect(maps.Values(tasks)))
+
+	visible := slices.DeleteFunc(slices.Collect(maps.Values(tasks)), func(t *Task[T]) bool {
+		return !can[T](c, auth.PermissionRead, t.Name())
+	})
+
+	c.JSON(http.StatusOK, visible)
 }
 
 // GetTask returns a task by name
@@ -42,6 +144,10 @@ func GetTask[T any](c *gin.Context) {
 		return
 	}
 
+	if !authorize[T](c, auth.PermissionRead, t.Name()) {
+		return
+	}
+
 	c.JSON(http.StatusOK, t)
 }
 
@@ -54,16 +160,253 @@ func PostTask[T any](c *gin.Context) {
 		return
 	}
 
-	body := make(map[string]any)
-	if err := c.BindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	if !authorize[T](c, auth.PermissionDispatch, t.Name()) {
+		return
+	}
+
+	params, opts, err := bindDispatchRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Callers may propagate their trace context in the traceparent header
+	ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	taskID, err := t.dispatch(ctx, params, opts...)
+	if errors.Is(err, ErrInvalidDispatch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Task dispatched successfully", "task_id": taskID})
+}
+
+// GetExecutions returns executions matching the query filters, most recent first.
+// Pagination is done by passing the returned next_cursor as the before parameter.
+func GetExecutions[T any](c *gin.Context) {
+	storage := c.MustGet("storage").(storage.Storage)
+
+	filter, err := parseExecutionFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !authorizeFilter[T](c, filter) {
+		return
+	}
+
+	executions, err := ListExecutions(storage, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	nextCursor := ""
+	if len(executions) == filter.Limit {
+		nextCursor = ExecutionCursor(executions[len(executions)-1])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"executions":  executions,
+		"next_cursor": nextCursor,
+	})
+}
+
+// GetExecutionByID returns an execution by id
+func GetExecutionByID[T any](c *gin.Context) {
+	storage := c.MustGet("storage").(storage.Storage)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution id"})
+		return
+	}
+
+	execution, err := GetExecution(storage, id)
+	if errors.Is(err, ErrExecutionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !authorize[T](c, auth.PermissionRead, execution.TaskName) {
+		return
+	}
+
+	c.JSON(http.StatusOK, execution)
+}
+
+// GetExecutionLogs returns the logs of an execution, most recent first
+func GetExecutionLogs[T any](c *gin.Context) {
+	storage := c.MustGet("storage").(storage.Storage)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution id"})
+		return
+	}
+
+	execution, err := GetExecution(storage, id)
+	if errors.Is(err, ErrExecutionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !authorize[T](c, auth.PermissionRead, execution.TaskName) {
+		return
+	}
+
+	limit, err := parseLimit(c, 1000)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logs, err := ListExecutionLogs(storage, id, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, logs)
+}
+
+// PostExecutionRetry dispatches a new execution with the parameters of an existing one
+func PostExecutionRetry[T any](c *gin.Context) {
+	tasks := c.MustGet("tasks").(map[string]*Task[T])
+	storage := c.MustGet("storage").(storage.Storage)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution id"})
+		return
+	}
+
+	execution, err := GetExecution(storage, id)
+	if errors.Is(err, ErrExecutionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, ok := tasks[execution.TaskName]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return
+	}
+
+	if !authorize[T](c, auth.PermissionDispatch, t.Name()) {
 		return
 	}
 
-	if err := t.Execute(body); err != nil {
+	// Offloaded parameters are loaded, so the retry stores its own blob instead of
+	// sharing the one of the original execution, deleted once it succeeds
+	params, err := t.executor.resolveParameters(&State{Parameters: execution.Parameters})
+	if errors.Is(err, blob.ErrNotFound) {
+		c.JSON(http.StatusGone, gin.H{"error": "Offloaded parameters of the execution have been deleted"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Task dispatched successfully"})
+	state := newState(params)
+	state.ParentID = execution.ParentID
+	ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	if err := t.executor.Publish(ctx, t, state); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Task dispatched successfully", "task_id": state.TaskID})
+}
+
+// authorizeFilter aborts the request with a 403 if the caller cannot read the executions
+// matched by the filter, callers restricted to some tasks must filter by task name
+func authorizeFilter[T any](c *gin.Context, filter ExecutionFilter) bool {
+	if filter.TaskName != "" {
+		return authorize[T](c, auth.PermissionRead, filter.TaskName)
+	}
+
+	if canAll(c, auth.PermissionRead) {
+		return true
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden, filter by task_name"})
+	return false
+}
+
+// parseExecutionFilter parses the execution filter from the query parameters
+func parseExecutionFilter(c *gin.Context) (ExecutionFilter, error) {
+	filter := ExecutionFilter{
+		TaskName: c.Query("task_name"),
+	}
+
+	if status := c.Query("status"); status != "" {
+		filter.Status = strings.Split(status, ",")
+	}
+
+	if parentID := c.Query("parent_id"); parentID != "" {
+		id, err := uuid.Parse(parentID)
+		if err != nil {
+			return filter, errors.New("invalid parent_id")
+		}
+		filter.ParentID = id
+	}
+
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return filter, errors.New("invalid since, expected RFC3339 time")
+		}
+		filter.Since = t
+	}
+
+	if before := c.Query("before"); before != "" {
+		t, id, err := parseExecutionCursor(before)
+		if err != nil {
+			return filter, errors.New("invalid before, expected a next_cursor or an RFC3339 time")
+		}
+		filter.Before = t
+		filter.BeforeID = id
+	}
+
+	limit, err := parseLimit(c, 100)
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit = limit
+
+	return filter, nil
+}
+
+// parseLimit parses the limit query parameter, capped to 1000
+func parseLimit(c *gin.Context, defaultLimit int) (int, error) {
+	limit := c.Query("limit")
+	if limit == "" {
+		return defaultLimit, nil
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid limit")
+	}
+
+	return min(n, 1000), nil
 }
@@ -0,0 +1,168 @@
+package zsched
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/auth"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+const (
+	// streamKeepAlive is the interval at which a ping is sent on idle streams
+	streamKeepAlive = 15 * time.Second
+
+	// logDrainDelay is the time logs are still read once an execution is final,
+	// as the log writer flushes them asynchronously
+	logDrainDelay = 3 * time.Second
+)
+
+// GetEvents streams execution status transitions as Server-Sent Events.
+// Events can be filtered with the task_name and task_id query parameters.
+func GetEvents[T any](c *gin.Context) {
+	events := c.MustGet("events").(*EventBus)
+
+	var taskNames []string
+	if taskName := c.Query("task_name"); taskName != "" {
+		taskNames = strings.Split(taskName, ",")
+	}
+
+	var taskID uuid.UUID
+	if id := c.Query("task_id"); id != "" {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task_id"})
+			return
+		}
+		taskID = parsed
+	}
+
+	ch, unsubscribe := events.Subscribe(100)
+	defer unsubscribe()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			c.SSEvent("ping", time.Now())
+			return true
+		case event, ok := <-ch:
+			if !ok {
+				return false
+			}
+			if taskNames != nil && !slices.Contains(taskNames, event.TaskName) {
+				return true
+			}
+			if taskID != uuid.Nil && event.TaskID != taskID {
+				return true
+			}
+			if !can[T](c, auth.PermissionRead, event.TaskName) {
+				return true
+			}
+			c.SSEvent(string(event.Status), event)
+			return true
+		}
+	})
+}
+
+// GetExecutionLogsStream tails the logs of an execution as Server-Sent Events.
+// The stream ends with an "end" event once the execution has reached a final status.
+func GetExecutionLogsStream[T any](c *gin.Context) {
+	storage := c.MustGet("storage").(storage.Storage)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution id"})
+		return
+	}
+
+	execution, err := GetExecution(storage, id)
+	if errors.Is(err, ErrExecutionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !authorize[T](c, auth.PermissionRead, execution.TaskName) {
+		return
+	}
+
+	var since time.Time
+	if s := c.Query("since"); s != "" {
+		since, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since, expected RFC3339 time"})
+			return
+		}
+	}
+
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+
+	var (
+		after   uuid.UUID
+		final   *Execution
+		finalAt time.Time
+	)
+	lastPing := time.Now()
+
+	c.Stream(func(w io.Writer) bool {
+		logs, err := ListExecutionLogsSince(storage, id, since, after, 1000)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": err.Error()})
+			return false
+		}
+
+		for _, l := range logs {
+			c.SSEvent("log", l)
+			since, after = l.LoggedAt, l.ID
+		}
+
+		if len(logs) == 0 {
+			if final != nil && time.Since(finalAt) >= logDrainDelay {
+				c.SSEvent("end", final)
+				return false
+			}
+			if final == nil {
+				execution, err := GetExecution(storage, id)
+				if err != nil {
+					c.SSEvent("error", gin.H{"error": err.Error()})
+					return false
+				}
+				if isFinalExecution(execution) {
+					final, finalAt = execution, time.Now()
+				}
+			}
+			if time.Since(lastPing) >= streamKeepAlive {
+				c.SSEvent("ping", time.Now())
+				lastPing = time.Now()
+			}
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-poll.C:
+			return true
+		}
+	})
+}
+
+// isFinalExecution returns true when the execution will not change status anymore,
+// successes and expirations are final for the rows written without the final column
+func isFinalExecution(execution *Execution) bool {
+	return execution.Final || execution.Status == StatusSuccess || execution.Status == StatusExpired
+}
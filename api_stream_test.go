@@ -0,0 +1,27 @@
+package zsched
+
+import "testing"
+
+func TestIsFinalExecution(t *testing.T) {
+	tests := []struct {
+		name      string
+		execution Execution
+		want      bool
+	}{
+		{name: "running", execution: Execution{Status: StatusRunning}},
+		{name: "failed and retried", execution: Execution{Status: StatusFailed, Iterations: 1}},
+		{name: "failed for good", execution: Execution{Status: StatusFailed, Final: true}, want: true},
+		{name: "lost and delivered again", execution: Execution{Status: StatusLost}},
+		{name: "lost for good", execution: Execution{Status: StatusLost, Final: true}, want: true},
+		{name: "success without final column", execution: Execution{Status: StatusSuccess}, want: true},
+		{name: "expired without final column", execution: Execution{Status: StatusExpired}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFinalExecution(&tt.execution); got != tt.want {
+				t.Errorf("isFinalExecution = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
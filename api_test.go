@@ -0,0 +1,115 @@
+package zsched
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestPostExecutionRetry(t *testing.T) {
+	body := strings.Repeat("a", 100)
+
+	tests := []struct {
+		name      string
+		offloaded bool
+		succeeded bool
+		status    int
+	}{
+		{name: "inline parameters", status: http.StatusOK},
+		{name: "offloaded parameters of a failed execution", offloaded: true, status: http.StatusOK},
+		{name: "offloaded parameters of a succeeded execution", offloaded: true, succeeded: true, status: http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, b, blobs := newClaimCheckExecutor(t)
+			task := NewTask("mail", func(*Context[any]) error { return nil })
+			task.executor = e
+
+			// The original execution ended, its blob is deleted only if it succeeded
+			original := &State{ID: uuid.New(), TaskID: uuid.New(), Parameters: map[string]any{"body": body}}
+			if tt.offloaded {
+				if err := e.offload(original); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.succeeded {
+				e.releaseParameters(original)
+			}
+
+			parameters, err := json.Marshal(original.Parameters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage := storagetest.New(func(q storagetest.Query) storagetest.Result {
+				if !q.Contains("WHERE task_id = $1") {
+					return storagetest.Result{}
+				}
+				now := time.Now()
+				return storagetest.Result{
+					Columns: []string{"task_id", "task_name", "status", "parent_id", "state", "iterations", "published_at", "started_at", "ended_at", "last_error", "node_id", "final", "done", "total", "message", "updated_at"},
+					Rows: [][]any{
+						{original.TaskID.String(), "mail", "failed", uuid.Nil.String(), parameters, int64(1), now, now, now, "boom", nil, true, nil, nil, nil, nil},
+					},
+				}
+			})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("tasks", map[string]*Task[any]{"mail": task})
+			c.Set("storage", storage)
+			c.Params = gin.Params{{Key: "id", Value: original.TaskID.String()}}
+			c.Request = httptest.NewRequest(http.MethodPost, "/executions/"+original.TaskID.String()+"/retry", nil)
+
+			PostExecutionRetry[any](c)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				if len(b.published()) != 0 {
+					t.Error("retry published")
+				}
+				return
+			}
+
+			msgs := b.published()
+			if len(msgs) != 1 {
+				t.Fatalf("published = %d, want 1", len(msgs))
+			}
+			retry, err := e.decode(msgs[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if retry.TaskID == original.TaskID {
+				t.Fatal("retry reuses the task id of the original execution")
+			}
+
+			params, err := e.resolveParameters(retry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params["body"] != body {
+				t.Errorf("parameters = %v", params)
+			}
+
+			// The retry carries its own blob, deleting it leaves the original one
+			if tt.offloaded {
+				if retry.Parameters[claimCheckParameter] != retry.TaskID.String() {
+					t.Fatalf("retry parameters = %v, want a blob of its own", retry.Parameters)
+				}
+				e.releaseParameters(retry)
+				if !blobs.has(original.TaskID.String()) {
+					t.Error("original blob deleted with the retry")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package zsched
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/auth"
+	"github.com/vlourme/zsched/pkg/broker"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// dispatchBatchSize is the number of executions written and published together by ExecuteBatch
+const dispatchBatchSize = 1000
+
+// ExecuteBatch executes the task once per parameters, the executions are written to the storage
+// and published in batches confirmed by the broker. It returns the number of dispatched
+// executions, the dispatch stops at the first error.
+func (t *Task[T]) ExecuteBatch(params iter.Seq[map[string]any], opts ...DispatchOption) (int, error) {
+	return t.dispatchBatch(context.Background(), params, opts...)
+}
+
+// dispatchBatch dispatches the executions of the parameters in batches
+func (t *Task[T]) dispatchBatch(ctx context.Context, params iter.Seq[map[string]any], opts ...DispatchOption) (int, error) {
+	dispatched := 0
+	states := make([]*State, 0, dispatchBatchSize)
+
+	flush := func() error {
+		if len(states) == 0 {
+			return nil
+		}
+		if err := t.executor.PublishBatch(ctx, t, states); err != nil {
+			return err
+		}
+		dispatched += len(states)
+		states = make([]*State, 0, dispatchBatchSize)
+		return nil
+	}
+
+	for p := range params {
+		state, err := t.newDispatchState(p, opts...)
+		if err != nil {
+			return dispatched, err
+		}
+		if state.IdempotencyKey != "" {
+			return dispatched, fmt.Errorf("%w: idempotency keys are not supported by batches", ErrInvalidDispatch)
+		}
+
+		states = append(states, state)
+		if len(states) >= dispatchBatchSize {
+			if err := flush(); err != nil {
+				return dispatched, err
+			}
+		}
+	}
+
+	return dispatched, flush()
+}
+
+// PublishBatch publishes executions with a single span, a batched write of the
+// executions and a batched publish, the trace context of ctx is propagated
+func (e *executor[T]) PublishBatch(ctx context.Context, task *Task[T], states []*State) (err error) {
+	span, traceContext := e.startPublishBatchSpan(ctx, task, len(states))
+	defer func() { endSpan(span, "", err) }()
+
+	now := time.Now()
+	msgs := make([]broker.Message, 0, len(states))
+	sent := make([]*State, 0, len(states))
+	writes := e.storage.NewBatch()
+
+	for _, state := range states {
+		state.ID = uuid.New()
+		state.TraceContext = traceContext
+
+		if err := e.offload(state); err != nil {
+			return err
+		}
+
+		if state.NotBefore.After(now) || e.outbox {
+			stored, err := e.store(state)
+			if err != nil {
+				return err
+			}
+
+			if state.NotBefore.After(now) {
+				err = writes.Add(insertDelayedQuery, stored.args(state.ID, task.Name(), state.NotBefore)...)
+			} else {
+				err = writes.Add(insertOutboxQuery, stored.args(state.ID, task.Name(), now)...)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		msg, err := e.newMessage(task.Name(), state)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+		sent = append(sent, state)
+	}
+
+	// Executions are written before being published, so consumers never overwrite
+	// their status with the pending one
+	if err := e.taskLogger.LogTasksBatch(task, states); err != nil {
+		return errors.Join(errors.New("failed to log executions"), err)
+	}
+
+	for _, state := range states {
+		if err := e.runBeforeExecuteHooks(task, state); err != nil {
+			log.Printf("failed to run before execute hooks: %v", err)
+		}
+	}
+
+	// Delayed executions and, with the outbox, every execution are written to the storage
+	if writes.Size() > 0 {
+		if err := writes.Execute(); err != nil {
+			err = errors.Join(errors.New("failed to write executions"), err)
+			e.failBatch(task, states, err)
+			return err
+		}
+		if e.outbox {
+			e.wakeRelay()
+		}
+	}
+
+	if err := e.sendMessages(ctx, msgs); err != nil {
+		e.failBatch(task, sent, err)
+		return err
+	}
+
+	for _, state := range states {
+		runHooks(e.hooks, func(h PublishHook) {
+			h.OnPublish(&ExecutionEvent{Task: task, State: state, Attempt: state.Iterations})
+		})
+	}
+
+	return nil
+}
+
+// failBatch marks the executions of a batch which could not be published as failed
+func (e *executor[T]) failBatch(task *Task[T], states []*State, err error) {
+	if len(states) == 0 {
+		return
+	}
+
+	failPublish(err, states...)
+	if err := e.taskLogger.LogTasksBatch(task, states); err != nil {
+		log.Printf("failed to log executions: %v", err)
+	}
+}
+
+// PostTaskBatch dispatches a task once per parameters of the body, either a JSON array or
+// newline delimited JSON objects. The parameters before an invalid one are dispatched.
+func PostTaskBatch[T any](c *gin.Context) {
+	tasks := c.MustGet("tasks").(map[string]*Task[T])
+	t, ok := tasks[c.Param("name")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return
+	}
+
+	if !authorize[T](c, auth.PermissionDispatch, t.Name()) {
+		return
+	}
+
+	reader := bufio.NewReader(c.Request.Body)
+	dec := json.NewDecoder(reader)
+
+	// A JSON array is read element by element, like the lines of NDJSON
+	if first, err := peekNonSpace(reader); err == nil && first == '[' {
+		if _, err := dec.Token(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	var decodeErr error
+	params := func(yield func(map[string]any) bool) {
+		for dec.More() {
+			p := make(map[string]any)
+			if err := dec.Decode(&p); err != nil {
+				decodeErr = err
+				return
+			}
+			if !yield(p) {
+				return
+			}
+		}
+	}
+
+	ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	dispatched, err := t.dispatchBatch(ctx, params)
+	if errors.Is(err, ErrInvalidDispatch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "dispatched": dispatched})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "dispatched": dispatched})
+		return
+	}
+	if decodeErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parameters: " + decodeErr.Error(), "dispatched": dispatched})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dispatched": dispatched})
+}
+
+// peekNonSpace returns the first byte of the reader which is not a whitespace, without consuming it
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package zsched
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestPostTaskBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		publishErr error
+		status     int
+		dispatched int
+		err        string
+	}{
+		{name: "ndjson", body: "{\"n\": 1}\n{\"n\": 2}\n{\"n\": 3}\n", status: http.StatusOK, dispatched: 3},
+		{name: "ndjson without a trailing newline", body: "{\"n\": 1}\n{\"n\": 2}", status: http.StatusOK, dispatched: 2},
+		{name: "array", body: `[{"n": 1}, {"n": 2}, {"n": 3}]`, status: http.StatusOK, dispatched: 3},
+		{name: "array after whitespace", body: "\n  [{\"n\": 1}]", status: http.StatusOK, dispatched: 1},
+		{name: "empty array", body: `[]`, status: http.StatusOK},
+		{name: "empty body", body: "", status: http.StatusOK},
+		{name: "invalid line", body: "{\"n\": 1}\n{\"n\": \n", status: http.StatusBadRequest, dispatched: 1, err: "Invalid parameters"},
+		{name: "element which is not an object", body: `[{"n": 1}, 2]`, status: http.StatusBadRequest, dispatched: 1, err: "Invalid parameters"},
+		{name: "broker failure", body: `[{"n": 1}]`, publishErr: errors.New("broker down"), status: http.StatusInternalServerError, err: "broker down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, b, _ := newTestExecutor(t)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+
+			task := NewTask("mail", func(*Context[any]) error { return nil })
+			task.executor = e
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("tasks", map[string]*Task[any]{"mail": task})
+			c.Params = gin.Params{{Key: "name", Value: "mail"}}
+			c.Request = httptest.NewRequest(http.MethodPost, "/tasks/mail/batch", strings.NewReader(tt.body))
+
+			PostTaskBatch[any](c)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			var res struct {
+				Dispatched int    `json:"dispatched"`
+				Error      string `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Dispatched != tt.dispatched {
+				t.Errorf("dispatched = %d, want %d", res.Dispatched, tt.dispatched)
+			}
+			if !strings.Contains(res.Error, tt.err) || (tt.err == "" && res.Error != "") {
+				t.Errorf("error = %q, want %q", res.Error, tt.err)
+			}
+			if tt.publishErr == nil && len(b.published()) != tt.dispatched {
+				t.Errorf("published = %d, want %d", len(b.published()), tt.dispatched)
+			}
+		})
+	}
+}
+
+func TestPostTaskBatchNotFound(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("tasks", map[string]*Task[any]{})
+	c.Params = gin.Params{{Key: "name", Value: "mail"}}
+	c.Request = httptest.NewRequest(http.MethodPost, "/tasks/mail/batch", strings.NewReader(`[]`))
+
+	PostTaskBatch[any](c)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPublishBatchFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		outbox     bool
+		publishErr error
+		failQuery  string
+		failed     int
+	}{
+		{name: "sent"},
+		{name: "broker failure", publishErr: errors.New("broker down"), failed: 3},
+		{name: "outbox write failure", outbox: true, failQuery: "INSERT INTO outbox", failed: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, b, s := newTestExecutor(t)
+			e.outbox = tt.outbox
+			e.outboxWake = make(chan struct{}, 1)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+			s.SetHandler(func(q storagetest.Query) storagetest.Result {
+				if tt.failQuery != "" && q.Contains(tt.failQuery) {
+					return storagetest.Result{Err: errors.New("storage down")}
+				}
+				return storagetest.Result{}
+			})
+
+			task := NewTask("mail", func(*Context[any]) error { return nil })
+			states := []*State{newState(nil), newState(nil), newState(nil)}
+
+			err := e.PublishBatch(context.Background(), task, states)
+			if (err != nil) != (tt.failed > 0) {
+				t.Fatalf("err = %v", err)
+			}
+
+			// Rows are written pending, then failed if the batch is not published
+			failed := 0
+			for _, q := range s.Find("INSERT INTO tasks") {
+				if q.Args[1] == StatusFailed {
+					failed++
+					if !q.Args[11].(bool) || !strings.Contains(q.Args[9].(string), "failed to publish") {
+						t.Errorf("args = %v, want a final row with the publish error", q.Args)
+					}
+				}
+			}
+			if failed != tt.failed {
+				t.Errorf("failed rows = %d, want %d", failed, tt.failed)
+			}
+		})
+	}
+}
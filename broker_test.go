@@ -0,0 +1,87 @@
+package zsched
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vlourme/zsched/pkg/broker"
+)
+
+// fakeBroker records the published messages, consumers are fed with deliver
+type fakeBroker struct {
+	mu        sync.Mutex
+	messages  []broker.Message
+	handlers  map[string]func(broker.Message) error
+	publishFn func(broker.Message) error
+	pingErr   error
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{handlers: make(map[string]func(broker.Message) error)}
+}
+
+func (b *fakeBroker) Publish(body []byte, routingKey ...string) error {
+	msg := broker.Message{Body: body}
+	if len(routingKey) > 0 {
+		msg.Queue = routingKey[0]
+	}
+	return b.PublishMessage(msg)
+}
+
+func (b *fakeBroker) PublishMessage(msg broker.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.publishFn != nil {
+		if err := b.publishFn(msg); err != nil {
+			return err
+		}
+	}
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+func (b *fakeBroker) PublishBatch(_ context.Context, msgs []broker.Message) error {
+	for _, msg := range msgs {
+		if err := b.PublishMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *fakeBroker) Consume(queue string, autoAck bool, concurrency int, handler func(body []byte) error) error {
+	return b.ConsumeMessages(queue, autoAck, concurrency, func(msg broker.Message) error {
+		return handler(msg.Body)
+	})
+}
+
+func (b *fakeBroker) ConsumeMessages(queue string, _ bool, _ int, handler func(broker.Message) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[queue] = handler
+	return nil
+}
+
+// deliver delivers a message to the consumer of its queue and returns the result of the handler
+func (b *fakeBroker) deliver(msg broker.Message) error {
+	b.mu.Lock()
+	handler := b.handlers[msg.Queue]
+	b.mu.Unlock()
+	return handler(msg)
+}
+
+// published returns the messages published so far
+func (b *fakeBroker) published() []broker.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]broker.Message(nil), b.messages...)
+}
+
+func (b *fakeBroker) Ping() error {
+	return b.pingErr
+}
+
+func (b *fakeBroker) Close() error {
+	return nil
+}
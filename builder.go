@@ -1,13 +1,21 @@
 package zsched
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/robfig/cron/v3"
+	"github.com/vlourme/zsched/pkg/auth"
+	"github.com/vlourme/zsched/pkg/blob"
 	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/codec"
 	"github.com/vlourme/zsched/pkg/logger"
 	"github.com/vlourme/zsched/pkg/storage"
+	"github.com/vlourme/zsched/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // builder is the builder for the engine
@@ -25,6 +33,15 @@ func NewBuilder[T any](userContext T) *builder[T] {
 			wg:          &sync.WaitGroup{},
 			cron:        cron.New(cron.WithSeconds()),
 			hooks:       make([]Hook, 0),
+			events:      NewEventBus(),
+
+			nodeID:            uuid.NewString(),
+			heartbeatInterval: defaultHeartbeatInterval,
+			reaperTimeout:     defaultReaperTimeout,
+
+			resources: make(map[string]*Resource),
+
+			codec: codec.JSON(),
 		},
 	}
 }
@@ -36,8 +53,8 @@ func (b *builder[T]) WithBroker(broker broker.Broker) *builder[T] {
 }
 
 // WithRabbitMQBroker sets the RabbitMQ broker for the engine
-func (b *builder[T]) WithRabbitMQBroker(url string) *builder[T] {
-	broker, err := broker.NewRabbitMQBroker(url)
+func (b *builder[T]) WithRabbitMQBroker(url string, opts ...func(*broker.RabbitMQBroker)) *builder[T] {
+	broker, err := broker.NewRabbitMQBroker(url, opts...)
 	if err != nil {
 		b.err = err
 		return b
@@ -68,9 +85,22 @@ func (b *builder[T]) WithHooks(hooks ...Hook) *builder[T] {
 	return b
 }
 
-// WithLogger sets the logger for the engine
-func (b *builder[T]) WithLogger(logger logger.Logger) *builder[T] {
-	b.engine.logger = logger
+// WithMiddleware adds global middlewares, wrapping the action of every task
+func (b *builder[T]) WithMiddleware(middlewares ...Middleware[T]) *builder[T] {
+	b.engine.middlewares = append(b.engine.middlewares, middlewares...)
+	return b
+}
+
+// WithLogger sets the logger for the engine, a logger.Logger or
+// a logrus entry or logger, wrapped with logger.NewLogrusLogger
+func (b *builder[T]) WithLogger(l logger.Compatible) *builder[T] {
+	adapted, err := logger.Adapt(l)
+	if err != nil {
+		b.err = err
+		return b
+	}
+
+	b.engine.logger = adapted
 	return b
 }
 
@@ -79,6 +109,106 @@ func (b *builder[T]) WithAPI(address string) *builder[T] {
 	return b
 }
 
+// WithAuth enables authentication on the API, requests are authenticated by
+// the first authenticator recognizing their credentials
+func (b *builder[T]) WithAuth(authenticators ...auth.Authenticator) *builder[T] {
+	b.engine.authenticators = append(b.engine.authenticators, authenticators...)
+	return b
+}
+
+// WithTracing exports traces of publishes and executions as configured,
+// the trace context is propagated through the broker
+func (b *builder[T]) WithTracing(config tracing.Config) *builder[T] {
+	tp, err := tracing.NewTracerProvider(context.Background(), config)
+	if err != nil {
+		b.err = err
+		return b
+	}
+
+	b.engine.tracerShutdown = tp.Shutdown
+	return b.WithTracerProvider(tp)
+}
+
+// WithTracerProvider sets the tracer provider for the engine,
+// default is the global provider of otel
+func (b *builder[T]) WithTracerProvider(tp trace.TracerProvider) *builder[T] {
+	b.engine.tracerProvider = tp
+	return b
+}
+
+// WithRoles sets the roles of the engine, default is every role. Tasks are
+// registered on every role, so any engine can dispatch them.
+func (b *builder[T]) WithRoles(roles ...Role) *builder[T] {
+	b.engine.roles = roles
+	return b
+}
+
+// WithWorkerTasks restricts the tasks consumed by the engine to the given names,
+// combined with WithWorkerTags a task is consumed if it matches either
+func (b *builder[T]) WithWorkerTasks(names ...string) *builder[T] {
+	b.engine.workerTasks = append(b.engine.workerTasks, names...)
+	return b
+}
+
+// WithWorkerTags restricts the tasks consumed by the engine to the tasks with one of the tags
+func (b *builder[T]) WithWorkerTags(tags ...string) *builder[T] {
+	b.engine.workerTags = append(b.engine.workerTags, tags...)
+	return b
+}
+
+// WithHeartbeat sets the interval of the heartbeats of the node and of its running executions,
+// default is 10 seconds
+func (b *builder[T]) WithHeartbeat(interval time.Duration) *builder[T] {
+	b.engine.heartbeatInterval = interval
+	return b
+}
+
+// WithReaper sets the time without heartbeat after which a running execution is marked as lost,
+// default is 1 minute and zero disables the reaper. The messages of tasks with retries are
+// acknowledged once the attempt ends, so the broker delivers lost executions again. Tasks
+// without retries acknowledge their messages on delivery, their lost executions are
+// dispatched again only when redispatch is set.
+func (b *builder[T]) WithReaper(timeout time.Duration, redispatch bool) *builder[T] {
+	b.engine.reaperTimeout = timeout
+	b.engine.reaperRedispatch = redispatch
+	return b
+}
+
+// WithCodec sets the codec of the messages published to the broker, default is JSON.
+// Messages are tagged with their codec, so every node decodes them whatever its own codec.
+func (b *builder[T]) WithCodec(c codec.Codec) *builder[T] {
+	b.engine.codec = c
+	return b
+}
+
+// WithCompression compresses the messages larger than the threshold, in bytes
+func (b *builder[T]) WithCompression(compression codec.Compression, threshold int) *builder[T] {
+	b.engine.compression = compression
+	b.engine.compressionThreshold = threshold
+	return b
+}
+
+// WithClaimCheck stores the parameters larger than the threshold, in bytes, in the blob store,
+// the messages and the tasks table carry a reference resolved before the action runs.
+// Blobs are deleted once the execution succeeds or expires, or after the retention, default is
+// a week, it must exceed the retries. Dispatches due after the retention are rejected.
+func (b *builder[T]) WithClaimCheck(store blob.Store, threshold int, retention ...time.Duration) *builder[T] {
+	b.engine.blobs = store
+	b.engine.claimCheckThreshold = threshold
+	b.engine.claimCheckRetention = defaultClaimCheckRetention
+	if len(retention) > 0 {
+		b.engine.claimCheckRetention = retention[0]
+	}
+	return b
+}
+
+// WithOutbox writes the dispatches to the outbox table of the storage instead of publishing them,
+// a relay on every node publishes them to the broker at least once and marks them sent
+func (b *builder[T]) WithOutbox() *builder[T] {
+	b.engine.outbox = true
+	return b
+}
+
 // Build builds the engine
 func (b *builder[T]) Build() (*Engine[T], error) {
 	if b.err != nil {
@@ -93,10 +223,16 @@ func (b *builder[T]) Build() (*Engine[T], error) {
 		return nil, errors.New("storage is required")
 	}
 
+	if b.engine.reaperTimeout > 0 && b.engine.reaperTimeout <= b.engine.heartbeatInterval {
+		return nil, errors.New("reaper timeout must be greater than the heartbeat interval")
+	}
+
 	if b.engine.logger == nil {
 		b.engine.logger = logger.NewLogger(b.engine.storage)
 	}
 
+	b.engine.hooks = append([]Hook{b.engine.events}, b.engine.hooks...)
+
 	for _, hook := range b.engine.hooks {
 		if err := hook.Initialize(b.engine.storage); err != nil {
 			return nil, errors.New("failed to initialize hook: " + err.Error())
@@ -0,0 +1,129 @@
+package zsched
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vlourme/zsched/pkg/blob"
+	"github.com/vlourme/zsched/pkg/codec"
+)
+
+const (
+	// claimCheckParameter is the parameter holding the key of offloaded parameters
+	claimCheckParameter = "$claim_check"
+
+	// claimCheckCodecParameter is the parameter holding the codec of offloaded parameters
+	claimCheckCodecParameter = "$codec"
+
+	// defaultClaimCheckRetention is the default time blobs are kept
+	defaultClaimCheckRetention = 7 * 24 * time.Hour
+
+	// blobCleanupInterval is the interval between two cleanups of the expired blobs
+	blobCleanupInterval = time.Hour
+)
+
+// offload stores the parameters of an execution in the blob store if they are larger than
+// the threshold, the execution then carries a reference to them
+func (e *executor[T]) offload(s *State) error {
+	if e.blobs == nil || len(s.Parameters) == 0 {
+		return nil
+	}
+	if _, ok := s.Parameters[claimCheckParameter]; ok {
+		return nil
+	}
+
+	data, err := e.codec.Marshal(s.Parameters)
+	if err != nil {
+		return errors.Join(errors.New("failed to encode parameters"), err)
+	}
+	if len(data) <= e.claimCheckThreshold {
+		return nil
+	}
+
+	// The blob would be cleaned up before the execution is due
+	if s.NotBefore.After(time.Now().Add(e.claimCheckRetention)) {
+		return fmt.Errorf("%w: execution is due after the retention of its offloaded parameters", ErrInvalidDispatch)
+	}
+
+	key := s.TaskID.String()
+	if err := e.blobs.Put(key, data); err != nil {
+		return errors.Join(errors.New("failed to offload parameters"), err)
+	}
+
+	s.Parameters = map[string]any{
+		claimCheckParameter:      key,
+		claimCheckCodecParameter: e.codec.Name(),
+	}
+
+	return nil
+}
+
+// resolveParameters returns the parameters of an execution, loaded from the blob store if offloaded
+func (e *executor[T]) resolveParameters(s *State) (map[string]any, error) {
+	key, ok := s.Parameters[claimCheckParameter].(string)
+	if !ok {
+		return s.Parameters, nil
+	}
+	if e.blobs == nil {
+		return nil, errors.New("parameters are offloaded but no blob store is configured")
+	}
+
+	// Blobs past the retention are gone, retrying cannot bring them back
+	data, err := e.blobs.Get(key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, Permanent(errors.Join(fmt.Errorf("failed to load parameters %s", key), err))
+	}
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to load parameters %s", key), err)
+	}
+
+	name, _ := s.Parameters[claimCheckCodecParameter].(string)
+	c, ok := codec.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %s", name)
+	}
+
+	parameters := make(map[string]any)
+	if err := c.Unmarshal(data, &parameters); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to decode parameters %s", key), err)
+	}
+
+	return parameters, nil
+}
+
+// releaseParameters deletes the offloaded parameters of an execution which succeeded or
+// expired, the retries of the execution share them until then. The parameters of failed
+// and lost executions are kept until the retention, so they can be retried through the API.
+func (e *executor[T]) releaseParameters(s *State) {
+	key, ok := s.Parameters[claimCheckParameter].(string)
+	if !ok || e.blobs == nil {
+		return
+	}
+
+	if err := e.blobs.Delete(key); err != nil {
+		log.Printf("failed to delete offloaded parameters %s: %v", key, err)
+	}
+}
+
+// cleanBlobs deletes the blobs older than the retention until the engine stops
+func (e *Engine[T]) cleanBlobs() {
+	ticker := time.NewTicker(blobCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := e.blobs.DeleteBefore(time.Now().Add(-e.claimCheckRetention))
+		if err != nil {
+			e.logger.WithError(err).Error("failed to clean up blobs")
+		} else if deleted > 0 {
+			e.logger.WithField("deleted", deleted).Info("cleaned up blobs")
+		}
+
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
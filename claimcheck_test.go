@@ -0,0 +1,225 @@
+package zsched
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/blob"
+	"github.com/vlourme/zsched/pkg/broker"
+)
+
+// memoryBlobs is an in-memory blob store
+type memoryBlobs struct {
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	getErr error
+}
+
+func newMemoryBlobs() *memoryBlobs {
+	return &memoryBlobs{blobs: map[string][]byte{}}
+}
+
+func (m *memoryBlobs) Put(key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = data
+	return nil
+}
+
+func (m *memoryBlobs) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	data, ok := m.blobs[key]
+	if !ok {
+		return nil, blob.ErrNotFound
+	}
+	return data, nil
+}
+
+func (m *memoryBlobs) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *memoryBlobs) DeleteBefore(time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *memoryBlobs) has(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.blobs[key]
+	return ok
+}
+
+// newClaimCheckExecutor creates a test executor offloading the parameters above 16 bytes
+func newClaimCheckExecutor(t *testing.T, hooks ...Hook) (*executor[any], *fakeBroker, *memoryBlobs) {
+	t.Helper()
+
+	e, b, _ := newTestExecutor(t, hooks...)
+	blobs := newMemoryBlobs()
+	e.blobs = blobs
+	e.claimCheckThreshold = 16
+	e.claimCheckRetention = 24 * time.Hour
+
+	return e, b, blobs
+}
+
+func TestOffload(t *testing.T) {
+	large := map[string]any{"body": strings.Repeat("a", 100)}
+
+	tests := []struct {
+		name      string
+		params    map[string]any
+		notBefore time.Duration
+		offloaded bool
+		err       error
+	}{
+		{name: "small parameters", params: map[string]any{"n": 1}},
+		{name: "large parameters", params: large, offloaded: true},
+		{name: "due within the retention", params: large, notBefore: time.Hour, offloaded: true},
+		{name: "due after the retention", params: large, notBefore: 48 * time.Hour, err: ErrInvalidDispatch},
+		{name: "small parameters due after the retention", params: map[string]any{"n": 1}, notBefore: 48 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, blobs := newClaimCheckExecutor(t)
+
+			s := &State{TaskID: uuid.New(), Parameters: tt.params}
+			if tt.notBefore > 0 {
+				s.NotBefore = time.Now().Add(tt.notBefore)
+			}
+
+			err := e.offload(s)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			_, offloaded := s.Parameters[claimCheckParameter]
+			if offloaded != tt.offloaded || blobs.has(s.TaskID.String()) != tt.offloaded {
+				t.Errorf("offloaded = %v, want %v", offloaded, tt.offloaded)
+			}
+		})
+	}
+}
+
+func TestResolveParameters(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    bool
+		codec     string
+		getErr    error
+		err       string
+		permanent bool
+	}{
+		{name: "inline parameters"},
+		{name: "offloaded parameters", stored: true, codec: "json"},
+		{name: "cleaned up blob", codec: "json", err: "blob not found", permanent: true},
+		{name: "store failure", stored: true, codec: "json", getErr: errors.New("storage down"), err: "storage down"},
+		{name: "unknown codec", stored: true, codec: "custom", err: "unknown codec custom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, blobs := newClaimCheckExecutor(t)
+			blobs.getErr = tt.getErr
+
+			s := &State{TaskID: uuid.New(), Parameters: map[string]any{"to": "a@b.c"}}
+			if tt.codec != "" {
+				if tt.stored {
+					blobs.Put(s.TaskID.String(), []byte(`{"to": "a@b.c"}`))
+				}
+				s.Parameters = map[string]any{claimCheckParameter: s.TaskID.String(), claimCheckCodecParameter: tt.codec}
+			}
+
+			params, err := e.resolveParameters(s)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				if IsPermanent(err) != tt.permanent {
+					t.Errorf("permanent = %v, want %v", IsPermanent(err), tt.permanent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params["to"] != "a@b.c" {
+				t.Errorf("params = %v", params)
+			}
+		})
+	}
+}
+
+func TestReleaseParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     func(*Context[any]) error
+		maxRetries int
+		publishErr error
+		cleanedUp  bool
+		published  int
+		released   bool
+	}{
+		{name: "success", action: func(*Context[any]) error { return nil }, released: true},
+		{name: "retried", action: func(*Context[any]) error { return errors.New("boom") }, maxRetries: 3, published: 1},
+		{name: "cleaned up before the execution", action: func(*Context[any]) error { return nil }, maxRetries: 3, cleanedUp: true, released: true},
+		{name: "retries exhausted", action: func(*Context[any]) error { return errors.New("boom") }},
+		{name: "retry not published", action: func(*Context[any]) error { return errors.New("boom") }, maxRetries: 3, publishErr: errors.New("broker down")},
+		{name: "permanent", action: func(*Context[any]) error { return Permanent(errors.New("invalid")) }, maxRetries: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, b, blobs := newClaimCheckExecutor(t)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+
+			task := NewTask("mail", tt.action, WithMaxRetries(tt.maxRetries))
+			s := &State{ID: uuid.New(), TaskID: uuid.New(), Parameters: map[string]any{"body": strings.Repeat("a", 100)}}
+			if err := e.offload(s); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.cleanedUp {
+				blobs.Delete(s.TaskID.String())
+			}
+
+			e.execute(task, s)
+
+			// Executions whose parameters are gone are not retried
+			if got := len(b.published()); got != tt.published {
+				t.Errorf("published = %d, want %d", got, tt.published)
+			}
+			if released := !blobs.has(s.TaskID.String()); released != tt.released {
+				t.Errorf("released = %v, want %v", released, tt.released)
+			}
+		})
+	}
+}
+
+func TestKeepParametersOnPublishFailure(t *testing.T) {
+	e, b, blobs := newClaimCheckExecutor(t)
+	b.publishFn = func(broker.Message) error { return errors.New("broker down") }
+
+	task := NewTask("mail", func(*Context[any]) error { return nil })
+	s := newState(map[string]any{"body": strings.Repeat("a", 100)})
+
+	if err := e.Publish(t.Context(), task, s); err == nil {
+		t.Fatal("err = nil")
+	}
+	// The failed execution may be retried through the API
+	if !blobs.has(s.TaskID.String()) {
+		t.Error("offloaded parameters deleted after a failed publish")
+	}
+}
@@ -0,0 +1,52 @@
+package zsched
+
+import "time"
+
+// cleanupInterval is the interval between two cleanups of the tables without retention policy
+const cleanupInterval = time.Hour
+
+// cleanupQuery deletes the rows of a table past their retention
+type cleanupQuery struct {
+	table string
+	query string
+}
+
+// cleanupQueries is the cleanups run by the schedulers, the tables are kept
+// for a week like the tasks and logs hypertables
+var cleanupQueries = []cleanupQuery{
+	{table: "progress", query: `DELETE FROM progress WHERE updated_at < now() - INTERVAL '7 days'`},
+	{table: "checkpoints", query: `DELETE FROM checkpoints WHERE updated_at < now() - INTERVAL '7 days'`},
+	{table: "idempotency_keys", query: `DELETE FROM idempotency_keys WHERE created_at < now() - INTERVAL '7 days'`},
+	{table: "outbox", query: `DELETE FROM outbox WHERE sent_at < now() - INTERVAL '7 days'`},
+}
+
+// cleanTables runs the cleanup queries on start and every cleanupInterval until the engine stops
+func (e *Engine[T]) cleanTables() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		e.cleanup()
+
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanup runs the cleanup queries once
+func (e *Engine[T]) cleanup() {
+	for _, c := range cleanupQueries {
+		res, err := e.storage.Exec(c.query)
+		if err != nil {
+			e.logger.WithError(err).WithField("table", c.table).Error("failed to clean up table")
+			continue
+		}
+
+		if deleted, _ := res.RowsAffected(); deleted > 0 {
+			e.logger.WithFields(map[string]any{"table": c.table, "deleted": deleted}).Info("cleaned up table")
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package zsched
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/vlourme/zsched/pkg/logger"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestCleanup(t *testing.T) {
+	tests := []struct {
+		name   string
+		failed string
+	}{
+		{name: "every table"},
+		{name: "continues after a failure", failed: "progress"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := storagetest.New(func(q storagetest.Query) storagetest.Result {
+				if tt.failed != "" && q.Contains("DELETE FROM "+tt.failed+" ") {
+					return storagetest.Result{Err: errors.New("down")}
+				}
+				return storagetest.Result{RowsAffected: 1}
+			})
+
+			e := &Engine[any]{
+				storage: s,
+				logger:  logger.NewSlogLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+			}
+			e.cleanup()
+
+			for _, c := range cleanupQueries {
+				if len(s.Find("DELETE FROM "+c.table+" ")) != 1 {
+					t.Errorf("table %s was not cleaned up", c.table)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package zsched
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/codec"
+)
+
+// newMessage creates the message of an execution of a task, encoded with the codec of the engine
+func (e *executor[T]) newMessage(taskName string, state *State) (broker.Message, error) {
+	body, compression, err := e.encode(state)
+	if err != nil {
+		return broker.Message{}, err
+	}
+
+	headers := make(map[string]string, len(state.Headers)+2)
+	maps.Copy(headers, state.Headers)
+	headers[codec.HeaderCodec] = e.codec.Name()
+	if compression != codec.None {
+		headers[codec.HeaderCompression] = string(compression)
+	}
+
+	queue := taskName
+	if state.Queue != "" {
+		queue = state.Queue
+	}
+
+	return broker.Message{
+		Queue:         queue,
+		Body:          body,
+		Priority:      state.Priority,
+		MessageID:     state.ID.String(),
+		CorrelationID: state.CorrelationID,
+		Headers:       headers,
+		ContentType:   e.codec.ContentType(),
+	}, nil
+}
+
+// encode encodes an execution with the codec of the engine, compressed above the threshold
+func (e *executor[T]) encode(state *State) ([]byte, codec.Compression, error) {
+	body, err := e.codec.Marshal(state)
+	if err != nil {
+		return nil, codec.None, errors.Join(errors.New("failed to encode execution"), err)
+	}
+
+	compression := codec.None
+	if e.compression != codec.None && len(body) > e.compressionThreshold {
+		compression = e.compression
+		if body, err = codec.Compress(compression, body); err != nil {
+			return nil, codec.None, errors.Join(errors.New("failed to compress execution"), err)
+		}
+	}
+
+	return body, compression, nil
+}
+
+// storedState is an execution kept in the delayed, outbox and heartbeats tables,
+// encoded like the body of its message
+type storedState struct {
+	body        []byte
+	codec       string
+	compression codec.Compression
+}
+
+// store encodes an execution to keep it in the storage
+func (e *executor[T]) store(state *State) (storedState, error) {
+	body, compression, err := e.encode(state)
+	if err != nil {
+		return storedState{}, err
+	}
+
+	return storedState{body: body, codec: e.codec.Name(), compression: compression}, nil
+}
+
+// args returns the arguments of insertDelayedQuery and insertOutboxQuery
+func (s storedState) args(stateID uuid.UUID, taskName string, at time.Time) []any {
+	return []any{stateID, taskName, s.body, s.codec, string(s.compression), at}
+}
+
+// storedColumns selects the columns of a stored execution, in the order of scan
+const storedColumns = `state, body, COALESCE(codec, ''), COALESCE(compression, '')`
+
+// scan returns the destinations of storedColumns, the state column of the rows
+// written before the codecs is scanned into legacy
+func (s *storedState) scan(legacy *[]byte) []any {
+	return []any{legacy, &s.body, &s.codec, &s.compression}
+}
+
+// decodeStored decodes an execution kept in the storage, rows written before the
+// codecs have no body and keep the execution in JSON in their state column
+func decodeStored(legacy []byte, s storedState) (*State, error) {
+	if s.body == nil {
+		return deserializeState(legacy)
+	}
+
+	c, ok := codec.Lookup(s.codec)
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %s", s.codec)
+	}
+
+	return decodeBody(c, s.compression, s.body)
+}
+
+// decode decodes the execution of a message with the codec and compression of its headers,
+// untagged messages are published by older nodes in JSON or by brokers without headers
+func (e *executor[T]) decode(msg broker.Message) (*State, error) {
+	c := e.codec
+	if msg.ContentType == "application/json" {
+		c = codec.JSON()
+	}
+	if name, ok := msg.Headers[codec.HeaderCodec]; ok {
+		if c, ok = codec.Lookup(name); !ok {
+			return nil, fmt.Errorf("unknown codec %s", name)
+		}
+	}
+
+	compression := codec.Detect(msg.Body)
+	if name, ok := msg.Headers[codec.HeaderCompression]; ok {
+		compression = codec.Compression(name)
+	}
+
+	return decodeBody(c, compression, msg.Body)
+}
+
+// decodeBody decompresses and decodes the body of an execution
+func decodeBody(c codec.Codec, compression codec.Compression, data []byte) (*State, error) {
+	body, err := codec.Decompress(compression, data)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to decompress execution"), err)
+	}
+
+	var state State
+	if err := c.Unmarshal(body, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
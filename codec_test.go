@@ -0,0 +1,78 @@
+package zsched
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/codec"
+)
+
+func TestStoredState(t *testing.T) {
+	tests := []struct {
+		name        string
+		codec       codec.Codec
+		compression codec.Compression
+		compressed  bool
+	}{
+		{name: "json", codec: codec.JSON()},
+		{name: "msgpack", codec: codec.MessagePack()},
+		{name: "cbor compressed", codec: codec.CBOR(), compression: codec.Zstd, compressed: true},
+		{name: "json compressed", codec: codec.JSON(), compression: codec.Gzip, compressed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, _ := newTestExecutor(t)
+			e.codec = tt.codec
+			e.compression = tt.compression
+
+			state := &State{ID: uuid.New(), TaskID: uuid.New(), Parameters: map[string]any{"to": strings.Repeat("a", 100)}}
+			stored, err := e.store(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.codec != tt.codec.Name() || (stored.compression != codec.None) != tt.compressed {
+				t.Errorf("codec = %s, compression = %q", stored.codec, stored.compression)
+			}
+
+			decoded, err := decodeStored(nil, stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.ID != state.ID || decoded.TaskID != state.TaskID || decoded.Parameters["to"] != state.Parameters["to"] {
+				t.Errorf("decoded = %+v, want %+v", decoded, state)
+			}
+		})
+	}
+}
+
+func TestDecodeStored(t *testing.T) {
+	legacy, err := (&State{ID: uuid.New()}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		legacy []byte
+		stored storedState
+		err    string
+	}{
+		{name: "stored in JSON by older nodes", legacy: legacy},
+		{name: "unknown codec", stored: storedState{body: []byte{0x80}, codec: "custom"}, err: "unknown codec custom"},
+		{name: "invalid body", stored: storedState{body: []byte("{"), codec: "json"}, err: "unexpected end"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeStored(tt.legacy, tt.stored)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -1,27 +1,46 @@
 package zsched
 
 import (
-	"github.com/sirupsen/logrus"
+	"context"
+	"time"
+
 	"github.com/vlourme/zsched/pkg/logger"
+	"github.com/vlourme/zsched/pkg/storage"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Context is a temporary object into the task execution context
-// It allow logging, outputting values and accessing the user context
+// It allow logging, outputting values and accessing the user context.
+// It implements context.Context, it is done when the task times out or the engine stops.
 type Context[T any] struct {
 	logger.Logger
 	State
 
+	// Data is the fields of the logger, as on logrus entries
+	Data logger.Fields
+
+	ctx         context.Context
 	task        Task[T]
 	userContext T
+	storage     storage.Storage
+
+	// progress is the last reported progress, written at most once per second
+	progress          *Progress
+	progressWrittenAt time.Time
 }
 
-func newContext[T any](task *Task[T], state State, logger logger.Logger, userContext T) *Context[T] {
+func newContext[T any](ctx context.Context, task *Task[T], state State, logger logger.Logger, storage storage.Storage, userContext T) *Context[T] {
+	logger = logger.WithFields(map[string]any{
+		"scope":    task.Name(),
+		"state_id": state.ID,
+		"task_id":  state.TaskID,
+	})
+
 	return &Context[T]{
-		Logger: logger.WithFields(logrus.Fields{
-			"scope":    task.Name(),
-			"state_id": state.ID,
-			"task_id":  state.TaskID,
-		}),
+		ctx:         ctx,
+		storage:     storage,
+		Logger:      logger,
+		Data:        logger.Fields(),
 		userContext: userContext,
 		task:        *task,
 		State:       state,
@@ -30,7 +49,12 @@ func newContext[T any](task *Task[T], state State, logger logger.Logger, userCon
 
 // Execute starts the same task with given parameters
 func (c *Context[T]) Execute(params ...map[string]any) error {
-	return c.task.Execute(params...)
+	return c.task.ExecuteContext(c, params...)
+}
+
+// Span returns the span tracing the current attempt
+func (c *Context[T]) Span() trace.Span {
+	return trace.SpanFromContext(c.ctx)
 }
 
 // Push pushes a value to the collector
@@ -38,6 +62,26 @@ func (c *Context[T]) Push(value any) {
 	c.task.Collector().Push(value)
 }
 
+// Deadline implements context.Context
+func (c *Context[T]) Deadline() (time.Time, bool) {
+	return c.ctx.Deadline()
+}
+
+// Done implements context.Context
+func (c *Context[T]) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Err implements context.Context
+func (c *Context[T]) Err() error {
+	return c.ctx.Err()
+}
+
+// Value implements context.Context
+func (c *Context[T]) Value(key any) any {
+	return c.ctx.Value(key)
+}
+
 // UserContext returns the user context of the task
 func (c *Context[T]) UserContext() T {
 	return c.userContext
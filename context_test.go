@@ -0,0 +1,109 @@
+package zsched
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/vlourme/zsched/pkg/logger"
+)
+
+func TestWithLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		logger logger.Compatible
+	}{
+		{name: "logrus entry", logger: logrus.NewEntry(logrus.New())},
+		{name: "logrus logger", logger: logrus.New()},
+		{name: "logger", logger: logger.NewLogrusLogger(logrus.NewEntry(logrus.New()))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder(&struct{}{}).WithLogger(tt.logger)
+			if b.err != nil {
+				t.Fatal(b.err)
+			}
+			if b.engine.logger == nil {
+				t.Error("logger is not set")
+			}
+		})
+	}
+}
+
+func TestContextData(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+
+	task := NewTask("mail", func(*Context[any]) error { return nil })
+	state := State{ID: uuid.New(), TaskID: uuid.New()}
+	ctx := newContext(context.Background(), task, state, logger.NewLogrusLogger(logrus.NewEntry(l)), nil, any(nil))
+
+	if ctx.Data["task_id"] != state.TaskID || ctx.Data["state_id"] != state.ID {
+		t.Fatalf("data = %v", ctx.Data)
+	}
+
+	// Fields added to the data are logged, as with the logrus entry embedded before
+	ctx.Data["tenant"] = "acme"
+	if ctx.Fields()["tenant"] != "acme" {
+		t.Error("data is not shared with the logger")
+	}
+}
+
+func TestMiddlewareData(t *testing.T) {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+
+	tenant := func(next taskAction[any]) taskAction[any] {
+		return func(ctx *Context[any]) error {
+			ctx.Logger = ctx.WithField("tenant", "acme")
+			return next(ctx)
+		}
+	}
+	region := func(next taskAction[any]) taskAction[any] {
+		return func(ctx *Context[any]) error {
+			if ctx.Data["tenant"] != "acme" {
+				t.Errorf("middleware data = %v, want the tenant of the outer middleware", ctx.Data)
+			}
+			ctx.Logger = ctx.WithField("region", "eu")
+			return next(ctx)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		middlewares []Middleware[any]
+		want        map[string]any
+	}{
+		{name: "no middleware", want: map[string]any{}},
+		{name: "logger replaced", middlewares: []Middleware[any]{tenant}, want: map[string]any{"tenant": "acme"}},
+		{name: "logger replaced twice", middlewares: []Middleware[any]{tenant, region}, want: map[string]any{"tenant": "acme", "region": "eu"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := NewTask("mail", func(*Context[any]) error { return nil })
+			ctx := newContext(context.Background(), task, State{ID: uuid.New(), TaskID: uuid.New()}, logger.NewLogrusLogger(logrus.NewEntry(l)), nil, any(nil))
+
+			var data logger.Fields
+			action := chain(func(ctx *Context[any]) error {
+				data = ctx.Data
+				return nil
+			}, tt.middlewares...)
+			if err := action(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if data["task_id"] == nil {
+				t.Errorf("data = %v, want the fields of the execution", data)
+			}
+			for k, v := range tt.want {
+				if data[k] != v {
+					t.Errorf("data[%s] = %v, want %v", k, data[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+const (
+	// delayedPollInterval is the interval between two checks of the due delayed executions
+	delayedPollInterval = time.Second
+
+	// delayedBatchSize is the maximum number of delayed executions published per check
+	delayedBatchSize = 500
+)
+
+// createDispatchTables creates the tables of the delayed executions and of the idempotency keys,
+// idempotency keys are deleted after a week by cleanTables
+func createDispatchTables(storage storage.Storage) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS delayed_executions (
+			state_id UUID PRIMARY KEY,
+			task_name VARCHAR(128),
+			state JSONB,
+			body BYTEA,
+			codec VARCHAR(32),
+			compression VARCHAR(16),
+			due_at TIMESTAMPTZ
+		)`,
+		`ALTER TABLE delayed_executions
+			ADD COLUMN IF NOT EXISTS body BYTEA,
+			ADD COLUMN IF NOT EXISTS codec VARCHAR(32),
+			ADD COLUMN IF NOT EXISTS compression VARCHAR(16)`,
+		`CREATE INDEX IF NOT EXISTS delayed_executions_due_at_idx ON delayed_executions (due_at)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			task_name VARCHAR(128),
+			key VARCHAR(255),
+			task_id UUID,
+			created_at TIMESTAMPTZ,
+			PRIMARY KEY (task_name, key)
+		)`,
+	}
+
+	for _, query := range queries {
+		if _, err := storage.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertDelayedQuery inserts a delayed execution
+const insertDelayedQuery = `INSERT INTO delayed_executions (state_id, task_name, body, codec, compression, due_at) VALUES ($1, $2, $3, $4, $5, $6)`
+
+// delay keeps an execution in the storage until it is due
+func (e *executor[T]) delay(task *Task[T], s *State) error {
+	stored, err := e.store(s)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.storage.Exec(insertDelayedQuery, stored.args(s.ID, task.Name(), s.NotBefore)...)
+	if err != nil {
+		return errors.Join(errors.New("failed to delay execution"), err)
+	}
+
+	return nil
+}
+
+// dispatchDelayed publishes the due delayed executions until the engine stops
+func (e *Engine[T]) dispatchDelayed() {
+	ticker := time.NewTicker(delayedPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Full batches are followed by another one right away
+		for {
+			published, err := e.publishDue()
+			if err != nil {
+				e.logger.WithError(err).Error("failed to publish delayed executions")
+				break
+			}
+			if published < delayedBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// publishDue publishes a batch of due delayed executions and deletes them in the same
+// transaction. Nodes claim distinct rows, and rows are only deleted once the broker
+// accepted them, an execution published by a node that failed to commit is published
+// again, so delivery is at least once.
+func (e *Engine[T]) publishDue() (int, error) {
+	conn, err := e.storage.Connection()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(e.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		e.ctx,
+		`
+		SELECT state_id, task_name, `+storedColumns+` FROM delayed_executions
+		WHERE due_at <= $1
+		ORDER BY due_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+		`,
+		time.Now(),
+		delayedBatchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, 0, delayedBatchSize)
+	msgs := make([]broker.Message, 0, delayedBatchSize)
+	for rows.Next() {
+		var (
+			stateID  string
+			taskName string
+			legacy   []byte
+			stored   storedState
+		)
+		if err := rows.Scan(append([]any{&stateID, &taskName}, stored.scan(&legacy)...)...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		// Executions that cannot be published are deleted too, so they do not block the table
+		ids = append(ids, stateID)
+
+		if _, ok := e.tasks[taskName]; !ok {
+			e.logger.WithField("task_name", taskName).Error("dropped delayed execution of unknown task")
+			continue
+		}
+
+		s, err := decodeStored(legacy, stored)
+		if err == nil {
+			var msg broker.Message
+			if msg, err = e.executor.newMessage(taskName, s); err == nil {
+				msgs = append(msgs, msg)
+				continue
+			}
+		}
+		e.logger.WithError(err).WithField("task_name", taskName).Error("dropped invalid delayed execution")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, outboxPublishTimeout)
+	defer cancel()
+	if err := e.executor.sendMessages(ctx, msgs); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(e.ctx, `DELETE FROM delayed_executions WHERE state_id = ANY($1::uuid[])`, ids); err != nil {
+		return 0, err
+	}
+
+	return len(ids), tx.Commit()
+}
+
+// claimIdempotencyKey records the key of a dispatch, it returns false and the id of the
+// first execution if the key has already been dispatched
+func (e *executor[T]) claimIdempotencyKey(task *Task[T], s *State) (uuid.UUID, bool, error) {
+	res, err := e.storage.Exec(
+		`
+		INSERT INTO idempotency_keys (task_name, key, task_id, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (task_name, key) DO NOTHING
+		`,
+		task.Name(),
+		s.IdempotencyKey,
+		s.TaskID,
+		time.Now(),
+	)
+	if err != nil {
+		return uuid.Nil, false, errors.Join(errors.New("failed to claim idempotency key"), err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return s.TaskID, true, nil
+	}
+
+	var taskID uuid.UUID
+	err = e.storage.QueryRow(
+		`SELECT task_id FROM idempotency_keys WHERE task_name = $1 AND key = $2`,
+		task.Name(),
+		s.IdempotencyKey,
+	).Scan(&taskID)
+	if err != nil {
+		return uuid.Nil, false, errors.Join(errors.New("failed to read idempotency key"), err)
+	}
+
+	return taskID, false, nil
+}
+
+// releaseIdempotencyKey forgets the key of a dispatch that failed to be published
+func (e *executor[T]) releaseIdempotencyKey(task *Task[T], s *State) error {
+	_, err := e.storage.Exec(
+		`DELETE FROM idempotency_keys WHERE task_name = $1 AND key = $2 AND task_id = $3`,
+		task.Name(),
+		s.IdempotencyKey,
+		s.TaskID,
+	)
+	return err
+}
@@ -0,0 +1,123 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/codec"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestPublishDue(t *testing.T) {
+	tests := []struct {
+		name       string
+		tasks      []string
+		codec      codec.Codec
+		legacy     bool
+		publishErr error
+		published  int
+		deleted    int
+		end        string
+		err        bool
+	}{
+		{name: "published then deleted", tasks: []string{"mail", "mail"}, published: 2, deleted: 2, end: "COMMIT"},
+		{name: "kept when the broker fails", tasks: []string{"mail"}, publishErr: errors.New("broker down"), end: "ROLLBACK", err: true},
+		{name: "stored with another codec", tasks: []string{"mail"}, codec: codec.MessagePack(), published: 1, deleted: 1, end: "COMMIT"},
+		{name: "stored in JSON by older nodes", tasks: []string{"mail"}, legacy: true, published: 1, deleted: 1, end: "COMMIT"},
+		{name: "unknown task deleted", tasks: []string{"mail", "unknown"}, published: 1, deleted: 2, end: "COMMIT"},
+		{name: "nothing due", end: "ROLLBACK"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor, b, s := newTestExecutor(t)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+			if tt.codec != nil {
+				executor.codec = tt.codec
+			}
+
+			rows := make([][]any, len(tt.tasks))
+			for i, name := range tt.tasks {
+				rows[i] = append([]any{uuid.NewString(), name}, storedRow(t, executor, tt.legacy)...)
+			}
+			s.SetHandler(func(q storagetest.Query) storagetest.Result {
+				if q.Contains("FROM delayed_executions") && q.Contains("FOR UPDATE SKIP LOCKED") {
+					return storagetest.Result{Columns: []string{"state_id", "task_name", "state", "body", "codec", "compression"}, Rows: rows}
+				}
+				return storagetest.Result{}
+			})
+
+			executor.codec = codec.JSON()
+			e := &Engine[any]{
+				ctx:      context.Background(),
+				storage:  s,
+				broker:   b,
+				logger:   executor.logger,
+				executor: executor,
+				tasks:    map[string]*Task[any]{"mail": NewTask("mail", func(*Context[any]) error { return nil })},
+			}
+
+			published, err := e.publishDue()
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if published != tt.deleted {
+				t.Errorf("published = %d, want %d", published, tt.deleted)
+			}
+			if got := len(b.published()); got != tt.published {
+				t.Errorf("messages = %d, want %d", got, tt.published)
+			}
+
+			deletes := s.Find("DELETE FROM delayed_executions")
+			// Due executions are published with the codec of the node, whatever they are stored with
+			for _, msg := range b.published() {
+				if msg.Headers[codec.HeaderCodec] != codec.JSON().Name() {
+					t.Errorf("codec = %s, want json", msg.Headers[codec.HeaderCodec])
+				}
+			}
+
+			if tt.deleted > 0 {
+				if len(deletes) != 1 || len(deletes[0].Args[0].([]string)) != tt.deleted {
+					t.Errorf("deletes = %v, want %d rows", deletes, tt.deleted)
+				}
+			} else if len(deletes) != 0 {
+				t.Errorf("deletes = %v, want none", deletes)
+			}
+
+			// Rows are deleted in the transaction that claimed them, after the publish
+			queries := s.Queries()
+			if last := queries[len(queries)-1].SQL; last != tt.end {
+				t.Errorf("last query = %s, want %s", last, tt.end)
+			}
+			if i := slices.IndexFunc(queries, func(q storagetest.Query) bool { return q.Contains("DELETE FROM") }); i >= 0 && queries[i+1].SQL != "COMMIT" {
+				t.Errorf("delete is followed by %s, want COMMIT", queries[i+1].SQL)
+			}
+		})
+	}
+}
+
+// storedRow returns the state, body, codec and compression columns of an execution
+// stored by the executor, or kept in JSON in the state column like older nodes did,
+// the codec and compression of older rows are coalesced to empty strings
+func storedRow(t *testing.T, e *executor[any], legacy bool) []any {
+	t.Helper()
+
+	state := &State{ID: uuid.New(), TaskID: uuid.New(), Parameters: map[string]any{"to": "a@b.c"}}
+	if legacy {
+		body, err := state.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []any{body, nil, "", ""}
+	}
+
+	stored, err := e.store(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []any{nil, stored.body, stored.codec, string(stored.compression)}
+}
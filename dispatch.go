@@ -0,0 +1,280 @@
+package zsched
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidDispatch is returned when the dispatch options of an execution are inconsistent
+var ErrInvalidDispatch = errors.New("invalid dispatch")
+
+// reservedParameterPrefix prefixes the parameters set by the engine, such as the claim check
+const reservedParameterPrefix = "$"
+
+// DispatchOption configures a single dispatch of a task
+type DispatchOption func(*State)
+
+// Priority sets the priority of the execution, higher priorities are consumed first.
+// Retries keep the priority, brokers without priorities ignore it.
+func Priority(priority uint8) DispatchOption {
+	return func(s *State) {
+		s.Priority = priority
+	}
+}
+
+// Delay publishes the execution once the delay has elapsed
+func Delay(delay time.Duration) DispatchOption {
+	return func(s *State) {
+		s.NotBefore = time.Now().Add(delay)
+	}
+}
+
+// ETA publishes the execution at the given time
+func ETA(eta time.Time) DispatchOption {
+	return func(s *State) {
+		s.NotBefore = eta
+	}
+}
+
+// TTL drops the execution unexecuted if it is not consumed within the ttl after the dispatch
+func TTL(ttl time.Duration) DispatchOption {
+	return func(s *State) {
+		s.ExpiresAt = time.Now().Add(ttl)
+	}
+}
+
+// ExpiresAt drops the execution unexecuted if it is not consumed before the given time
+func ExpiresAt(expiresAt time.Time) DispatchOption {
+	return func(s *State) {
+		s.ExpiresAt = expiresAt
+	}
+}
+
+// IdempotencyKey dispatches the execution only once per key and task, the
+// keys are kept for a week
+func IdempotencyKey(key string) DispatchOption {
+	return func(s *State) {
+		s.IdempotencyKey = key
+	}
+}
+
+// Headers adds custom headers to the execution, readable from the context and sent as message headers
+func Headers(headers map[string]string) DispatchOption {
+	return func(s *State) {
+		if s.Headers == nil {
+			s.Headers = make(map[string]string, len(headers))
+		}
+		maps.Copy(s.Headers, headers)
+	}
+}
+
+// CorrelationID correlates the execution with the request that dispatched it
+func CorrelationID(id string) DispatchOption {
+	return func(s *State) {
+		s.CorrelationID = id
+	}
+}
+
+// Queue sends the execution to one of the additional queues of the task, set with WithQueues
+func Queue(queue string) DispatchOption {
+	return func(s *State) {
+		s.Queue = queue
+	}
+}
+
+// ExecuteWithOptions executes the task once with the given dispatch options
+func (t *Task[T]) ExecuteWithOptions(params map[string]any, opts ...DispatchOption) error {
+	_, err := t.dispatch(context.Background(), params, opts...)
+	return err
+}
+
+// dispatch publishes a new execution of the task with the given dispatch options, it
+// returns the id of the execution, or of the first one dispatched with the same idempotency key
+func (t *Task[T]) dispatch(ctx context.Context, params map[string]any, opts ...DispatchOption) (uuid.UUID, error) {
+	state, err := t.newDispatchState(params, opts...)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if state.IdempotencyKey != "" {
+		taskID, claimed, err := t.executor.claimIdempotencyKey(t, state)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if !claimed {
+			return taskID, nil
+		}
+	}
+
+	if err := t.executor.Publish(ctx, t, state); err != nil {
+		if state.IdempotencyKey != "" {
+			if err := t.executor.releaseIdempotencyKey(t, state); err != nil {
+				log.Printf("failed to release idempotency key: %v", err)
+			}
+		}
+		return uuid.Nil, err
+	}
+
+	return state.TaskID, nil
+}
+
+// newDispatchState creates the state of a new execution and checks its dispatch options
+func (t *Task[T]) newDispatchState(params map[string]any, opts ...DispatchOption) (*State, error) {
+	// A reference to the offloaded parameters of another execution would be loaded, then deleted
+	for key := range params {
+		if strings.HasPrefix(key, reservedParameterPrefix) {
+			return nil, fmt.Errorf("%w: parameter %s is reserved", ErrInvalidDispatch, key)
+		}
+	}
+
+	state := newState(params)
+	for _, opt := range opts {
+		opt(state)
+	}
+
+	if t.Fairness != nil && state.FairnessKey == "" {
+		state.FairnessKey = t.Fairness.fairnessKey(params)
+	}
+
+	if state.Queue == t.Name() {
+		state.Queue = ""
+	}
+	if state.Queue != "" && !slices.Contains(t.Queues, state.Queue) {
+		return nil, fmt.Errorf("%w: queue %s is not consumed by task %s", ErrInvalidDispatch, state.Queue, t.Name())
+	}
+
+	if !state.ExpiresAt.IsZero() && !state.ExpiresAt.After(state.NotBefore) {
+		return nil, fmt.Errorf("%w: execution expires before it is due", ErrInvalidDispatch)
+	}
+
+	return state, nil
+}
+
+// dispatchRequest is the body of POST /tasks/:name carrying dispatch options,
+// bodies without a "parameters" object are the parameters themselves
+type dispatchRequest struct {
+	Parameters     map[string]any    `json:"parameters"`
+	Priority       *int              `json:"priority"`
+	FairnessKey    string            `json:"fairness_key"`
+	Delay          string            `json:"delay"`
+	ETA            *time.Time        `json:"eta"`
+	TTL            string            `json:"ttl"`
+	ExpiresAt      *time.Time        `json:"expires_at"`
+	IdempotencyKey string            `json:"idempotency_key"`
+	Headers        map[string]string `json:"headers"`
+	CorrelationID  string            `json:"correlation_id"`
+	Queue          string            `json:"queue"`
+}
+
+// dispatchOptionKeys is the keys of the options of a dispatch request, rejected
+// in bodies without a "parameters" object where they would be parameters
+var dispatchOptionKeys = func() []string {
+	keys := make([]string, 0)
+	for _, field := range reflect.VisibleFields(reflect.TypeFor[dispatchRequest]()) {
+		if key, _, _ := strings.Cut(field.Tag.Get("json"), ","); key != "parameters" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}()
+
+// bindDispatchRequest reads the parameters and dispatch options of a dispatch request,
+// the Idempotency-Key and X-Correlation-ID headers apply to both forms of body
+func bindDispatchRequest(c *gin.Context) (map[string]any, []DispatchOption, error) {
+	data, err := c.GetRawData()
+	if err != nil {
+		return nil, nil, errors.New("Invalid request body")
+	}
+
+	body := make(map[string]any)
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, nil, errors.New("Invalid request body")
+	}
+
+	opts := make([]DispatchOption, 0)
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		opts = append(opts, IdempotencyKey(key))
+	}
+	if id := c.GetHeader("X-Correlation-ID"); id != "" {
+		opts = append(opts, CorrelationID(id))
+	}
+
+	if _, ok := body["parameters"].(map[string]any); !ok {
+		for _, key := range dispatchOptionKeys {
+			if _, ok := body[key]; ok {
+				return nil, nil, fmt.Errorf("Invalid request body, %s is a dispatch option: wrap the parameters in a \"parameters\" object", key)
+			}
+		}
+		return body, opts, nil
+	}
+
+	var req dispatchRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, nil, errors.New("Invalid request body")
+	}
+
+	if req.Priority != nil {
+		if *req.Priority < 0 || *req.Priority > math.MaxUint8 {
+			return nil, nil, errors.New("Invalid priority, must be between 0 and 255")
+		}
+		opts = append(opts, Priority(uint8(*req.Priority)))
+	}
+
+	if req.FairnessKey != "" {
+		opts = append(opts, FairnessKey(req.FairnessKey))
+	}
+
+	if req.Delay != "" {
+		delay, err := time.ParseDuration(req.Delay)
+		if err != nil {
+			return nil, nil, errors.New("Invalid delay, must be a duration such as 30s")
+		}
+		opts = append(opts, Delay(delay))
+	}
+
+	if req.ETA != nil {
+		opts = append(opts, ETA(*req.ETA))
+	}
+
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, nil, errors.New("Invalid ttl, must be a duration such as 5m")
+		}
+		opts = append(opts, TTL(ttl))
+	}
+
+	if req.ExpiresAt != nil {
+		opts = append(opts, ExpiresAt(*req.ExpiresAt))
+	}
+
+	if req.IdempotencyKey != "" {
+		opts = append(opts, IdempotencyKey(req.IdempotencyKey))
+	}
+
+	if len(req.Headers) > 0 {
+		opts = append(opts, Headers(req.Headers))
+	}
+
+	if req.CorrelationID != "" {
+		opts = append(opts, CorrelationID(req.CorrelationID))
+	}
+
+	if req.Queue != "" {
+		opts = append(opts, Queue(req.Queue))
+	}
+
+	return req.Parameters, opts, nil
+}
@@ -0,0 +1,193 @@
+package zsched
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestBindDispatchRequest(t *testing.T) {
+	eta := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		body    string
+		headers map[string]string
+		params  map[string]any
+		state   State
+		err     string
+	}{
+		{
+			name:   "flat parameters",
+			body:   `{"user_id": 42}`,
+			params: map[string]any{"user_id": float64(42)},
+		},
+		{
+			name:    "flat parameters with headers",
+			body:    `{"user_id": 42}`,
+			headers: map[string]string{"Idempotency-Key": "key", "X-Correlation-ID": "corr"},
+			params:  map[string]any{"user_id": float64(42)},
+			state:   State{IdempotencyKey: "key", CorrelationID: "corr"},
+		},
+		{
+			name: "flat parameters with an option key",
+			body: `{"user_id": 42, "priority": 9}`,
+			err:  "priority is a dispatch option",
+		},
+		{
+			name: "flat parameters with a parameters value",
+			body: `{"parameters": "not an object", "queue": "eu"}`,
+			err:  "queue is a dispatch option",
+		},
+		{
+			name:   "wrapped parameters",
+			body:   `{"parameters": {"user_id": 42}, "priority": 9, "fairness_key": "tenant", "eta": "2030-01-02T03:04:05Z", "idempotency_key": "key", "headers": {"source": "billing"}, "correlation_id": "corr", "queue": "eu"}`,
+			params: map[string]any{"user_id": float64(42)},
+			state: State{
+				Priority:       9,
+				FairnessKey:    "tenant",
+				NotBefore:      eta,
+				IdempotencyKey: "key",
+				Headers:        map[string]string{"source": "billing"},
+				CorrelationID:  "corr",
+				Queue:          "eu",
+			},
+		},
+		{
+			name: "priority out of range",
+			body: `{"parameters": {}, "priority": 256}`,
+			err:  "Invalid priority",
+		},
+		{
+			name: "invalid delay",
+			body: `{"parameters": {}, "delay": "soon"}`,
+			err:  "Invalid delay",
+		},
+		{
+			name: "invalid ttl",
+			body: `{"parameters": {}, "ttl": "1 hour"}`,
+			err:  "Invalid ttl",
+		},
+		{
+			name: "invalid json",
+			body: `{"parameters": `,
+			err:  "Invalid request body",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/tasks/mail", strings.NewReader(tt.body))
+			for k, v := range tt.headers {
+				c.Request.Header.Set(k, v)
+			}
+
+			params, opts, err := bindDispatchRequest(c)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(params, tt.params) {
+				t.Errorf("params = %v, want %v", params, tt.params)
+			}
+
+			var state State
+			for _, opt := range opts {
+				opt(&state)
+			}
+			if !reflect.DeepEqual(state, tt.state) {
+				t.Errorf("state = %+v, want %+v", state, tt.state)
+			}
+		})
+	}
+}
+
+func TestBindDispatchRequestDurations(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/tasks/mail", strings.NewReader(`{"parameters": {}, "delay": "10m", "ttl": "1h"}`))
+
+	_, opts, err := bindDispatchRequest(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state State
+	for _, opt := range opts {
+		opt(&state)
+	}
+
+	if d := time.Until(state.NotBefore); d < 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("not before in %s, want 10m", d)
+	}
+	if d := time.Until(state.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expires in %s, want 1h", d)
+	}
+}
+
+func TestNewDispatchState(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]any
+		err    string
+	}{
+		{name: "parameters", params: map[string]any{"to": "a@b.c", "nested": map[string]any{"$ref": "x"}}},
+		{name: "no parameters"},
+		{name: "claim check", params: map[string]any{"$claim_check": uuid.NewString(), "$codec": "json"}, err: "parameter $"},
+		{name: "reserved prefix", params: map[string]any{"to": "a@b.c", "$tenant": "acme"}, err: "parameter $tenant is reserved"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := NewTask("mail", func(*Context[any]) error { return nil })
+
+			state, err := task.newDispatchState(tt.params)
+			if tt.err != "" {
+				if !errors.Is(err, ErrInvalidDispatch) || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(state.Parameters, tt.params) {
+				t.Errorf("parameters = %v, want %v", state.Parameters, tt.params)
+			}
+		})
+	}
+}
+
+func TestPostTaskRejectsClaimCheck(t *testing.T) {
+	e, b, _ := newTestExecutor(t)
+	task := NewTask("mail", func(*Context[any]) error { return nil })
+	task.executor = e
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("tasks", map[string]*Task[any]{"mail": task})
+	c.Params = gin.Params{{Key: "name", Value: "mail"}}
+	c.Request = httptest.NewRequest(http.MethodPost, "/tasks/mail", strings.NewReader(`{"$claim_check": "`+uuid.NewString()+`", "$codec": "json"}`))
+
+	PostTask[any](c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	if len(b.published()) != 0 {
+		t.Error("execution published")
+	}
+}
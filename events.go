@@ -0,0 +1,133 @@
+package zsched
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a status transition of an execution
+type Event struct {
+	// TaskName is the name of the task
+	TaskName string `json:"task_name"`
+
+	// TaskID is the id of the execution
+	TaskID uuid.UUID `json:"task_id"`
+
+	// StateID is the id of the state, it changes on every retry
+	StateID uuid.UUID `json:"state_id"`
+
+	// ParentID is the id of the parent execution
+	ParentID uuid.UUID `json:"parent_id"`
+
+	// Status is the new status of the execution
+	Status stateStatus `json:"status"`
+
+	// Iterations is the number of attempts so far
+	Iterations int `json:"iterations"`
+
+	// Error is the last error of the execution
+	Error string `json:"error,omitempty"`
+
+	// Time is the time of the transition
+	Time time.Time `json:"time"`
+}
+
+// EventBus fans out execution events to in-process subscribers.
+// It is registered as a hook so every status transition seen by the executor feeds it.
+type EventBus struct {
+	BaseHook
+
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventBus creates a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving events with the given buffer size
+// and a function to unsubscribe. Events are dropped for slow subscribers.
+func (b *EventBus) Subscribe(bufferSize int) (<-chan Event, func()) {
+	ch := make(chan Event, bufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends an event to every subscriber without blocking
+func (b *EventBus) Publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// OnPublish implements PublishHook
+func (b *EventBus) OnPublish(event *ExecutionEvent) {
+	b.Publish(newEvent(event.Task, event.State))
+}
+
+// OnStart implements StartHook
+func (b *EventBus) OnStart(event *ExecutionEvent) {
+	b.Publish(newEvent(event.Task, event.State))
+}
+
+// OnSuccess implements SuccessHook
+func (b *EventBus) OnSuccess(event *ExecutionEvent) {
+	b.Publish(newEvent(event.Task, event.State))
+}
+
+// OnRetry implements RetryHook
+func (b *EventBus) OnRetry(event *ExecutionEvent) {
+	b.Publish(newEvent(event.Task, event.State))
+}
+
+// OnFailure implements FailureHook
+func (b *EventBus) OnFailure(event *ExecutionEvent) {
+	b.Publish(newEvent(event.Task, event.State))
+}
+
+// OnLost implements LostHook
+func (b *EventBus) OnLost(event *ExecutionEvent) {
+	b.Publish(newEvent(event.Task, event.State))
+}
+
+// OnExpire implements ExpireHook
+func (b *EventBus) OnExpire(event *ExecutionEvent) {
+	b.Publish(newEvent(event.Task, event.State))
+}
+
+// newEvent creates an event from the current state of an execution
+func newEvent(task AnyTask, state *State) Event {
+	return Event{
+		TaskName:   task.Name(),
+		TaskID:     state.TaskID,
+		StateID:    state.ID,
+		ParentID:   state.ParentID,
+		Status:     state.Status,
+		Iterations: state.Iterations,
+		Error:      state.LastError,
+		Time:       time.Now(),
+	}
+}
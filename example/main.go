@@ -35,10 +35,14 @@ var helloTask = zsched.NewTask(
 var dispatchTask = zsched.NewTask(
 	"dispatch",
 	func(ctx *zsched.Context[*UserCtx]) error {
-		for range ctx.GetInt("count", 10) {
-			helloTask.Execute(map[string]any{"name": "World"})
-		}
-		return nil
+		_, err := helloTask.ExecuteBatch(func(yield func(map[string]any) bool) {
+			for range ctx.GetInt("count", 10) {
+				if !yield(map[string]any{"name": "World"}) {
+					return
+				}
+			}
+		})
+		return err
 	},
 	zsched.WithTags("goat"),
 	zsched.WithDefaultParameters(map[string]any{
@@ -56,7 +60,7 @@ func main() {
 	engine, err := zsched.NewBuilder(&userCtx).
 		WithRabbitMQBroker(os.Getenv("RABBITMQ_URL")).
 		WithTimescaleDBStorage(os.Getenv("POSTGRES_URL")).
-		WithHooks(&hooks.PrometheusHook{}).
+		WithHooks(&hooks.PrometheusHook{MountOnAPI: true}).
 		WithAPI(":8080").
 		Build()
 	if err != nil {
@@ -0,0 +1,337 @@
+package zsched
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+// ErrExecutionNotFound is returned when an execution does not exist in storage
+var ErrExecutionNotFound = errors.New("execution not found")
+
+// Execution is a single execution of a task, as stored by the task logger
+type Execution struct {
+	// TaskID is the id of the execution
+	TaskID uuid.UUID `json:"task_id"`
+
+	// TaskName is the name of the executed task
+	TaskName string `json:"task_name"`
+
+	// Status is the last known status of the execution
+	Status stateStatus `json:"status"`
+
+	// ParentID is the id of the parent execution
+	ParentID uuid.UUID `json:"parent_id"`
+
+	// Parameters is the parameters the execution was dispatched with
+	Parameters map[string]any `json:"parameters"`
+
+	// Iterations is the number of times the execution has been attempted
+	Iterations int `json:"iterations"`
+
+	// PublishedAt is the time the execution was published
+	PublishedAt time.Time `json:"published_at"`
+
+	// StartedAt is the time the execution started, zero while pending
+	StartedAt time.Time `json:"started_at,omitzero"`
+
+	// EndedAt is the time the execution ended, zero until completion
+	EndedAt time.Time `json:"ended_at,omitzero"`
+
+	// Duration is the duration of the execution in seconds
+	Duration float64 `json:"duration,omitempty"`
+
+	// LastError is the last error of the execution
+	LastError string `json:"last_error,omitempty"`
+
+	// NodeID is the id of the node that ran the last attempt
+	NodeID string `json:"node_id,omitempty"`
+
+	// Final is true once the execution will not be attempted again
+	Final bool `json:"final"`
+
+	// Progress is the last progress reported by the execution
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// ExecutionLog is a log line emitted during an execution
+type ExecutionLog struct {
+	ID       uuid.UUID      `json:"id"`
+	TaskID   uuid.UUID      `json:"task_id"`
+	StateID  uuid.UUID      `json:"state_id"`
+	Level    string         `json:"level"`
+	Message  string         `json:"message"`
+	Data     map[string]any `json:"data"`
+	LoggedAt time.Time      `json:"logged_at"`
+}
+
+// ExecutionFilter filters executions when listing them
+type ExecutionFilter struct {
+	// TaskName filters executions by task name
+	TaskName string
+
+	// Status filters executions by one or many statuses
+	Status []string
+
+	// ParentID filters executions by parent execution
+	ParentID uuid.UUID
+
+	// Since only keeps executions published after this time
+	Since time.Time
+
+	// Before only keeps executions published strictly before this time
+	Before time.Time
+
+	// BeforeID breaks the ties of Before, only executions published at Before with a
+	// lower id are kept, Before and BeforeID form the cursor of the pagination
+	BeforeID uuid.UUID
+
+	// Limit is the maximum number of executions to return
+	Limit int
+}
+
+const executionColumns = `task_id, task_name, status, parent_id, state, iterations, published_at, started_at, ended_at, last_error, node_id, final, done, total, message, updated_at`
+
+// executionTables joins the executions with their progress
+const executionTables = `tasks LEFT JOIN progress USING (task_id)`
+
+// where builds the WHERE clause of the filter and its arguments
+func (f ExecutionFilter) where() (string, []any) {
+	clauses := make([]string, 0)
+	args := make([]any, 0)
+
+	add := func(clause string, arg any) {
+		args = append(args, arg)
+		clauses = append(clauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if f.TaskName != "" {
+		add("task_name = $%d", f.TaskName)
+	}
+	if len(f.Status) > 0 {
+		add("status = ANY($%d)", f.Status)
+	}
+	if f.ParentID != uuid.Nil {
+		add("parent_id = $%d", f.ParentID)
+	}
+	if !f.Since.IsZero() {
+		add("published_at >= $%d", f.Since)
+	}
+	switch {
+	case !f.Before.IsZero() && f.BeforeID != uuid.Nil:
+		args = append(args, f.Before, f.BeforeID)
+		clauses = append(clauses, fmt.Sprintf("(published_at, task_id) < ($%d, $%d)", len(args)-1, len(args)))
+	case !f.Before.IsZero():
+		add("published_at < $%d", f.Before)
+	}
+
+	if len(clauses) == 0 {
+		return "", args
+	}
+
+	return "WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanExecution scans an execution selected with executionColumns
+func scanExecution(row scanner) (*Execution, error) {
+	var (
+		e         Execution
+		state     []byte
+		nodeID    sql.NullString
+		final     sql.NullBool
+		done      sql.NullInt64
+		total     sql.NullInt64
+		message   sql.NullString
+		updatedAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&e.TaskID,
+		&e.TaskName,
+		&e.Status,
+		&e.ParentID,
+		&state,
+		&e.Iterations,
+		&e.PublishedAt,
+		&e.StartedAt,
+		&e.EndedAt,
+		&e.LastError,
+		&nodeID,
+		&final,
+		&done,
+		&total,
+		&message,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	e.NodeID = nodeID.String
+	e.Final = final.Bool
+
+	if updatedAt.Valid {
+		e.Progress = &Progress{
+			Done:      done.Int64,
+			Total:     total.Int64,
+			Message:   message.String,
+			UpdatedAt: updatedAt.Time,
+		}
+	}
+
+	if len(state) > 0 {
+		if err := json.Unmarshal(state, &e.Parameters); err != nil {
+			return nil, err
+		}
+	}
+
+	// The task logger stores zero times for unset timestamps
+	if e.StartedAt.Year() <= 1 {
+		e.StartedAt = time.Time{}
+	}
+	if e.EndedAt.Year() <= 1 {
+		e.EndedAt = time.Time{}
+	}
+	if !e.StartedAt.IsZero() && !e.EndedAt.IsZero() {
+		e.Duration = e.EndedAt.Sub(e.StartedAt).Seconds()
+	}
+
+	return &e, nil
+}
+
+// ListExecutions returns the executions matching the filter, most recent first
+func ListExecutions(storage storage.Storage, filter ExecutionFilter) ([]*Execution, error) {
+	where, args := filter.where()
+	args = append(args, filter.Limit)
+
+	rows, err := storage.Query(
+		fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY published_at DESC, task_id DESC LIMIT $%d`, executionColumns, executionTables, where, len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to query executions"), err)
+	}
+	defer rows.Close()
+
+	executions := make([]*Execution, 0)
+	for rows.Next() {
+		e, err := scanExecution(rows)
+		if err != nil {
+			return nil, errors.Join(errors.New("failed to scan execution"), err)
+		}
+		executions = append(executions, e)
+	}
+
+	return executions, rows.Err()
+}
+
+// GetExecution returns a single execution by id
+func GetExecution(storage storage.Storage, id uuid.UUID) (*Execution, error) {
+	row := storage.QueryRow(
+		fmt.Sprintf(`SELECT %s FROM %s WHERE task_id = $1 ORDER BY published_at DESC LIMIT 1`, executionColumns, executionTables),
+		id,
+	)
+
+	e, err := scanExecution(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrExecutionNotFound
+	}
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to query execution"), err)
+	}
+
+	return e, nil
+}
+
+// ListExecutionLogs returns the logs of an execution, most recent first
+func ListExecutionLogs(storage storage.Storage, id uuid.UUID, limit int) ([]*ExecutionLog, error) {
+	rows, err := storage.Query(
+		`SELECT id, task_id, state_id, level, message, data, logged_at FROM logs WHERE task_id = $1 ORDER BY logged_at DESC, id DESC LIMIT $2`,
+		id,
+		limit,
+	)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to query logs"), err)
+	}
+	defer rows.Close()
+
+	return scanExecutionLogs(rows)
+}
+
+// scanExecutionLogs scans all the logs of the rows
+func scanExecutionLogs(rows *sql.Rows) ([]*ExecutionLog, error) {
+	logs := make([]*ExecutionLog, 0)
+	for rows.Next() {
+		var (
+			l    ExecutionLog
+			data []byte
+		)
+		if err := rows.Scan(&l.ID, &l.TaskID, &l.StateID, &l.Level, &l.Message, &data, &l.LoggedAt); err != nil {
+			return nil, errors.Join(errors.New("failed to scan log"), err)
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &l.Data); err != nil {
+				return nil, err
+			}
+		}
+		logs = append(logs, &l)
+	}
+
+	return logs, rows.Err()
+}
+
+// ListExecutionLogsSince returns the logs of an execution following the log logged at since
+// with the id after, oldest first. A nil after returns the logs logged after since.
+func ListExecutionLogsSince(storage storage.Storage, id uuid.UUID, since time.Time, after uuid.UUID, limit int) ([]*ExecutionLog, error) {
+	cursor, args := `logged_at > $2`, []any{id, since, limit}
+	if after != uuid.Nil {
+		cursor, args = `(logged_at, id) > ($2, $4)`, append(args, after)
+	}
+
+	rows, err := storage.Query(
+		`SELECT id, task_id, state_id, level, message, data, logged_at FROM logs WHERE task_id = $1 AND `+cursor+` ORDER BY logged_at ASC, id ASC LIMIT $3`,
+		args...,
+	)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to query logs"), err)
+	}
+	defer rows.Close()
+
+	return scanExecutionLogs(rows)
+}
+
+// ExecutionCursor returns the cursor of the executions following the execution, most recent first
+func ExecutionCursor(e *Execution) string {
+	return e.PublishedAt.Format(time.RFC3339Nano) + "," + e.TaskID.String()
+}
+
+// parseExecutionCursor parses a cursor returned by ExecutionCursor, a single time is accepted
+func parseExecutionCursor(cursor string) (time.Time, uuid.UUID, error) {
+	before, id, found := strings.Cut(cursor, ",")
+
+	t, err := time.Parse(time.RFC3339Nano, before)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+	if !found {
+		return t, uuid.Nil, nil
+	}
+
+	taskID, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	return t, taskID, nil
+}
@@ -0,0 +1,217 @@
+package zsched
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestExecutionFilterWhere(t *testing.T) {
+	before := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	id := uuid.MustParse("7f1c2b8e-3c6a-4a52-9d0e-5b1f0c9f6a11")
+
+	tests := []struct {
+		name   string
+		filter ExecutionFilter
+		where  string
+		args   []any
+	}{
+		{
+			name:   "empty",
+			filter: ExecutionFilter{},
+			where:  "",
+			args:   []any{},
+		},
+		{
+			name:   "task and statuses",
+			filter: ExecutionFilter{TaskName: "mail", Status: []string{"failed", "lost"}},
+			where:  "WHERE task_name = $1 AND status = ANY($2)",
+			args:   []any{"mail", []string{"failed", "lost"}},
+		},
+		{
+			name:   "time cursor",
+			filter: ExecutionFilter{Before: before},
+			where:  "WHERE published_at < $1",
+			args:   []any{before},
+		},
+		{
+			name:   "keyset cursor",
+			filter: ExecutionFilter{TaskName: "mail", Before: before, BeforeID: id},
+			where:  "WHERE task_name = $1 AND (published_at, task_id) < ($2, $3)",
+			args:   []any{"mail", before, id},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := tt.filter.where()
+			if where != tt.where {
+				t.Errorf("where = %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestExecutionCursor(t *testing.T) {
+	execution := &Execution{
+		TaskID:      uuid.New(),
+		PublishedAt: time.Date(2026, 1, 2, 3, 4, 5, 123456000, time.UTC),
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		before  time.Time
+		id      uuid.UUID
+		wantErr bool
+	}{
+		{name: "next cursor", cursor: ExecutionCursor(execution), before: execution.PublishedAt, id: execution.TaskID},
+		{name: "time only", cursor: "2026-01-02T03:04:05Z", before: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{name: "invalid time", cursor: "yesterday", wantErr: true},
+		{name: "invalid id", cursor: "2026-01-02T03:04:05Z,nope", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, id, err := parseExecutionCursor(tt.cursor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !before.Equal(tt.before) || id != tt.id {
+				t.Errorf("cursor = (%v, %v), want (%v, %v)", before, id, tt.before, tt.id)
+			}
+		})
+	}
+}
+
+func TestParseExecutionFilter(t *testing.T) {
+	id := uuid.New()
+
+	tests := []struct {
+		name    string
+		query   string
+		want    ExecutionFilter
+		wantErr bool
+	}{
+		{name: "defaults", query: "", want: ExecutionFilter{Limit: 100}},
+		{
+			name:  "filters",
+			query: "?task_name=mail&status=failed,lost&limit=5000&before=2026-01-02T03:04:05Z," + id.String(),
+			want: ExecutionFilter{
+				TaskName: "mail",
+				Status:   []string{"failed", "lost"},
+				Before:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+				BeforeID: id,
+				Limit:    1000,
+			},
+		},
+		{name: "invalid limit", query: "?limit=-1", wantErr: true},
+		{name: "invalid parent", query: "?parent_id=1", wantErr: true},
+		{name: "invalid before", query: "?before=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/executions"+tt.query, nil)
+
+			filter, err := parseExecutionFilter(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if filter.TaskName != tt.want.TaskName || !slices.Equal(filter.Status, tt.want.Status) ||
+				!filter.Before.Equal(tt.want.Before) || filter.BeforeID != tt.want.BeforeID || filter.Limit != tt.want.Limit {
+				t.Errorf("filter = %+v, want %+v", filter, tt.want)
+			}
+		})
+	}
+}
+
+func TestListExecutions(t *testing.T) {
+	id := uuid.New()
+	published := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	started := published.Add(time.Second)
+	ended := started.Add(2 * time.Second)
+
+	s := storagetest.New(func(q storagetest.Query) storagetest.Result {
+		return storagetest.Result{
+			Columns: []string{"task_id", "task_name", "status", "parent_id", "state", "iterations", "published_at", "started_at", "ended_at", "last_error", "node_id", "final", "done", "total", "message", "updated_at"},
+			Rows: [][]any{
+				{id.String(), "mail", "success", uuid.Nil.String(), []byte(`{"to":"a@b.c"}`), int64(1), published, started, ended, "", nil, true, nil, nil, nil, nil},
+			},
+		}
+	})
+
+	executions, err := ListExecutions(s, ExecutionFilter{TaskName: "mail", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := s.Queries()
+	if len(queries) != 1 || !queries[0].Contains("ORDER BY published_at DESC, task_id DESC LIMIT $2") {
+		t.Errorf("queries = %v", queries)
+	}
+
+	if len(executions) != 1 {
+		t.Fatalf("executions = %v", executions)
+	}
+	e := executions[0]
+	if e.TaskID != id || e.Parameters["to"] != "a@b.c" || e.Duration != 2 || !e.Final || e.Progress != nil {
+		t.Errorf("execution = %+v", e)
+	}
+}
+
+func TestListExecutionLogsSince(t *testing.T) {
+	taskID := uuid.New()
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	after := uuid.New()
+
+	tests := []struct {
+		name   string
+		after  uuid.UUID
+		cursor string
+		args   int
+	}{
+		{name: "time", after: uuid.Nil, cursor: "AND logged_at > $2 ORDER BY logged_at ASC, id ASC LIMIT $3", args: 3},
+		{name: "keyset", after: after, cursor: "AND (logged_at, id) > ($2, $4) ORDER BY logged_at ASC, id ASC LIMIT $3", args: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logID := uuid.New()
+			s := storagetest.New(func(q storagetest.Query) storagetest.Result {
+				return storagetest.Result{
+					Columns: []string{"id", "task_id", "state_id", "level", "message", "data", "logged_at"},
+					Rows: [][]any{
+						{logID.String(), taskID.String(), uuid.New().String(), "info", "hello", []byte(`{"n":1}`), since},
+					},
+				}
+			})
+
+			logs, err := ListExecutionLogsSince(s, taskID, since, tt.after, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			queries := s.Queries()
+			if len(queries) != 1 || !queries[0].Contains(tt.cursor) || len(queries[0].Args) != tt.args {
+				t.Fatalf("queries = %v", queries)
+			}
+			if len(logs) != 1 || logs[0].ID != logID || logs[0].Message != "hello" || logs[0].Data["n"] != float64(1) {
+				t.Errorf("logs = %+v", logs)
+			}
+		})
+	}
+}
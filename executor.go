@@ -1,28 +1,102 @@
 package zsched
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/blob"
 	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/codec"
 	"github.com/vlourme/zsched/pkg/logger"
+	"github.com/vlourme/zsched/pkg/storage"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Executor is the executor for the tasks
 type executor[T any] struct {
 	taskLogger  *taskLogger[T]
 	broker      broker.Broker
+	storage     storage.Storage
 	logger      logger.Logger
 	hooks       []Hook
 	userContext T
+
+	// ctx is the parent context of every execution, canceled when the engine stops
+	ctx context.Context
+
+	// middlewares is the global middlewares, wrapping the middlewares of each task
+	middlewares []Middleware[T]
+
+	// tracer traces publishes and executions
+	tracer trace.Tracer
+
+	// nodeID identifies the engine running the executor in the heartbeats
+	nodeID string
+
+	// slotTimeout is the time without heartbeat after which a fairness slot is free
+	slotTimeout time.Duration
+
+	// resources is the resource pools defined on the engine
+	resources map[string]*Resource
+
+	// outbox writes the dispatches to the outbox table, relayed to the broker
+	outbox     bool
+	outboxWake chan struct{}
+
+	// codec encodes the published messages, compressed above compressionThreshold bytes
+	codec                codec.Codec
+	compression          codec.Compression
+	compressionThreshold int
+
+	// blobs keeps the parameters larger than claimCheckThreshold bytes, the messages carry a reference
+	blobs               blob.Store
+	claimCheckThreshold int
+	claimCheckRetention time.Duration
+
+	consumersMu sync.RWMutex
+	consumers   map[string]*ConsumerStatus
 }
 
-// Publish publishes one or many executions to the broker
-func (e *executor[T]) Publish(task *Task[T], state *State) error {
+// ConsumerStatus is the status of the consumer of a task
+type ConsumerStatus struct {
+	// TaskName is the name of the consumed task
+	TaskName string `json:"task_name"`
+
+	// State is either "running" or "stopped"
+	State string `json:"state"`
+
+	// Error is the error that stopped the consumer
+	Error string `json:"error,omitempty"`
+
+	// Concurrency is the maximum number of concurrent executions
+	Concurrency int `json:"concurrency"`
+
+	// Active is the number of executions currently running
+	Active int `json:"active"`
+}
+
+const (
+	consumerRunning = "running"
+	consumerStopped = "stopped"
+)
+
+// Publish publishes one or many executions to the broker, the trace context of ctx is propagated
+func (e *executor[T]) Publish(ctx context.Context, task *Task[T], state *State) (err error) {
 	state.ID = uuid.New()
-	body, err := state.Serialize()
-	if err != nil {
+
+	span := e.startPublishSpan(ctx, task, state)
+	defer func() { endSpan(span, "", err) }()
+
+	if err := e.offload(state); err != nil {
 		return err
 	}
 
@@ -34,10 +108,71 @@ func (e *executor[T]) Publish(task *Task[T], state *State) error {
 		log.Printf("failed to run before execute hooks: %v", err)
 	}
 
-	if err := e.broker.Publish(body, task.Name()); err != nil {
+	// Delayed executions are kept in the storage until they are due
+	switch {
+	case state.NotBefore.After(time.Now()):
+		err = e.delay(task, state)
+	case e.outbox:
+		err = e.enqueue(task, state)
+	default:
+		err = e.send(task, state)
+	}
+	if err != nil {
+		failPublish(err, state)
+		if err := e.taskLogger.LogTasks(task, state); err != nil {
+			log.Printf("failed to log execution: %v", err)
+		}
 		return err
 	}
 
+	runHooks(e.hooks, func(h PublishHook) {
+		h.OnPublish(&ExecutionEvent{Task: task, State: state, Attempt: state.Iterations})
+	})
+
+	return nil
+}
+
+// failPublish marks executions which could not be published as failed, so their
+// pending row does not wait forever for a consumer
+func failPublish(err error, states ...*State) {
+	for _, s := range states {
+		s.Status = StatusFailed
+		s.Final = true
+		s.LastError = "failed to publish: " + err.Error()
+	}
+}
+
+// send publishes an execution to its queue
+func (e *executor[T]) send(task *Task[T], state *State) error {
+	msg, err := e.newMessage(task.Name(), state)
+	if err != nil {
+		return err
+	}
+
+	return e.sendMessage(msg)
+}
+
+// sendMessage publishes a message with its properties if the broker supports them
+func (e *executor[T]) sendMessage(msg broker.Message) error {
+	if p, ok := e.broker.(broker.MessagePublisher); ok {
+		return p.PublishMessage(msg)
+	}
+
+	return e.broker.Publish(msg.Body, msg.Queue)
+}
+
+// sendMessages publishes messages in a batch confirmed by the broker if it supports
+// batches, one by one otherwise
+func (e *executor[T]) sendMessages(ctx context.Context, msgs []broker.Message) error {
+	if p, ok := e.broker.(broker.BatchPublisher); ok {
+		return p.PublishBatch(ctx, msgs)
+	}
+
+	for _, msg := range msgs {
+		if err := e.sendMessage(msg); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -47,84 +182,331 @@ func (e *executor[T]) Consume(task *Task[T]) error {
 		go task.collectorAction(task.collector, e.userContext)
 	}
 
-	return e.broker.Consume(
-		task.Name(),
-		task.MaxRetries == 0, // prevent re-shipping on broker restart
-		task.Concurrency,
-		func(body []byte) error {
-			s, err := deserializeState(body)
-			if err != nil {
-				return err
-			}
+	e.updateConsumer(task, func(c *ConsumerStatus) {
+		c.State = consumerRunning
+		c.Error = ""
+	})
 
-			s.Status = StatusRunning
-			s.StartedAt = time.Now()
-			s.Iterations++
+	// With fairness, executions are fetched ahead and picked across their keys
+	prefetch := task.Concurrency
+	var sched *fairScheduler
+	if task.Fairness != nil {
+		prefetch = task.Concurrency * (1 + max(task.Fairness.Lookahead, 0))
+		sched = newFairScheduler(task.Concurrency, task.Fairness)
+	}
 
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("recovered from a panic: [%s]%s: %v", task.Name(), s.ID, r)
-				}
-			}()
+	// The queue of the task and its additional queues are consumed with the same handler
+	queues := append([]string{task.Name()}, task.Queues...)
+	errs := make([]error, len(queues))
 
-			if err := e.taskLogger.LogTasks(task, s); err != nil {
-				log.Printf("failed to log execution: %v", err)
-			}
+	var wg sync.WaitGroup
+	for i, queue := range queues {
+		wg.Go(func() {
+			errs[i] = e.consume(
+				queue,
+				task.MaxRetries == 0, // prevent re-shipping on broker restart
+				prefetch,
+				func(msg broker.Message) error {
+					defer func() {
+						if r := recover(); r != nil {
+							log.Printf("recovered from a panic: [%s]%s: %v", task.Name(), msg.MessageID, r)
+						}
+					}()
 
-			if err := e.runBeforeExecuteHooks(task, s); err != nil {
-				log.Printf("failed to run before execute hooks: %v", err)
-			}
+					return e.handle(task, sched, msg)
+				},
+			)
+		})
+	}
+	wg.Wait()
 
-			ctx := newContext(task, *s, e.logger, e.userContext)
-			err = task.Action(ctx)
-			if err != nil {
-				ctx.WithField("error", err.Error()).WithField("task_name", task.Name()).Error("task execution failed")
-				s.LastError = err.Error()
-				s.Status = StatusFailed
-
-				if task.MaxRetries == -1 || s.Iterations < task.MaxRetries {
-					if err := e.taskLogger.LogTasks(task, s); err != nil {
-						log.Printf("failed to log execution: %v", err)
-					}
-					if err := e.runAfterExecuteHooks(task, s); err != nil {
-						log.Printf("failed to run after execute hooks: %v", err)
-					}
-					if err := e.Publish(task, s); err != nil {
-						log.Printf("failed to re-publish task: %v", err)
-					}
-					return err
-				}
-			} else {
-				s.Status = StatusSuccess
-			}
+	err := errors.Join(errs...)
 
-			if err := e.taskLogger.LogTasks(task, s); err != nil {
-				log.Printf("failed to log execution: %v", err)
-			}
-			if err := e.runAfterExecuteHooks(task, s); err != nil {
-				log.Printf("failed to run after execute hooks: %v", err)
-			}
+	e.updateConsumer(task, func(c *ConsumerStatus) {
+		c.State = consumerStopped
+		if err != nil {
+			c.Error = err.Error()
+		}
+	})
+
+	return err
+}
+
+// consume consumes a queue with the properties of the messages if the broker supports them
+func (e *executor[T]) consume(queue string, autoAck bool, concurrency int, handler func(msg broker.Message) error) error {
+	if c, ok := e.broker.(broker.MessageConsumer); ok {
+		return c.ConsumeMessages(queue, autoAck, concurrency, handler)
+	}
+
+	return e.broker.Consume(queue, autoAck, concurrency, func(body []byte) error {
+		return handler(broker.Message{Queue: queue, Body: body})
+	})
+}
+
+// handle admits a received execution according to the fairness and resources of the task, then executes it
+func (e *executor[T]) handle(task *Task[T], sched *fairScheduler, msg broker.Message) error {
+	s, err := e.decode(msg)
+	if err != nil {
+		return err
+	}
+
+	if s.expired() {
+		e.expire(task, s)
+		return nil
+	}
+
+	if sched != nil {
+		release, err := e.admit(task, sched, s)
+		if errors.Is(err, errDeferred) {
+			return e.requeue(task, s)
+		}
+		if errors.Is(err, context.Canceled) {
+			return e.giveBack(task, s)
+		}
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	if len(task.Resources) > 0 {
+		release, err := e.acquireResources(task, s)
+		if errors.Is(err, context.Canceled) {
+			return e.giveBack(task, s)
+		}
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	// The execution may have expired while waiting for its slots
+	if s.expired() {
+		e.expire(task, s)
+		return nil
+	}
 
-			return nil
-		},
-	)
+	return e.execute(task, s)
 }
 
-// runBeforeExecuteHooks runs the before execute hooks
+// expire drops an execution that expired before being executed
+func (e *executor[T]) expire(task *Task[T], s *State) {
+	s.Status = StatusExpired
+	s.Final = true
+	s.LastError = "execution expired at " + s.ExpiresAt.Format(time.RFC3339)
+	e.releaseParameters(s)
+
+	e.logger.WithField("task_name", task.Name()).WithField("task_id", s.TaskID.String()).Warn(s.LastError)
+
+	if err := e.taskLogger.LogTasks(task, s); err != nil {
+		log.Printf("failed to log execution: %v", err)
+	}
+
+	runHooks(e.hooks, func(h ExpireHook) {
+		h.OnExpire(&ExecutionEvent{Task: task, State: s, Attempt: s.Iterations})
+	})
+}
+
+// execute runs an attempt of an execution and dispatches the lifecycle hooks
+func (e *executor[T]) execute(task *Task[T], s *State) error {
+	s.Status = StatusRunning
+	s.StartedAt = time.Now()
+	s.Iterations++
+	s.NodeID = e.nodeID
+
+	if err := e.taskLogger.LogTasks(task, s); err != nil {
+		log.Printf("failed to log execution: %v", err)
+	}
+
+	if err := e.runBeforeExecuteHooks(task, s); err != nil {
+		log.Printf("failed to run before execute hooks: %v", err)
+	}
+
+	runHooks(e.hooks, func(h StartHook) {
+		h.OnStart(&ExecutionEvent{Task: task, State: s, Attempt: s.Iterations})
+	})
+
+	e.updateConsumer(task, func(c *ConsumerStatus) { c.Active++ })
+	defer e.updateConsumer(task, func(c *ConsumerStatus) { c.Active-- })
+
+	e.beginHeartbeat(task, s)
+	defer e.endHeartbeat(s.TaskID, s.ID)
+
+	var err error
+
+	spanCtx, span := e.startConsumeSpan(task, s)
+	defer func() { endSpan(span, s.Status, err) }()
+
+	actionCtx := spanCtx
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		actionCtx, cancel = context.WithTimeout(spanCtx, task.Timeout)
+		defer cancel()
+	}
+
+	action := chain(task.Action, append(slices.Clone(e.middlewares), taskMiddlewares(task)...)...)
+
+	// Offloaded parameters are only resolved for the action, the state keeps the reference
+	state := *s
+	state.Parameters, err = e.resolveParameters(s)
+
+	ctx := newContext(actionCtx, task, state, e.logger, e.storage, e.userContext)
+	if err == nil {
+		err = runAction(action, ctx)
+	}
+
+	if err := ctx.flushProgress(); err != nil {
+		log.Printf("failed to save progress: %v", err)
+	}
+
+	event := &ExecutionEvent{
+		Task:     task,
+		State:    s,
+		Attempt:  s.Iterations,
+		Duration: time.Since(s.StartedAt),
+		Error:    err,
+	}
+
+	if err == nil {
+		s.Status = StatusSuccess
+		s.Final = true
+		e.releaseParameters(s)
+
+		if err := e.taskLogger.LogTasks(task, s); err != nil {
+			log.Printf("failed to log execution: %v", err)
+		}
+		if err := e.runAfterExecuteHooks(task, s); err != nil {
+			log.Printf("failed to run after execute hooks: %v", err)
+		}
+		runHooks(e.hooks, func(h SuccessHook) { h.OnSuccess(event) })
+
+		return nil
+	}
+
+	ctx.WithField("error", err.Error()).WithField("task_name", task.Name()).Error("task execution failed")
+	s.LastError = err.Error()
+	s.Status = StatusFailed
+
+	retry := !IsPermanent(err) && (task.MaxRetries == -1 || s.Iterations < task.MaxRetries)
+
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		runHooks(e.hooks, func(h PanicHook) { h.OnPanic(event) })
+	case errors.Is(actionCtx.Err(), context.DeadlineExceeded):
+		runHooks(e.hooks, func(h TimeoutHook) { h.OnTimeout(event) })
+	case errors.Is(actionCtx.Err(), context.Canceled):
+		runHooks(e.hooks, func(h CancelHook) { h.OnCancel(event) })
+	}
+
+	if retry {
+		// The retry is published first, so a failed publish is recorded as the end of the execution
+		retried := *s
+		publishErr := e.Publish(e.retryContext(s, span), task, &retried)
+		if publishErr == nil {
+			e.logFailure(task, s)
+			runHooks(e.hooks, func(h RetryHook) { h.OnRetry(event) })
+			return err
+		}
+
+		log.Printf("failed to re-publish task: %v", publishErr)
+		event.Error = errors.Join(err, publishErr)
+	}
+
+	s.Final = true
+	e.logFailure(task, s)
+
+	runHooks(e.hooks, func(h FailureHook) { h.OnFailure(event) })
+
+	return nil
+}
+
+// logFailure writes a failed attempt to the tasks table and runs the legacy after execute hooks
+func (e *executor[T]) logFailure(task *Task[T], s *State) {
+	if err := e.taskLogger.LogTasks(task, s); err != nil {
+		log.Printf("failed to log execution: %v", err)
+	}
+	if err := e.runAfterExecuteHooks(task, s); err != nil {
+		log.Printf("failed to run after execute hooks: %v", err)
+	}
+}
+
+// PanicError is the error of an attempt that panicked
+type PanicError struct {
+	// Value is the value passed to panic
+	Value any
+
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// runAction runs the action, recovering panics as a *PanicError
+func runAction[T any](action taskAction[T], ctx *Context[T]) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return action(ctx)
+}
+
+// updateConsumer updates the consumer status of a task
+func (e *executor[T]) updateConsumer(task *Task[T], update func(c *ConsumerStatus)) {
+	e.consumersMu.Lock()
+	defer e.consumersMu.Unlock()
+
+	if e.consumers == nil {
+		e.consumers = make(map[string]*ConsumerStatus)
+	}
+
+	c, ok := e.consumers[task.Name()]
+	if !ok {
+		c = &ConsumerStatus{TaskName: task.Name(), Concurrency: task.Concurrency}
+		e.consumers[task.Name()] = c
+	}
+
+	update(c)
+}
+
+// Consumers returns a snapshot of the consumer statuses, sorted by task name
+func (e *executor[T]) Consumers() []ConsumerStatus {
+	e.consumersMu.RLock()
+	defer e.consumersMu.RUnlock()
+
+	statuses := make([]ConsumerStatus, 0, len(e.consumers))
+	for _, c := range slices.SortedFunc(maps.Values(e.consumers), func(a, b *ConsumerStatus) int {
+		return strings.Compare(a.TaskName, b.TaskName)
+	}) {
+		statuses = append(statuses, *c)
+	}
+
+	return statuses
+}
+
+// runBeforeExecuteHooks runs the legacy before execute hooks
 func (e *executor[T]) runBeforeExecuteHooks(task *Task[T], s *State) error {
 	for _, hook := range e.hooks {
-		if err := hook.BeforeExecute(task, s); err != nil {
-			return err
+		if h, ok := hook.(BeforeExecuteHook); ok {
+			if err := h.BeforeExecute(task, s); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-// runAfterExecuteHooks runs the after execute hooks
+// runAfterExecuteHooks runs the legacy after execute hooks
 func (e *executor[T]) runAfterExecuteHooks(task *Task[T], s *State) error {
 	for _, hook := range e.hooks {
-		if err := hook.AfterExecute(task, s); err != nil {
-			return err
+		if h, ok := hook.(AfterExecuteHook); ok {
+			if err := h.AfterExecute(task, s); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
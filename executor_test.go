@@ -0,0 +1,238 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/codec"
+	"github.com/vlourme/zsched/pkg/logger"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// newTestExecutor creates an executor on a fake broker and an in-memory storage
+func newTestExecutor(t *testing.T, hooks ...Hook) (*executor[any], *fakeBroker, *storagetest.Storage) {
+	t.Helper()
+
+	b := newFakeBroker()
+	s := storagetest.New(nil)
+
+	return &executor[any]{
+		taskLogger: &taskLogger[any]{storage: s, pending: make(chan pendingTask, 100)},
+		broker:     b,
+		storage:    s,
+		logger:     logger.NewSlogLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		hooks:      hooks,
+		ctx:        context.Background(),
+		tracer:     noop.NewTracerProvider().Tracer(tracerName),
+		nodeID:     "node",
+		codec:      codec.JSON(),
+	}, b, s
+}
+
+// recordingHook records the names of the events it receives
+type recordingHook struct {
+	BaseHook
+
+	mu     sync.Mutex
+	events []string
+	errors []error
+	panic  bool
+}
+
+func (h *recordingHook) record(name string, event *ExecutionEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, name)
+	h.errors = append(h.errors, event.Error)
+}
+
+func (h *recordingHook) OnStart(event *ExecutionEvent) {
+	if h.panic {
+		panic("hook panicked")
+	}
+	h.record("start", event)
+}
+
+func (h *recordingHook) OnSuccess(event *ExecutionEvent) { h.record("success", event) }
+func (h *recordingHook) OnRetry(event *ExecutionEvent)   { h.record("retry", event) }
+func (h *recordingHook) OnFailure(event *ExecutionEvent) { h.record("failure", event) }
+func (h *recordingHook) OnPanic(event *ExecutionEvent)   { h.record("panic", event) }
+
+func (h *recordingHook) recorded() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.events...)
+}
+
+func TestExecuteHooks(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     func(*Context[any]) error
+		maxRetries int
+		publishErr error
+		events     []string
+		published  int
+		wantErr    bool
+		lastErr    string
+		final      bool
+	}{
+		{
+			name:   "success",
+			action: func(*Context[any]) error { return nil },
+			events: []string{"start", "success"},
+			final:  true,
+		},
+		{
+			name:       "retried",
+			action:     func(*Context[any]) error { return errors.New("boom") },
+			maxRetries: 3,
+			events:     []string{"start", "retry"},
+			published:  1,
+			wantErr:    true,
+		},
+		{
+			name:       "retry not published",
+			action:     func(*Context[any]) error { return errors.New("boom") },
+			maxRetries: 3,
+			publishErr: errors.New("broker down"),
+			events:     []string{"start", "failure"},
+			lastErr:    "broker down",
+			final:      true,
+		},
+		{
+			name:       "permanent",
+			action:     func(*Context[any]) error { return Permanent(errors.New("invalid")) },
+			maxRetries: 3,
+			events:     []string{"start", "failure"},
+			final:      true,
+		},
+		{
+			name:       "panic",
+			action:     func(*Context[any]) error { panic("oops") },
+			maxRetries: 1,
+			events:     []string{"start", "panic", "failure"},
+			final:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &recordingHook{}
+			e, b, _ := newTestExecutor(t, hook)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+
+			task := NewTask("mail", tt.action, WithMaxRetries(tt.maxRetries))
+			s := &State{ID: uuid.New(), TaskID: uuid.New(), Parameters: map[string]any{}}
+
+			err := e.execute(task, s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := hook.recorded(); !slices.Equal(got, tt.events) {
+				t.Errorf("events = %v, want %v", got, tt.events)
+			}
+			if got := len(b.published()); got != tt.published {
+				t.Errorf("published = %d, want %d", got, tt.published)
+			}
+			// The last row written to the tasks table records the decision of the executor
+			var last pendingTask
+			for len(e.taskLogger.pending) > 0 {
+				last = <-e.taskLogger.pending
+			}
+			if last.Final != tt.final {
+				t.Errorf("final = %v, want %v", last.Final, tt.final)
+			}
+
+			if tt.lastErr != "" {
+				last := hook.errors[len(hook.errors)-1]
+				if last == nil || !strings.Contains(last.Error(), tt.lastErr) {
+					t.Errorf("failure error = %v, want %q", last, tt.lastErr)
+				}
+			}
+		})
+	}
+}
+
+func TestConsumeRecoversPanics(t *testing.T) {
+	hook := &recordingHook{panic: true}
+	e, b, _ := newTestExecutor(t, hook)
+
+	task := NewTask("mail", func(*Context[any]) error { return nil })
+	if err := e.Consume(task); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := e.newMessage(task.Name(), &State{ID: uuid.New(), TaskID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A panic out of the action is recovered by the handler, not by the broker
+	if err := b.deliver(msg); err != nil {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestPublishFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		outbox     bool
+		delay      time.Duration
+		publishErr error
+		failQuery  string
+		status     stateStatus
+	}{
+		{name: "sent", status: StatusPending},
+		{name: "broker failure", publishErr: errors.New("broker down"), status: StatusFailed},
+		{name: "outbox write failure", outbox: true, failQuery: "INSERT INTO outbox", status: StatusFailed},
+		{name: "delayed write failure", delay: time.Hour, failQuery: "INSERT INTO delayed_executions", status: StatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, b, s := newTestExecutor(t)
+			e.outbox = tt.outbox
+			e.outboxWake = make(chan struct{}, 1)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+			s.SetHandler(func(q storagetest.Query) storagetest.Result {
+				if tt.failQuery != "" && q.Contains(tt.failQuery) {
+					return storagetest.Result{Err: errors.New("storage down")}
+				}
+				return storagetest.Result{}
+			})
+
+			task := NewTask("mail", func(*Context[any]) error { return nil })
+			state := newState(map[string]any{})
+			if tt.delay > 0 {
+				state.NotBefore = time.Now().Add(tt.delay)
+			}
+
+			err := e.Publish(context.Background(), task, state)
+			if (err != nil) != (tt.status == StatusFailed) {
+				t.Fatalf("err = %v", err)
+			}
+
+			// The last row of the execution is the one left in the tasks table
+			var last pendingTask
+			for len(e.taskLogger.pending) > 0 {
+				last = <-e.taskLogger.pending
+			}
+			if last.Status != tt.status {
+				t.Errorf("status = %s, want %s", last.Status, tt.status)
+			}
+			if tt.status == StatusFailed && (!last.Final || !strings.Contains(last.LastError, "failed to publish")) {
+				t.Errorf("row = %+v, want a final row with the publish error", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,464 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+const (
+	// defaultFairnessLookahead is the default number of waiting executions fetched per unit of concurrency
+	defaultFairnessLookahead = 3
+
+	// defaultFairnessDeferAfter is the default time an execution waits for its key before being deferred
+	defaultFairnessDeferAfter = 5 * time.Second
+
+	// fairnessSlotRetryInterval is the time a key is skipped after its cluster-wide cap was reached
+	fairnessSlotRetryInterval = time.Second
+)
+
+// errDeferred is returned when an execution waited too long for its fairness key
+var errDeferred = errors.New("fairness key is saturated")
+
+// Fairness shares the concurrency of a task between the keys of its executions,
+// for example the customers on behalf of whom the task is dispatched
+type Fairness struct {
+	// Parameter is the parameter holding the fairness key of an execution
+	Parameter string `json:"parameter"`
+
+	// KeyConcurrency is the maximum number of running executions of a key across
+	// every node, zero means the key is only limited by the task concurrency
+	KeyConcurrency int `json:"key_concurrency,omitempty"`
+
+	// Weights is the share of the concurrency of the keys, default weight is 1
+	Weights map[string]int `json:"weights,omitempty"`
+
+	// Lookahead is the number of waiting executions fetched per unit of concurrency,
+	// executions are picked among them
+	Lookahead int `json:"lookahead"`
+
+	// DeferAfter is the time an execution waits for its capped key before being
+	// dispatched again at the end of the queue
+	DeferAfter time.Duration `json:"defer_after"`
+}
+
+// WithFairness picks the executions of the task round-robin across the values of a parameter,
+// instead of in dispatch order
+func WithFairness(parameter string, opts ...func(*Fairness)) func(*taskConfig) {
+	return func(t *taskConfig) {
+		t.Fairness = &Fairness{
+			Parameter:  parameter,
+			Lookahead:  defaultFairnessLookahead,
+			DeferAfter: defaultFairnessDeferAfter,
+		}
+
+		for _, opt := range opts {
+			opt(t.Fairness)
+		}
+	}
+}
+
+// WithKeyConcurrency sets the maximum number of running executions of a key across every node
+func WithKeyConcurrency(concurrency int) func(*Fairness) {
+	return func(f *Fairness) {
+		f.KeyConcurrency = concurrency
+	}
+}
+
+// WithKeyWeights sets the share of the concurrency of the keys, default weight is 1
+func WithKeyWeights(weights map[string]int) func(*Fairness) {
+	return func(f *Fairness) {
+		f.Weights = weights
+	}
+}
+
+// WithLookahead sets the number of waiting executions fetched per unit of concurrency, default is 3
+func WithLookahead(lookahead int) func(*Fairness) {
+	return func(f *Fairness) {
+		f.Lookahead = lookahead
+	}
+}
+
+// WithDeferAfter sets the time an execution waits for its capped key before
+// being dispatched again at the end of the queue, default is 5 seconds
+func WithDeferAfter(d time.Duration) func(*Fairness) {
+	return func(f *Fairness) {
+		f.DeferAfter = d
+	}
+}
+
+// FairnessKey sets the fairness key of the execution, instead of the value of the fairness parameter
+func FairnessKey(key string) DispatchOption {
+	return func(s *State) {
+		s.FairnessKey = key
+	}
+}
+
+// fairnessKey returns the fairness key of a new execution
+func (f *Fairness) fairnessKey(params map[string]any) string {
+	if v, ok := params[f.Parameter]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// weight returns the weight of a key
+func (f *Fairness) weight(key string) int {
+	if w := f.Weights[key]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// fairWaiter is an execution waiting for its key, it is deferred once it has been
+// blocked by its key, rather than by the concurrency of the task, for DeferAfter
+type fairWaiter struct {
+	granted  chan struct{}
+	deferred chan struct{}
+
+	// blocked is the time blocked by the key before blockedAt, zero while not blocked
+	blocked   time.Duration
+	blockedAt time.Time
+	timer     *time.Timer
+	deferOnce sync.Once
+}
+
+func newFairWaiter() *fairWaiter {
+	return &fairWaiter{deferred: make(chan struct{})}
+}
+
+// block starts or stops counting the time the waiter is blocked by its key,
+// must be called with the lock of the scheduler held
+func (w *fairWaiter) block(blocked bool, now time.Time, deferAfter time.Duration) {
+	switch {
+	case blocked && w.blockedAt.IsZero():
+		w.blockedAt = now
+		w.timer = time.AfterFunc(deferAfter-w.blocked, func() {
+			w.deferOnce.Do(func() { close(w.deferred) })
+		})
+	case !blocked && !w.blockedAt.IsZero():
+		w.timer.Stop()
+		w.blocked += now.Sub(w.blockedAt)
+		w.blockedAt = time.Time{}
+	}
+}
+
+// fairKey is the local state of a fairness key
+type fairKey struct {
+	running      int
+	waiting      []*fairWaiter
+	current      int
+	blockedUntil time.Time
+}
+
+// fairScheduler grants the concurrency of a task to the waiting executions with a
+// smooth weighted round-robin across their keys, capped per key
+type fairScheduler struct {
+	mu          sync.Mutex
+	fairness    *Fairness
+	concurrency int
+	running     int
+	keys        map[string]*fairKey
+
+	// deferAfter is the time a waiter is blocked by its key before being deferred, zero never defers
+	deferAfter time.Duration
+}
+
+func newFairScheduler(concurrency int, fairness *Fairness) *fairScheduler {
+	f := &fairScheduler{
+		fairness:    fairness,
+		concurrency: concurrency,
+		keys:        make(map[string]*fairKey),
+	}
+	if fairness.KeyConcurrency > 0 {
+		f.deferAfter = fairness.DeferAfter
+	}
+	return f
+}
+
+// acquire waits until the key is granted a slot, it returns errDeferred once the waiter
+// has been blocked by its key for too long, the same waiter keeps the time it was blocked
+// across acquisitions
+func (f *fairScheduler) acquire(ctx context.Context, key string, w *fairWaiter) error {
+	f.mu.Lock()
+	w.granted = make(chan struct{})
+	k, ok := f.keys[key]
+	if !ok {
+		k = &fairKey{}
+		f.keys[key] = k
+	}
+	k.waiting = append(k.waiting, w)
+	f.grant()
+	f.mu.Unlock()
+
+	var err error
+	select {
+	case <-w.granted:
+		return nil
+	case <-w.deferred:
+		err = errDeferred
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	select {
+	case <-w.granted:
+		// Granted while giving up, the slot is handed to the next waiter
+		f.releaseLocked(key, 0)
+	default:
+		w.block(false, time.Now(), f.deferAfter)
+		k.waiting = slices.DeleteFunc(k.waiting, func(o *fairWaiter) bool { return o == w })
+		f.forget(key)
+	}
+
+	return err
+}
+
+// release frees the slot of a key, the key is skipped for the block duration
+func (f *fairScheduler) release(key string, block time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.releaseLocked(key, block)
+}
+
+func (f *fairScheduler) releaseLocked(key string, block time.Duration) {
+	k := f.keys[key]
+	k.running--
+	f.running--
+
+	if block > 0 {
+		k.blockedUntil = time.Now().Add(block)
+		time.AfterFunc(block, func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.grant()
+			if _, ok := f.keys[key]; ok {
+				f.forget(key)
+			}
+		})
+	}
+
+	f.grant()
+	f.forget(key)
+}
+
+// grant hands the free slots to the waiting executions, must be called with the lock held
+func (f *fairScheduler) grant() {
+	now := time.Now()
+	defer f.deferWaiters(now)
+
+	for f.running < f.concurrency {
+		// Smooth weighted round-robin: every eligible key earns its weight,
+		// the richest is picked and pays the total
+		var (
+			picked    *fairKey
+			total     int
+			candidate bool
+		)
+		for key, k := range f.keys {
+			if len(k.waiting) == 0 || f.keyBlocked(k, now) {
+				continue
+			}
+
+			weight := f.fairness.weight(key)
+			k.current += weight
+			total += weight
+			if !candidate || k.current > picked.current {
+				picked, candidate = k, true
+			}
+		}
+		if !candidate {
+			return
+		}
+
+		picked.current -= total
+		w := picked.waiting[0]
+		picked.waiting = picked.waiting[1:]
+		picked.running++
+		f.running++
+		w.block(false, now, f.deferAfter)
+		close(w.granted)
+	}
+}
+
+// keyBlocked returns true if the key cannot run more executions, because of its
+// cap or of the cluster-wide slots, must be called with the lock held
+func (f *fairScheduler) keyBlocked(k *fairKey, now time.Time) bool {
+	if now.Before(k.blockedUntil) {
+		return true
+	}
+	return f.fairness.KeyConcurrency > 0 && k.running >= f.fairness.KeyConcurrency
+}
+
+// deferWaiters counts the time the waiters are blocked by their key, waiters only
+// blocked by the concurrency of the task are never deferred. Must be called with the lock held.
+func (f *fairScheduler) deferWaiters(now time.Time) {
+	if f.deferAfter <= 0 {
+		return
+	}
+
+	for _, k := range f.keys {
+		blocked := f.keyBlocked(k, now)
+		for _, w := range k.waiting {
+			w.block(blocked, now, f.deferAfter)
+		}
+	}
+}
+
+// forget removes an idle key, must be called with the lock held
+func (f *fairScheduler) forget(key string) {
+	k := f.keys[key]
+	if k.running == 0 && len(k.waiting) == 0 && time.Now().After(k.blockedUntil) {
+		delete(f.keys, key)
+	}
+}
+
+// createFairnessTable creates the table of the cluster-wide slots of the fairness keys
+func createFairnessTable(storage storage.Storage) error {
+	_, err := storage.Exec(`
+		CREATE TABLE IF NOT EXISTS fairness_slots (
+			task_name VARCHAR(128),
+			fairness_key VARCHAR(255),
+			slot INTEGER,
+			task_id UUID,
+			node_id VARCHAR(64),
+			heartbeat_at TIMESTAMPTZ,
+			PRIMARY KEY (task_name, fairness_key, slot)
+		)
+	`)
+	return err
+}
+
+// admit waits until the execution can run according to the fairness of the task,
+// it returns errDeferred if its key stayed saturated for too long
+func (e *executor[T]) admit(task *Task[T], sched *fairScheduler, s *State) (func(), error) {
+	fairness := task.Fairness
+	w := newFairWaiter()
+
+	for {
+		if err := sched.acquire(e.ctx, s.FairnessKey, w); err != nil {
+			return nil, err
+		}
+
+		if fairness.KeyConcurrency == 0 {
+			return func() { sched.release(s.FairnessKey, 0) }, nil
+		}
+
+		slot, ok, err := e.acquireSlot(task, s)
+		if err != nil {
+			// The storage is unreachable, the key is only capped on this node
+			log.Printf("failed to acquire fairness slot: %v", err)
+			return func() { sched.release(s.FairnessKey, 0) }, nil
+		}
+		if ok {
+			taskID := s.TaskID
+			return func() {
+				e.releaseSlot(task, s.FairnessKey, slot, taskID)
+				sched.release(s.FairnessKey, 0)
+			}, nil
+		}
+
+		// Every slot of the key is taken by other nodes
+		sched.release(s.FairnessKey, fairnessSlotRetryInterval)
+	}
+}
+
+// acquireSlot takes a free cluster-wide slot of the key of the execution,
+// slots of nodes without heartbeat are taken over
+func (e *executor[T]) acquireSlot(task *Task[T], s *State) (int, bool, error) {
+	keyConcurrency := task.Fairness.KeyConcurrency
+	now := time.Now()
+	offset := rand.IntN(keyConcurrency)
+
+	for i := range keyConcurrency {
+		slot := (offset + i) % keyConcurrency
+
+		res, err := e.storage.Exec(
+			`
+			INSERT INTO fairness_slots (task_name, fairness_key, slot, task_id, node_id, heartbeat_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (task_name, fairness_key, slot)
+			DO UPDATE SET task_id = $4, node_id = $5, heartbeat_at = $6
+			WHERE fairness_slots.heartbeat_at < $7
+			`,
+			task.Name(),
+			s.FairnessKey,
+			slot,
+			s.TaskID,
+			e.nodeID,
+			now,
+			now.Add(-e.slotTimeout),
+		)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			return slot, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// releaseSlot frees a cluster-wide slot, unless it has been taken over
+func (e *executor[T]) releaseSlot(task *Task[T], key string, slot int, taskID uuid.UUID) {
+	_, err := e.storage.Exec(
+		`DELETE FROM fairness_slots WHERE task_name = $1 AND fairness_key = $2 AND slot = $3 AND task_id = $4`,
+		task.Name(),
+		key,
+		slot,
+		taskID,
+	)
+	if err != nil {
+		log.Printf("failed to release fairness slot: %v", err)
+	}
+}
+
+// requeue publishes a received message again at the end of its queue. When the publish
+// fails, messages acknowledged once executed are returned to the broker, auto-acknowledged
+// ones are gone so the execution is marked failed.
+func (e *executor[T]) requeue(task *Task[T], s *State) error {
+	err := e.send(task, s)
+	if err == nil {
+		return nil
+	}
+
+	if task.MaxRetries != 0 {
+		log.Printf("failed to requeue execution, returning it to the broker: %v", err)
+		return broker.ErrRequeue
+	}
+
+	log.Printf("failed to requeue auto-acknowledged execution: %v", err)
+	failPublish(err, s)
+	if err := e.taskLogger.LogTasks(task, s); err != nil {
+		log.Printf("failed to log execution: %v", err)
+	}
+
+	event := &ExecutionEvent{Task: task, State: s, Attempt: s.Iterations, Error: errors.New(s.LastError)}
+	runHooks(e.hooks, func(h FailureHook) { h.OnFailure(event) })
+
+	return nil
+}
+
+// giveBack returns a received execution to the broker when the engine stops before it
+// runs, auto-acknowledged messages are gone so they are published again
+func (e *executor[T]) giveBack(task *Task[T], s *State) error {
+	if task.MaxRetries == 0 {
+		return e.requeue(task, s)
+	}
+	return broker.ErrRequeue
+}
@@ -0,0 +1,270 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/broker"
+)
+
+func TestFairSchedulerGrant(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		fairness    Fairness
+		waiting     map[string]int
+		grants      int
+		granted     map[string]int
+	}{
+		{
+			name:        "round-robin across keys",
+			concurrency: 4,
+			waiting:     map[string]int{"a": 10, "b": 2},
+			grants:      4,
+			granted:     map[string]int{"a": 2, "b": 2},
+		},
+		{
+			name:        "weighted keys",
+			concurrency: 3,
+			fairness:    Fairness{Weights: map[string]int{"a": 2}},
+			waiting:     map[string]int{"a": 10, "b": 10},
+			grants:      3,
+			granted:     map[string]int{"a": 2, "b": 1},
+		},
+		{
+			name:        "capped keys",
+			concurrency: 4,
+			fairness:    Fairness{KeyConcurrency: 1},
+			waiting:     map[string]int{"a": 3, "b": 1},
+			grants:      2,
+			granted:     map[string]int{"a": 1, "b": 1},
+		},
+		{
+			name:        "task concurrency",
+			concurrency: 2,
+			waiting:     map[string]int{"a": 3},
+			grants:      2,
+			granted:     map[string]int{"a": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFairScheduler(tt.concurrency, &tt.fairness)
+
+			waiters := make(map[string][]*fairWaiter)
+			f.mu.Lock()
+			for key, n := range tt.waiting {
+				k := &fairKey{}
+				for range n {
+					w := newFairWaiter()
+					w.granted = make(chan struct{})
+					k.waiting = append(k.waiting, w)
+					waiters[key] = append(waiters[key], w)
+				}
+				f.keys[key] = k
+			}
+			f.grant()
+			f.mu.Unlock()
+
+			granted := make(map[string]int)
+			total := 0
+			for key, ws := range waiters {
+				for _, w := range ws {
+					select {
+					case <-w.granted:
+						granted[key]++
+						total++
+					default:
+					}
+				}
+			}
+
+			if total != tt.grants || f.running != tt.grants {
+				t.Errorf("grants = %d, running = %d, want %d", total, f.running, tt.grants)
+			}
+			for key, n := range tt.granted {
+				if granted[key] != n {
+					t.Errorf("granted %s = %d, want %d", key, granted[key], n)
+				}
+			}
+		})
+	}
+}
+
+func TestFairSchedulerAcquire(t *testing.T) {
+	deferAfter := 20 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		concurrency int
+		fairness    Fairness
+		setup       func(f *fairScheduler)
+		key         string
+		err         error
+	}{
+		{
+			name:        "granted",
+			concurrency: 1,
+			fairness:    Fairness{KeyConcurrency: 1, DeferAfter: deferAfter},
+			key:         "a",
+		},
+		{
+			name:        "blocked by its key",
+			concurrency: 2,
+			fairness:    Fairness{KeyConcurrency: 1, DeferAfter: deferAfter},
+			setup: func(f *fairScheduler) {
+				f.acquire(context.Background(), "a", newFairWaiter())
+			},
+			key: "a",
+			err: errDeferred,
+		},
+		{
+			name:        "cluster-wide slots taken",
+			concurrency: 2,
+			fairness:    Fairness{KeyConcurrency: 2, DeferAfter: deferAfter},
+			setup: func(f *fairScheduler) {
+				f.acquire(context.Background(), "a", newFairWaiter())
+				f.release("a", time.Minute)
+			},
+			key: "a",
+			err: errDeferred,
+		},
+		{
+			name:        "blocked by the task concurrency",
+			concurrency: 1,
+			fairness:    Fairness{KeyConcurrency: 1, DeferAfter: deferAfter},
+			setup: func(f *fairScheduler) {
+				f.acquire(context.Background(), "a", newFairWaiter())
+			},
+			key: "b",
+			err: context.DeadlineExceeded,
+		},
+		{
+			name:        "never deferred without key concurrency",
+			concurrency: 1,
+			fairness:    Fairness{DeferAfter: deferAfter},
+			setup: func(f *fairScheduler) {
+				f.acquire(context.Background(), "a", newFairWaiter())
+			},
+			key: "a",
+			err: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFairScheduler(tt.concurrency, &tt.fairness)
+			if tt.setup != nil {
+				tt.setup(f)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*deferAfter)
+			defer cancel()
+
+			err := f.acquire(ctx, tt.key, newFairWaiter())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			// A waiter giving up is forgotten
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if k, ok := f.keys[tt.key]; ok && len(k.waiting) != 0 {
+				t.Errorf("waiting = %d, want 0", len(k.waiting))
+			}
+		})
+	}
+}
+
+func TestHandleGivesBackOnShutdown(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		err        error
+		published  int
+	}{
+		{name: "acknowledged once executed", maxRetries: 3, err: broker.ErrRequeue},
+		{name: "auto-acknowledged", maxRetries: 0, published: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, b, _ := newTestExecutor(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			e.ctx = ctx
+
+			task := NewTask("mail", func(*Context[any]) error { return nil }, WithMaxRetries(tt.maxRetries), WithFairness("tenant"))
+
+			// The only slot of the task is taken
+			sched := newFairScheduler(1, task.Fairness)
+			if err := sched.acquire(context.Background(), "other", newFairWaiter()); err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := e.newMessage(task.Name(), &State{ID: uuid.New(), TaskID: uuid.New(), FairnessKey: "acme"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cancel()
+			if err := e.handle(task, sched, msg); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if got := len(b.published()); got != tt.published {
+				t.Errorf("published = %d, want %d", got, tt.published)
+			}
+		})
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		publishErr error
+		err        error
+		published  int
+		events     []string
+		status     stateStatus
+	}{
+		{name: "requeued", maxRetries: 3, published: 1},
+		{name: "returned to the broker", maxRetries: 3, publishErr: errors.New("broker down"), err: broker.ErrRequeue},
+		{name: "auto-acknowledged requeued", maxRetries: 0, published: 1},
+		{name: "auto-acknowledged lost", maxRetries: 0, publishErr: errors.New("broker down"), events: []string{"failure"}, status: StatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &recordingHook{}
+			e, b, _ := newTestExecutor(t, hook)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+
+			task := NewTask("mail", func(*Context[any]) error { return nil }, WithMaxRetries(tt.maxRetries))
+			s := &State{ID: uuid.New(), TaskID: uuid.New(), Status: StatusPending}
+
+			if err := e.requeue(task, s); !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.publishErr == nil && len(b.published()) != tt.published {
+				t.Errorf("published = %d, want %d", len(b.published()), tt.published)
+			}
+			if got := hook.recorded(); !slices.Equal(got, tt.events) {
+				t.Errorf("events = %v, want %v", got, tt.events)
+			}
+
+			// Only the executions gone with their message are recorded as failed
+			var last pendingTask
+			for len(e.taskLogger.pending) > 0 {
+				last = <-e.taskLogger.pending
+			}
+			if last.Status != tt.status || last.Final != (tt.status == StatusFailed) {
+				t.Errorf("row = %+v, want status %q", last, tt.status)
+			}
+		})
+	}
+}
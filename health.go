@@ -0,0 +1,217 @@
+package zsched
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vlourme/zsched/pkg/auth"
+	"github.com/vlourme/zsched/pkg/broker"
+)
+
+// backlogThreshold is the ratio of the task logger queue above which the engine is not ready
+const backlogThreshold = 0.9
+
+// HealthCheck is the result of a single check
+type HealthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Health is the result of a liveness or readiness probe
+type Health struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]HealthCheck `json:"checks"`
+	Backlog   int                    `json:"task_logger_backlog"`
+	Consumers []ConsumerStatus       `json:"consumers"`
+}
+
+// ScheduleInfo is a cron schedule of a task and its fire times
+type ScheduleInfo struct {
+	TaskName string    `json:"task_name"`
+	Schedule string    `json:"schedule"`
+	Next     time.Time `json:"next"`
+	Prev     time.Time `json:"prev,omitzero"`
+}
+
+// EngineInfo describes the running engine
+type EngineInfo struct {
+	NodeID           string           `json:"node_id"`
+	Roles            []Role           `json:"roles"`
+	Version          string           `json:"version"`
+	StartedAt        time.Time        `json:"started_at"`
+	Uptime           float64          `json:"uptime"`
+	Tasks            []string         `json:"tasks"`
+	ActiveExecutions int              `json:"active_executions"`
+	Consumers        []ConsumerStatus `json:"consumers"`
+	Schedules        []ScheduleInfo   `json:"schedules"`
+}
+
+// Liveness checks that the consumers are running. External dependencies and the task
+// logger, which backs up when the storage is down, are left to Readiness, so an outage
+// does not restart every replica.
+func (e *Engine[T]) Liveness() Health {
+	health := Health{
+		Status: "ok",
+		Checks: make(map[string]HealthCheck),
+	}
+
+	if e.executor == nil {
+		health.Status = "unavailable"
+		health.Checks["engine"] = HealthCheck{Status: "unavailable", Error: "engine not started"}
+		return health
+	}
+
+	health.Consumers = e.executor.Consumers()
+	health.Checks["consumers"] = HealthCheck{Status: "ok"}
+	for _, c := range health.Consumers {
+		if c.State != consumerRunning {
+			health.Checks["consumers"] = HealthCheck{Status: "unavailable", Error: "consumer of " + c.TaskName + " is " + c.State}
+			break
+		}
+	}
+
+	health.computeStatus()
+	return health
+}
+
+// Readiness checks the liveness of the engine, the backlog of the task logger and the
+// connectivity to the broker and the storage
+func (e *Engine[T]) Readiness(ctx context.Context) Health {
+	health := e.Liveness()
+	if e.executor == nil {
+		return health
+	}
+
+	backlog, capacity := e.executor.taskLogger.Backlog()
+	health.Backlog = backlog
+	health.Checks["task_logger"] = HealthCheck{Status: "ok"}
+	if float64(backlog) >= float64(capacity)*backlogThreshold {
+		health.Checks["task_logger"] = HealthCheck{Status: "unavailable", Error: "task logger backlog is full"}
+	}
+
+	health.Checks["storage"] = HealthCheck{Status: "ok"}
+	if err := e.pingStorage(ctx); err != nil {
+		health.Checks["storage"] = HealthCheck{Status: "unavailable", Error: err.Error()}
+	}
+
+	if pinger, ok := e.broker.(broker.Pinger); ok {
+		health.Checks["broker"] = HealthCheck{Status: "ok"}
+		if err := pinger.Ping(); err != nil {
+			health.Checks["broker"] = HealthCheck{Status: "unavailable", Error: err.Error()}
+		}
+	}
+
+	health.computeStatus()
+	return health
+}
+
+// Info returns the description of the running engine
+func (e *Engine[T]) Info() EngineInfo {
+	info := EngineInfo{
+		NodeID:    e.nodeID,
+		Roles:     e.activeRoles(),
+		Version:   Version(),
+		StartedAt: e.startedAt,
+		Uptime:    time.Since(e.startedAt).Seconds(),
+		Tasks:     slices.Sorted(maps.Keys(e.tasks)),
+		Consumers: make([]ConsumerStatus, 0),
+		Schedules: make([]ScheduleInfo, 0),
+	}
+
+	if e.executor != nil {
+		info.Consumers = e.executor.Consumers()
+		for _, c := range info.Consumers {
+			info.ActiveExecutions += c.Active
+		}
+	}
+
+	for _, entry := range e.cron.Entries() {
+		schedule, ok := e.schedules[entry.ID]
+		if !ok {
+			continue
+		}
+		info.Schedules = append(info.Schedules, ScheduleInfo{
+			TaskName: schedule.taskName,
+			Schedule: schedule.schedule,
+			Next:     entry.Next,
+			Prev:     entry.Prev,
+		})
+	}
+
+	return info
+}
+
+// pingStorage checks the connectivity to the storage
+func (e *Engine[T]) pingStorage(ctx context.Context) error {
+	conn, err := e.storage.Connection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.PingContext(ctx)
+}
+
+// computeStatus sets the global status from the checks
+func (h *Health) computeStatus() {
+	h.Status = "ok"
+	for _, check := range h.Checks {
+		if check.Status != "ok" {
+			h.Status = "unavailable"
+			return
+		}
+	}
+}
+
+// GetHealthz is the liveness probe
+func GetHealthz[T any](c *gin.Context) {
+	engine := c.MustGet("engine").(*Engine[T])
+	writeHealth(c, engine.Liveness())
+}
+
+// GetReadyz is the readiness probe
+func GetReadyz[T any](c *gin.Context) {
+	engine := c.MustGet("engine").(*Engine[T])
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	writeHealth(c, engine.Readiness(ctx))
+}
+
+// GetEngine returns the description of the running engine
+func GetEngine[T any](c *gin.Context) {
+	engine := c.MustGet("engine").(*Engine[T])
+
+	if !canAll(c, auth.PermissionRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	c.JSON(http.StatusOK, engine.Info())
+}
+
+// GetMetrics serves the handler of a MetricsHook, metrics cover every task
+func GetMetrics(handler http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !canAll(c, auth.PermissionRead) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// writeHealth writes the health with a 503 status code when unavailable
+func writeHealth(c *gin.Context, health Health) {
+	if health.Status != "ok" {
+		c.JSON(http.StatusServiceUnavailable, health)
+		return
+	}
+	c.JSON(http.StatusOK, health)
+}
@@ -0,0 +1,64 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		name      string
+		backlog   int
+		consumer  string
+		pingErr   error
+		liveness  string
+		readiness string
+		failed    string
+	}{
+		{name: "healthy", liveness: "ok", readiness: "ok"},
+		{name: "backlog only fails readiness", backlog: 10, liveness: "ok", readiness: "unavailable", failed: "task_logger"},
+		{name: "broker only fails readiness", pingErr: errors.New("down"), liveness: "ok", readiness: "unavailable", failed: "broker"},
+		{name: "stopped consumer fails both", consumer: consumerStopped, liveness: "unavailable", readiness: "unavailable", failed: "consumers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFakeBroker()
+			b.pingErr = tt.pingErr
+
+			pending := make(chan pendingTask, 10)
+			for range tt.backlog {
+				pending <- pendingTask{}
+			}
+
+			state := consumerRunning
+			if tt.consumer != "" {
+				state = tt.consumer
+			}
+
+			e := &Engine[any]{
+				broker:  b,
+				storage: storagetest.New(nil),
+				executor: &executor[any]{
+					taskLogger: &taskLogger[any]{pending: pending},
+					consumers:  map[string]*ConsumerStatus{"mail": {TaskName: "mail", State: state}},
+				},
+			}
+
+			if live := e.Liveness(); live.Status != tt.liveness {
+				t.Errorf("liveness = %+v, want %s", live, tt.liveness)
+			}
+
+			ready := e.Readiness(context.Background())
+			if ready.Status != tt.readiness {
+				t.Errorf("readiness = %+v, want %s", ready, tt.readiness)
+			}
+			if tt.failed != "" && ready.Checks[tt.failed].Status != "unavailable" {
+				t.Errorf("check %s = %+v", tt.failed, ready.Checks[tt.failed])
+			}
+		})
+	}
+}
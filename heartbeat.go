@@ -0,0 +1,196 @@
+package zsched
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+const (
+	// defaultHeartbeatInterval is the default interval between two heartbeats
+	defaultHeartbeatInterval = 10 * time.Second
+
+	// defaultReaperTimeout is the default time without heartbeat after which an execution is lost
+	defaultReaperTimeout = time.Minute
+)
+
+// createHeartbeatTables creates the tables of the node and execution heartbeats
+func createHeartbeatTables(storage storage.Storage) error {
+	if err := createNodesTable(storage); err != nil {
+		return err
+	}
+
+	_, err := storage.Exec(`
+		CREATE TABLE IF NOT EXISTS heartbeats (
+			task_id UUID PRIMARY KEY,
+			state_id UUID,
+			task_name VARCHAR(128),
+			node_id VARCHAR(64),
+			state JSONB,
+			body BYTEA,
+			codec VARCHAR(32),
+			compression VARCHAR(16),
+			heartbeat_at TIMESTAMPTZ
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.Exec(`
+		ALTER TABLE heartbeats
+			ADD COLUMN IF NOT EXISTS body BYTEA,
+			ADD COLUMN IF NOT EXISTS codec VARCHAR(32),
+			ADD COLUMN IF NOT EXISTS compression VARCHAR(16)
+	`)
+	return err
+}
+
+// beginHeartbeat registers a running attempt, it is kept alive by the heartbeats of the node
+func (e *executor[T]) beginHeartbeat(task *Task[T], s *State) {
+	stored, err := e.store(s)
+	if err != nil {
+		log.Printf("failed to encode state for heartbeat: %v", err)
+		return
+	}
+
+	_, err = e.storage.Exec(
+		`
+		INSERT INTO heartbeats (task_id, state_id, task_name, node_id, body, codec, compression, heartbeat_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (task_id)
+		DO UPDATE SET state_id = $2, task_name = $3, node_id = $4, body = $5, codec = $6, compression = $7, heartbeat_at = $8
+		`,
+		s.TaskID,
+		s.ID,
+		task.Name(),
+		e.nodeID,
+		stored.body,
+		stored.codec,
+		string(stored.compression),
+		time.Now(),
+	)
+	if err != nil {
+		log.Printf("failed to register heartbeat: %v", err)
+	}
+}
+
+// endHeartbeat unregisters an attempt once it ended, unless a retry already replaced it
+func (e *executor[T]) endHeartbeat(taskID, stateID uuid.UUID) {
+	if _, err := e.storage.Exec(`DELETE FROM heartbeats WHERE task_id = $1 AND state_id = $2`, taskID, stateID); err != nil {
+		log.Printf("failed to unregister heartbeat: %v", err)
+	}
+}
+
+// heartbeat refreshes the heartbeat of the node and of its running attempts, then reaps
+// the lost executions, until the engine stops
+func (e *Engine[T]) heartbeat() {
+	ticker := time.NewTicker(e.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+
+		if err := e.registerNode(now); err != nil {
+			e.logger.WithError(err).Error("failed to send node heartbeat")
+		}
+
+		_, err := e.storage.Exec(`UPDATE heartbeats SET heartbeat_at = $2 WHERE node_id = $1`, e.nodeID, now)
+		if err != nil {
+			e.logger.WithError(err).Error("failed to send execution heartbeats")
+		}
+
+		_, err = e.storage.Exec(`UPDATE fairness_slots SET heartbeat_at = $2 WHERE node_id = $1`, e.nodeID, now)
+		if err != nil {
+			e.logger.WithError(err).Error("failed to send fairness slot heartbeats")
+		}
+
+		if err := e.refreshLeases(now); err != nil {
+			e.logger.WithError(err).Error("failed to refresh resource leases")
+		}
+
+		if e.reaperTimeout > 0 && e.hasRole(Worker) {
+			if err := e.reap(); err != nil {
+				e.logger.WithError(err).Error("failed to reap lost executions")
+			}
+		}
+
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reap marks the executions without heartbeat since the reaper timeout as lost, and
+// dispatches the auto-acknowledged ones again if enabled. Only the executions of
+// the tasks registered on this node are reaped.
+func (e *Engine[T]) reap() error {
+	rows, err := e.storage.Query(
+		`DELETE FROM heartbeats WHERE heartbeat_at < $1 AND task_name = ANY($2) RETURNING task_name, `+storedColumns+`, heartbeat_at`,
+		time.Now().Add(-e.reaperTimeout),
+		slices.Collect(maps.Keys(e.tasks)),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			taskName    string
+			legacy      []byte
+			stored      storedState
+			heartbeatAt time.Time
+		)
+		if err := rows.Scan(append(append([]any{&taskName}, stored.scan(&legacy)...), &heartbeatAt)...); err != nil {
+			return err
+		}
+
+		s, err := decodeStored(legacy, stored)
+		if err != nil {
+			e.logger.WithError(err).WithField("task_name", taskName).Error("failed to decode lost execution")
+			continue
+		}
+
+		e.executor.lose(e.tasks[taskName], s, heartbeatAt, e.reaperRedispatch)
+	}
+
+	return rows.Err()
+}
+
+// lose marks an attempt as lost. Tasks with retries acknowledge their messages once
+// the attempt ends, so the broker delivers them again and they are never re-dispatched.
+// Tasks without retries auto-acknowledge their messages, which are gone: they are
+// dispatched again only when redispatch is set, regardless of their retry policy.
+func (e *executor[T]) lose(task *Task[T], s *State, heartbeatAt time.Time, redispatch bool) {
+	s.Status = StatusLost
+	s.LastError = "execution lost, last heartbeat at " + heartbeatAt.Format(time.RFC3339)
+
+	e.logger.WithField("task_name", task.Name()).WithField("task_id", s.TaskID.String()).Warn(s.LastError)
+
+	// The execution is published again first, so a failed publish is recorded as its end
+	autoAck := task.MaxRetries == 0
+	s.Final = autoAck
+	if autoAck && redispatch {
+		retried := *s
+		if err := e.Publish(e.retryContext(s, nil), task, &retried); err != nil {
+			log.Printf("failed to re-publish lost task: %v", err)
+		} else {
+			s.Final = false
+		}
+	}
+
+	if err := e.taskLogger.LogTasks(task, s); err != nil {
+		log.Printf("failed to log execution: %v", err)
+	}
+
+	event := &ExecutionEvent{Task: task, State: s, Attempt: s.Iterations, Error: errors.New(s.LastError)}
+	runHooks(e.hooks, func(h LostHook) { h.OnLost(event) })
+}
@@ -0,0 +1,113 @@
+package zsched
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/codec"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestLose(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		redispatch bool
+		publishErr error
+		published  int
+		final      bool
+	}{
+		{name: "auto-acknowledged", maxRetries: 0, final: true},
+		{name: "auto-acknowledged re-dispatched", maxRetries: 0, redispatch: true, published: 1},
+		{name: "re-dispatch failed", maxRetries: 0, redispatch: true, publishErr: errors.New("broker down"), final: true},
+		{name: "delivered again by the broker", maxRetries: 3, redispatch: true},
+		{name: "infinite retries delivered again by the broker", maxRetries: -1, redispatch: true},
+		{name: "retries exhausted", maxRetries: 1, redispatch: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &lostHook{}
+			e, b, _ := newTestExecutor(t, hook)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+
+			task := NewTask("mail", func(*Context[any]) error { return nil }, WithMaxRetries(tt.maxRetries))
+			s := &State{ID: uuid.New(), TaskID: uuid.New(), Iterations: 1, Status: StatusRunning}
+
+			e.lose(task, s, time.Now().Add(-time.Hour), tt.redispatch)
+
+			if got := len(b.published()); got != tt.published {
+				t.Errorf("published = %d, want %d", got, tt.published)
+			}
+			if s.Status != StatusLost || s.Final != tt.final {
+				t.Errorf("status = %s, final = %v, want lost and %v", s.Status, s.Final, tt.final)
+			}
+			if hook.lost != 1 {
+				t.Errorf("lost events = %d, want 1", hook.lost)
+			}
+		})
+	}
+}
+
+// lostHook counts the lost executions
+type lostHook struct {
+	BaseHook
+	lost int
+}
+
+func (h *lostHook) OnLost(*ExecutionEvent) { h.lost++ }
+
+func TestReap(t *testing.T) {
+	tests := []struct {
+		name   string
+		codec  codec.Codec
+		legacy bool
+		body   []byte
+		lost   int
+	}{
+		{name: "json", codec: codec.JSON(), lost: 1},
+		{name: "cbor", codec: codec.CBOR(), lost: 1},
+		{name: "stored in JSON by older nodes", codec: codec.JSON(), legacy: true, lost: 1},
+		{name: "invalid body", codec: codec.JSON(), body: []byte("not json")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &lostHook{}
+			executor, _, s := newTestExecutor(t, hook)
+			executor.codec = tt.codec
+
+			row := append([]any{"mail"}, storedRow(t, executor, tt.legacy)...)
+			if tt.body != nil {
+				row[2] = tt.body
+			}
+			s.SetHandler(func(q storagetest.Query) storagetest.Result {
+				if q.Contains("DELETE FROM heartbeats") {
+					return storagetest.Result{
+						Columns: []string{"task_name", "state", "body", "codec", "compression", "heartbeat_at"},
+						Rows:    [][]any{append(row, time.Now().Add(-time.Hour))},
+					}
+				}
+				return storagetest.Result{}
+			})
+
+			e := &Engine[any]{
+				storage:       s,
+				logger:        executor.logger,
+				executor:      executor,
+				reaperTimeout: time.Minute,
+				tasks:         map[string]*Task[any]{"mail": NewTask("mail", func(*Context[any]) error { return nil }, WithMaxRetries(3))},
+			}
+
+			if err := e.reap(); err != nil {
+				t.Fatal(err)
+			}
+			if hook.lost != tt.lost {
+				t.Errorf("lost events = %d, want %d", hook.lost, tt.lost)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package zsched
 
-import "github.com/vlourme/zsched/pkg/storage"
+import (
+	"net/http"
+	"time"
+
+	"github.com/vlourme/zsched/pkg/storage"
+)
 
 // AnyTask is the interface that represents any task, no matter
 // the inner type of the user context
@@ -8,14 +13,178 @@ type AnyTask interface {
 	Name() string
 }
 
-// Hook is the interface for the hooks
+// TaggedTask is implemented by tasks exposing their tags
+type TaggedTask interface {
+	AnyTask
+	TaskTags() []string
+}
+
+// Hook is the interface for the hooks, they implement one or more of the event
+// interfaces below and only the implemented events are delivered to them.
+// Embed BaseHook to get a no-op Initialize.
 type Hook interface {
-	// Initialize is called when the engine is initialized
+	// Initialize is called when the engine is built
 	Initialize(storage storage.Storage) error
+}
 
+// BaseHook is embedded by hooks without initialization
+type BaseHook struct{}
+
+// Initialize implements Hook
+func (BaseHook) Initialize(storage.Storage) error {
+	return nil
+}
+
+// BeforeExecuteHook is the legacy hook called both when an execution is
+// published and when it starts, prefer PublishHook and StartHook.
+type BeforeExecuteHook interface {
 	// BeforeExecute is called before the task is executed
 	BeforeExecute(task AnyTask, state *State) error
+}
 
+// AfterExecuteHook is the legacy hook called after every attempt,
+// prefer SuccessHook, RetryHook and FailureHook.
+type AfterExecuteHook interface {
 	// AfterExecute is called after the task is executed
 	AfterExecute(task AnyTask, state *State) error
 }
+
+// ExecutionEvent describes a lifecycle event of an execution
+type ExecutionEvent struct {
+	// Task is the executed task
+	Task AnyTask
+
+	// State is the state of the execution, hooks must not modify it
+	State *State
+
+	// Attempt is the attempt number, starting at 1 once the execution started
+	Attempt int
+
+	// Duration is the duration of the attempt, zero before it ends
+	Duration time.Duration
+
+	// Error is the error of the attempt, if any
+	Error error
+}
+
+// PublishHook is called when an execution has been published to the broker
+type PublishHook interface {
+	OnPublish(event *ExecutionEvent)
+}
+
+// StartHook is called when an attempt starts
+type StartHook interface {
+	OnStart(event *ExecutionEvent)
+}
+
+// SuccessHook is called when an attempt succeeds
+type SuccessHook interface {
+	OnSuccess(event *ExecutionEvent)
+}
+
+// RetryHook is called when an attempt fails and the execution will be retried
+type RetryHook interface {
+	OnRetry(event *ExecutionEvent)
+}
+
+// FailureHook is called when the last attempt of an execution fails
+type FailureHook interface {
+	OnFailure(event *ExecutionEvent)
+}
+
+// LostHook is called when the reaper finds an attempt without heartbeat,
+// after its node died or lost access to the storage
+type LostHook interface {
+	OnLost(event *ExecutionEvent)
+}
+
+// ExpireHook is called when an execution is dropped because it expired before being consumed
+type ExpireHook interface {
+	OnExpire(event *ExecutionEvent)
+}
+
+// PanicHook is called when an attempt panics, before OnRetry or OnFailure
+type PanicHook interface {
+	OnPanic(event *ExecutionEvent)
+}
+
+// TimeoutHook is called when an attempt fails after exceeding its timeout, before OnRetry or OnFailure
+type TimeoutHook interface {
+	OnTimeout(event *ExecutionEvent)
+}
+
+// CancelHook is called when an attempt fails after being canceled by the engine
+// shutting down, before OnRetry or OnFailure
+type CancelHook interface {
+	OnCancel(event *ExecutionEvent)
+}
+
+// EngineEvent describes a lifecycle event of the engine
+type EngineEvent struct {
+	// Tasks is the names of the registered tasks
+	Tasks []string
+
+	// Uptime is the time since the engine started, zero on start
+	Uptime time.Duration
+}
+
+// EngineStartHook is called once the engine has started its consumers
+type EngineStartHook interface {
+	OnEngineStart(event *EngineEvent)
+}
+
+// EngineStopHook is called when the engine is closing, before the storage is closed
+type EngineStopHook interface {
+	OnEngineStop(event *EngineEvent)
+}
+
+// ScheduleEvent describes a dispatch of a cron schedule
+type ScheduleEvent struct {
+	// Task is the dispatched task
+	Task AnyTask
+
+	// Schedule is the cron expression that fired
+	Schedule string
+
+	// Error is the error of the dispatch, if any
+	Error error
+}
+
+// ScheduleHook is called when a cron schedule dispatches an execution
+type ScheduleHook interface {
+	OnSchedule(event *ScheduleEvent)
+}
+
+// FlushEvent describes a flush of the task logger to the storage
+type FlushEvent struct {
+	// Size is the number of rows written
+	Size int
+
+	// Duration is the duration of the flush
+	Duration time.Duration
+
+	// Backlog is the number of rows waiting for the next flush
+	Backlog int
+
+	// Error is the error of the flush, if any
+	Error error
+}
+
+// FlushHook is called every time the task logger flushes to the storage
+type FlushHook interface {
+	OnFlush(event *FlushEvent)
+}
+
+// MetricsHook exposes a metrics handler, mounted on /metrics of the API when not nil
+type MetricsHook interface {
+	MetricsHandler() http.Handler
+}
+
+// runHooks calls fn on every hook implementing H
+func runHooks[H any](hooks []Hook, fn func(h H)) {
+	for _, hook := range hooks {
+		if h, ok := hook.(H); ok {
+			fn(h)
+		}
+	}
+}
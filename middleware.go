@@ -0,0 +1,82 @@
+package zsched
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Middleware wraps the action of a task. It can run code around the action,
+// short-circuit it by returning without calling next, enrich the context
+// (e.g. ctx.Logger = ctx.WithField("tenant", id)) or transform the returned error.
+// ctx.Data is rebuilt from ctx.Logger before next runs, set the logger rather than the data.
+type Middleware[T any] func(next taskAction[T]) taskAction[T]
+
+// WithMiddleware adds middlewares to the task, they run inside the global middlewares
+// of the engine, in the given order
+func WithMiddleware[T any](middlewares ...Middleware[T]) func(*taskConfig) {
+	return func(t *taskConfig) {
+		for _, m := range middlewares {
+			t.middlewares = append(t.middlewares, m)
+		}
+	}
+}
+
+// chain wraps the action with the middlewares, the first middleware being the outermost
+func chain[T any](action taskAction[T], middlewares ...Middleware[T]) taskAction[T] {
+	action = syncData(action)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		action = syncData(middlewares[i](action))
+	}
+	return action
+}
+
+// syncData refreshes the data of the context from its logger before running the action,
+// so the fields of a logger replaced by an outer middleware are seen by the inner ones
+func syncData[T any](action taskAction[T]) taskAction[T] {
+	return func(ctx *Context[T]) error {
+		ctx.Data = ctx.Fields()
+		return action(ctx)
+	}
+}
+
+// permanentError is an error that must not be retried
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanentError) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks an error as permanent, the execution fails without being retried.
+// Middlewares may return it to veto an execution.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if the error has been marked with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// taskMiddlewares returns the middlewares of the task, it panics if a middleware
+// has been declared for another user context type
+func taskMiddlewares[T any](t *Task[T]) []Middleware[T] {
+	middlewares := make([]Middleware[T], 0, len(t.middlewares))
+	for _, m := range t.middlewares {
+		mw, ok := m.(Middleware[T])
+		if !ok {
+			panic(fmt.Sprintf("task %s: middleware %T does not match the user context of the task", t.Name(), m))
+		}
+		middlewares = append(middlewares, mw)
+	}
+	return middlewares
+}
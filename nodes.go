@@ -0,0 +1,166 @@
+package zsched
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vlourme/zsched/pkg/auth"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+// nodeTimeoutHeartbeats is the number of missed heartbeats after which a node is not alive
+const nodeTimeoutHeartbeats = 3
+
+// Node is an engine registered in the storage
+type Node struct {
+	// ID is the id of the node, generated on every start
+	ID string `json:"node_id"`
+
+	// Hostname is the hostname of the machine running the node
+	Hostname string `json:"hostname"`
+
+	// Version is the version of zsched of the node
+	Version string `json:"version"`
+
+	// StartedAt is the time the node started
+	StartedAt time.Time `json:"started_at"`
+
+	// HeartbeatAt is the time of the last heartbeat of the node
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+
+	// StoppedAt is the time the node was closed, zero while running or if it died
+	StoppedAt time.Time `json:"stopped_at,omitzero"`
+
+	// Alive is true if the node is running and sent a recent heartbeat
+	Alive bool `json:"alive"`
+
+	// Consumers is the consumed tasks with their concurrency, as of the last heartbeat
+	Consumers []ConsumerStatus `json:"consumers"`
+
+	// ActiveExecutions is the number of running executions, as of the last heartbeat
+	ActiveExecutions int `json:"active_executions"`
+}
+
+// createNodesTable creates the nodes table and forgets the nodes stopped for a week
+func createNodesTable(storage storage.Storage) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS nodes (
+			node_id VARCHAR(64) PRIMARY KEY,
+			hostname VARCHAR(255),
+			version VARCHAR(64),
+			started_at TIMESTAMPTZ,
+			heartbeat_at TIMESTAMPTZ,
+			stopped_at TIMESTAMPTZ,
+			consumers JSONB,
+			active_executions INTEGER
+		)`,
+		`DELETE FROM nodes WHERE heartbeat_at < now() - INTERVAL '7 days'`,
+	}
+
+	for _, query := range queries {
+		if _, err := storage.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerNode registers the node or refreshes its heartbeat and load
+func (e *Engine[T]) registerNode(now time.Time) error {
+	hostname, _ := os.Hostname()
+	info := e.Info()
+
+	consumers, err := json.Marshal(info.Consumers)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.storage.Exec(
+		`
+		INSERT INTO nodes (node_id, hostname, version, started_at, heartbeat_at, consumers, active_executions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (node_id)
+		DO UPDATE SET heartbeat_at = $5, consumers = $6, active_executions = $7
+		`,
+		e.nodeID,
+		hostname,
+		info.Version,
+		e.startedAt,
+		now,
+		string(consumers),
+		info.ActiveExecutions,
+	)
+	return err
+}
+
+// unregisterNode marks the node as stopped
+func (e *Engine[T]) unregisterNode() error {
+	_, err := e.storage.Exec(`UPDATE nodes SET stopped_at = $2, active_executions = 0 WHERE node_id = $1`, e.nodeID, time.Now())
+	return err
+}
+
+// ListNodes returns the registered nodes, most recently started first. A node is
+// alive if it has not been stopped and sent a heartbeat within the timeout.
+func ListNodes(storage storage.Storage, timeout time.Duration) ([]*Node, error) {
+	rows, err := storage.Query(`
+		SELECT node_id, hostname, version, started_at, heartbeat_at, stopped_at, consumers, active_executions
+		FROM nodes
+		ORDER BY started_at DESC
+	`)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to query nodes"), err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*Node, 0)
+	for rows.Next() {
+		var (
+			n         Node
+			stoppedAt *time.Time
+			consumers []byte
+		)
+
+		if err := rows.Scan(&n.ID, &n.Hostname, &n.Version, &n.StartedAt, &n.HeartbeatAt, &stoppedAt, &consumers, &n.ActiveExecutions); err != nil {
+			return nil, errors.Join(errors.New("failed to scan node"), err)
+		}
+
+		if stoppedAt != nil {
+			n.StoppedAt = *stoppedAt
+		}
+
+		n.Consumers = make([]ConsumerStatus, 0)
+		if len(consumers) > 0 {
+			if err := json.Unmarshal(consumers, &n.Consumers); err != nil {
+				return nil, errors.Join(errors.New("failed to decode node consumers"), err)
+			}
+		}
+
+		n.Alive = n.StoppedAt.IsZero() && time.Since(n.HeartbeatAt) < timeout
+		nodes = append(nodes, &n)
+	}
+
+	return nodes, rows.Err()
+}
+
+// GetNodes returns the registered nodes
+func GetNodes[T any](c *gin.Context) {
+	engine := c.MustGet("engine").(*Engine[T])
+
+	if !canAll(c, auth.PermissionRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	nodes, err := ListNodes(engine.storage, engine.heartbeatInterval*nodeTimeoutHeartbeats)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, nodes)
+}
@@ -0,0 +1,90 @@
+package zsched
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestListNodes(t *testing.T) {
+	now := time.Now()
+	timeout := 30 * time.Second
+
+	tests := []struct {
+		name        string
+		heartbeatAt time.Time
+		stoppedAt   any
+		alive       bool
+	}{
+		{name: "running", heartbeatAt: now.Add(-time.Second), alive: true},
+		{name: "missed heartbeats", heartbeatAt: now.Add(-time.Minute)},
+		{name: "stopped", heartbeatAt: now.Add(-time.Second), stoppedAt: now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumers, _ := json.Marshal([]ConsumerStatus{{TaskName: "mail", State: consumerRunning}})
+			s := storagetest.New(func(q storagetest.Query) storagetest.Result {
+				return storagetest.Result{
+					Columns: []string{"node_id", "hostname", "version", "started_at", "heartbeat_at", "stopped_at", "consumers", "active_executions"},
+					Rows:    [][]any{{"node", "host", "dev", now.Add(-time.Hour), tt.heartbeatAt, tt.stoppedAt, consumers, int64(2)}},
+				}
+			})
+
+			nodes, err := ListNodes(s, timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nodes) != 1 {
+				t.Fatalf("nodes = %d, want 1", len(nodes))
+			}
+
+			n := nodes[0]
+			if n.Alive != tt.alive {
+				t.Errorf("alive = %v, want %v", n.Alive, tt.alive)
+			}
+			if n.StoppedAt.IsZero() != (tt.stoppedAt == nil) {
+				t.Errorf("stopped at = %v", n.StoppedAt)
+			}
+			if len(n.Consumers) != 1 || n.Consumers[0].TaskName != "mail" || n.ActiveExecutions != 2 {
+				t.Errorf("node = %+v", n)
+			}
+		})
+	}
+}
+
+func TestRegisterNode(t *testing.T) {
+	s := storagetest.New(nil)
+	e := &Engine[any]{
+		storage:   s,
+		nodeID:    "node",
+		startedAt: time.Now(),
+		cron:      cron.New(),
+		executor: &executor[any]{consumers: map[string]*ConsumerStatus{
+			"mail":   {TaskName: "mail", State: consumerRunning, Active: 2},
+			"export": {TaskName: "export", State: consumerRunning, Active: 1},
+		}},
+	}
+
+	now := time.Now()
+	if err := e.registerNode(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.unregisterNode(); err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := s.Find("INSERT INTO nodes")
+	if len(inserts) != 1 {
+		t.Fatalf("inserts = %d, want 1", len(inserts))
+	}
+	if args := inserts[0].Args; args[0] != "node" || args[4] != now || args[6] != 3 {
+		t.Errorf("args = %v", args)
+	}
+	if len(s.Find("UPDATE nodes SET stopped_at")) != 1 {
+		t.Error("node was not marked as stopped")
+	}
+}
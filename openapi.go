@@ -0,0 +1,418 @@
+package zsched
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/auth"
+)
+
+// pathParamRegex matches gin path parameters such as ":id"
+var pathParamRegex = regexp.MustCompile(`:([a-zA-Z0-9_]+)`)
+
+// routeOperation describes a route of the API in the OpenAPI specification
+type routeOperation struct {
+	ID      string
+	Summary string
+
+	// Response is the schema of the successful response, see responseSchemas
+	Response func(s schemas) map[string]any
+
+	// ContentType is the content type of the successful response, default is application/json
+	ContentType string
+}
+
+// routeOperations describes the routes of the API
+var routeOperations = map[string]routeOperation{
+	"GET /tasks":                      {ID: "list_tasks", Summary: "List registered tasks", Response: arrayOf[Task[any]]},
+	"GET /tasks/:name":                {ID: "get_task", Summary: "Get a task", Response: schemaOf[Task[any]]},
+	"POST /tasks/:name":               {ID: "dispatch_task", Summary: "Dispatch a task", Response: dispatchResponse},
+	"POST /tasks/:name/batch":         {ID: "dispatch_task_batch", Summary: "Dispatch a task once per parameters of a JSON array or NDJSON body", Response: batchResponse},
+	"GET /executions":                 {ID: "list_executions", Summary: "List executions", Response: executionsResponse},
+	"GET /executions/:id":             {ID: "get_execution", Summary: "Get an execution", Response: schemaOf[Execution]},
+	"GET /executions/:id/logs":        {ID: "list_execution_logs", Summary: "Get the logs of an execution", Response: arrayOf[ExecutionLog]},
+	"GET /executions/:id/logs/stream": {ID: "stream_execution_logs", Summary: "Tail the logs of an execution as Server-Sent Events", ContentType: "text/event-stream"},
+	"POST /executions/:id/retry":      {ID: "retry_execution", Summary: "Dispatch a new execution with the same parameters", Response: dispatchResponse},
+	"GET /events":                     {ID: "stream_events", Summary: "Stream status transitions as Server-Sent Events", ContentType: "text/event-stream"},
+	"GET /openapi.json":               {ID: "get_openapi", Summary: "Get the OpenAPI specification", Response: func(schemas) map[string]any { return map[string]any{"type": "object"} }},
+	"GET /healthz":                    {ID: "get_healthz", Summary: "Liveness probe", Response: schemaOf[Health]},
+	"GET /readyz":                     {ID: "get_readyz", Summary: "Readiness probe", Response: schemaOf[Health]},
+	"GET /engine":                     {ID: "get_engine", Summary: "Describe the running engine", Response: schemaOf[EngineInfo]},
+	"GET /nodes":                      {ID: "list_nodes", Summary: "List the registered nodes with their consumers and load", Response: arrayOf[Node]},
+	"GET /metrics":                    {ID: "get_metrics", Summary: "Get the metrics in the Prometheus format", ContentType: "text/plain"},
+}
+
+// executionQueryParameters are the filters accepted by the executions listing routes
+var executionQueryParameters = []map[string]any{
+	queryParameter("task_name", "Filter by task name"),
+	queryParameter("status", "Filter by comma separated statuses"),
+	queryParameter("parent_id", "Filter by parent execution"),
+	queryParameter("since", "Only executions published after this RFC3339 time"),
+	queryParameter("before", "The next_cursor of the previous page, or an RFC3339 time"),
+	queryParameter("limit", "Maximum number of executions to return"),
+}
+
+// newOpenAPISpec generates the OpenAPI specification of the router and the registered tasks
+func newOpenAPISpec[T any](routes gin.RoutesInfo, tasks map[string]*Task[T], secured bool) map[string]any {
+	paths := make(map[string]map[string]any)
+	components := schemas{"Error": map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"error": map[string]any{"type": "string"}},
+	}}
+
+	for _, route := range routes {
+		path := pathParamRegex.ReplaceAllString(route.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+
+		op := routeOperations[route.Method+" "+route.Path]
+		operation := map[string]any{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"responses":   responses(components, op),
+		}
+		if route.Path == "/healthz" || route.Path == "/readyz" {
+			operation["security"] = []map[string]any{}
+		}
+
+		parameters := make([]map[string]any, 0)
+		for _, match := range pathParamRegex.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		if route.Method == http.MethodGet && route.Path == "/executions" {
+			parameters = append(parameters, executionQueryParameters...)
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if route.Method == http.MethodPost && route.Path == "/tasks/:name" {
+			operation["requestBody"] = requestBody(map[string]any{"type": "object"})
+		}
+		if route.Method == http.MethodPost && route.Path == "/tasks/:name/batch" {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json":     map[string]any{"schema": map[string]any{"type": "array", "items": map[string]any{"type": "object"}}},
+					"application/x-ndjson": map[string]any{"schema": map[string]any{"type": "object"}},
+				},
+			}
+		}
+
+		paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	// Every task gets its own dispatch operation, typed with its parameters
+	for _, name := range slices.Sorted(maps.Keys(tasks)) {
+		t := tasks[name]
+
+		paths["/tasks/"+name] = map[string]any{
+			"post": map[string]any{
+				"operationId": "dispatch_" + strings.NewReplacer("-", "_", ".", "_").Replace(name),
+				"summary":     fmt.Sprintf("Dispatch the %s task", name),
+				"tags":        t.Tags,
+				"requestBody": requestBody(dispatchSchema(t.parametersSchema())),
+				"responses":   responses(components, routeOperations["POST /tasks/:name"]),
+			},
+		}
+	}
+
+	spec := map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "zsched",
+			"version": Version(),
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": map[string]any(components),
+		},
+	}
+
+	if secured {
+		// A signed request carries the three HMAC headers, they form a single requirement
+		spec["components"].(map[string]any)["securitySchemes"] = map[string]any{
+			"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			"hmacKey": map[string]any{
+				"type": "apiKey", "in": "header", "name": auth.HeaderKeyID,
+				"description": "Id of the HMAC key",
+			},
+			"hmacTimestamp": map[string]any{
+				"type": "apiKey", "in": "header", "name": auth.HeaderTimestamp,
+				"description": "Unix timestamp of the signature",
+			},
+			"hmacSignature": map[string]any{
+				"type": "apiKey", "in": "header", "name": auth.HeaderSignature,
+				"description": "Hex HMAC-SHA256 of the method, the request URI, the timestamp and the hex SHA-256 of the body, separated by new lines",
+			},
+		}
+		spec["security"] = []map[string]any{
+			{"apiKey": []string{}},
+			{"bearer": []string{}},
+			{"hmacKey": []string{}, "hmacTimestamp": []string{}, "hmacSignature": []string{}},
+		}
+	}
+
+	return spec
+}
+
+// parametersSchema returns the JSON schema of the task parameters, inferred
+// from the default parameters when no schema has been set
+func (t *Task[T]) parametersSchema() map[string]any {
+	if t.ParameterSchema != nil {
+		return t.ParameterSchema
+	}
+
+	properties := make(map[string]any, len(t.DefaultParameters))
+	for name, value := range t.DefaultParameters {
+		schema := inferSchema(value)
+		schema["default"] = value
+		properties[name] = schema
+	}
+
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// inferSchema infers the JSON schema type of a value
+func inferSchema(value any) map[string]any {
+	if value == nil {
+		return map[string]any{}
+	}
+
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array"}
+	case reflect.Map, reflect.Struct:
+		return map[string]any{"type": "object"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// queryParameter describes an optional string query parameter
+func queryParameter(name, description string) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"schema":      map[string]any{"type": "string"},
+	}
+}
+
+// requestBody describes a JSON request body with the given schema
+func requestBody(schema map[string]any) map[string]any {
+	return map[string]any{
+		"required": true,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schema},
+		},
+	}
+}
+
+// dispatchSchema describes a dispatch body, either the parameters or
+// an object with the parameters and the dispatch options
+func dispatchSchema(parameters map[string]any) map[string]any {
+	return map[string]any{
+		"anyOf": []map[string]any{
+			parameters,
+			{
+				"type":     "object",
+				"required": []string{"parameters"},
+				"properties": map[string]any{
+					"parameters":      parameters,
+					"priority":        map[string]any{"type": "integer", "minimum": 0, "maximum": 255},
+					"fairness_key":    map[string]any{"type": "string"},
+					"delay":           map[string]any{"type": "string", "description": "Duration such as 30s"},
+					"eta":             map[string]any{"type": "string", "format": "date-time"},
+					"ttl":             map[string]any{"type": "string", "description": "Duration such as 5m"},
+					"expires_at":      map[string]any{"type": "string", "format": "date-time"},
+					"idempotency_key": map[string]any{"type": "string"},
+					"headers":         map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+					"correlation_id":  map[string]any{"type": "string"},
+					"queue":           map[string]any{"type": "string"},
+				},
+			},
+		},
+	}
+}
+
+// responses describes the responses of an operation
+func responses(s schemas, op routeOperation) map[string]any {
+	contentType := op.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	schema := map[string]any{"type": "string"}
+	if op.Response != nil {
+		schema = op.Response(s)
+	}
+
+	errorResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+			},
+		}
+	}
+
+	responses := map[string]any{
+		"200": map[string]any{
+			"description": "OK",
+			"content":     map[string]any{contentType: map[string]any{"schema": schema}},
+		},
+		"400": errorResponse("Bad Request"),
+		"401": errorResponse("Unauthorized"),
+		"403": errorResponse("Forbidden"),
+		"404": errorResponse("Not Found"),
+		"500": errorResponse("Internal Server Error"),
+	}
+	if op.ID == "get_healthz" || op.ID == "get_readyz" {
+		responses["503"] = map[string]any{
+			"description": "Service Unavailable",
+			"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+		}
+	}
+
+	return responses
+}
+
+// dispatchResponse describes the response of a dispatch
+func dispatchResponse(schemas) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"message": map[string]any{"type": "string"},
+			"task_id": map[string]any{"type": "string", "format": "uuid"},
+		},
+	}
+}
+
+// batchResponse describes the response of a batch dispatch
+func batchResponse(schemas) map[string]any {
+	return map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"dispatched": map[string]any{"type": "integer"}},
+	}
+}
+
+// executionsResponse describes a page of executions
+func executionsResponse(s schemas) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"executions":  arrayOf[Execution](s),
+			"next_cursor": map[string]any{"type": "string", "description": "Cursor of the next page, empty on the last page"},
+		},
+	}
+}
+
+// schemas is the schemas of the components of the specification, by name
+type schemas map[string]any
+
+// schemaOf returns a reference to the schema of a type, added to the components
+func schemaOf[V any](s schemas) map[string]any {
+	return s.schema(reflect.TypeFor[V]())
+}
+
+// arrayOf returns the schema of an array of a type
+func arrayOf[V any](s schemas) map[string]any {
+	return map[string]any{"type": "array", "items": schemaOf[V](s)}
+}
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	uuidType     = reflect.TypeFor[uuid.UUID]()
+	durationType = reflect.TypeFor[time.Duration]()
+)
+
+// schema returns the schema of a type from its JSON encoding, structs are
+// added to the components and referenced
+func (s schemas) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]any{"type": "string", "format": "uuid"}
+	case durationType:
+		return map[string]any{"type": "integer", "description": "Duration in nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.schema(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if i := strings.IndexByte(name, '['); i >= 0 {
+			name = name[:i]
+		}
+		name = strings.ToUpper(name[:1]) + name[1:]
+
+		ref := map[string]any{"$ref": "#/components/schemas/" + name}
+		if _, ok := s[name]; ok {
+			return ref
+		}
+
+		// The name is reserved before the fields are described, for recursive types
+		properties := make(map[string]any)
+		s[name] = map[string]any{"type": "object", "properties": properties}
+		s.properties(t, properties)
+
+		return ref
+	case reflect.Interface:
+		return map[string]any{}
+	default:
+		return inferSchema(reflect.Zero(t).Interface())
+	}
+}
+
+// properties adds the exported fields of a struct to properties, as encoded by encoding/json
+func (s schemas) properties(t reflect.Type, properties map[string]any) {
+	for _, f := range reflect.VisibleFields(t) {
+		if f.Anonymous || !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		properties[name] = s.schema(f.Type)
+	}
+}
@@ -0,0 +1,116 @@
+package zsched
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewOpenAPISpec(t *testing.T) {
+	router := gin.New()
+	for route := range routeOperations {
+		method, path, _ := strings.Cut(route, " ")
+		router.Handle(method, path, func(*gin.Context) {})
+	}
+
+	tasks := map[string]*Task[any]{
+		"send-mail": NewTask("send-mail", func(*Context[any]) error { return nil }, WithDefaultParameters(map[string]any{"to": ""})),
+	}
+
+	spec := newOpenAPISpec(router.Routes(), tasks, true)
+	paths := spec["paths"].(map[string]map[string]any)
+	components := spec["components"].(map[string]any)
+	schemas := components["schemas"].(map[string]any)
+
+	// Every operation has a unique id and its responses reference existing schemas
+	ids := make(map[string]string)
+	for path, operations := range paths {
+		for method, op := range operations {
+			operation := op.(map[string]any)
+			id, _ := operation["operationId"].(string)
+			if id == "" {
+				t.Errorf("%s %s has no operationId", method, path)
+			}
+			if other, ok := ids[id]; ok {
+				t.Errorf("%s %s and %s share the operationId %s", method, path, other, id)
+			}
+			ids[id] = method + " " + path
+
+			ok := operation["responses"].(map[string]any)["200"].(map[string]any)
+			for _, content := range ok["content"].(map[string]any) {
+				checkRefs(t, content, schemas)
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		schema   string
+		property string
+		want     any
+	}{
+		{name: "execution id", schema: "Execution", property: "task_id", want: "uuid"},
+		{name: "execution time", schema: "Execution", property: "published_at", want: "date-time"},
+		{name: "promoted task field", schema: "Task", property: "max_retries", want: "integer"},
+		{name: "task name", schema: "Task", property: "name", want: "string"},
+		{name: "node", schema: "Node", property: "alive", want: "boolean"},
+		{name: "log", schema: "ExecutionLog", property: "id", want: "uuid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, ok := schemas[tt.schema].(map[string]any)
+			if !ok {
+				t.Fatalf("schema %s is missing", tt.schema)
+			}
+			property, ok := schema["properties"].(map[string]any)[tt.property].(map[string]any)
+			if !ok {
+				t.Fatalf("property %s.%s is missing", tt.schema, tt.property)
+			}
+			if property["format"] != tt.want && property["type"] != tt.want {
+				t.Errorf("%s.%s = %v, want %v", tt.schema, tt.property, property, tt.want)
+			}
+		})
+	}
+
+	if _, ok := schemas["Task"].(map[string]any)["properties"].(map[string]any)["Action"]; ok {
+		t.Error("Task schema describes the action")
+	}
+
+	if paths["/tasks/send-mail"]["post"].(map[string]any)["operationId"] != "dispatch_send_mail" {
+		t.Errorf("task operation = %v", paths["/tasks/send-mail"])
+	}
+	if security := paths["/healthz"]["get"].(map[string]any)["security"]; security == nil {
+		t.Error("/healthz requires authentication")
+	}
+
+	hmac := spec["security"].([]map[string]any)[2]
+	if _, ok := hmac["hmacSignature"]; !ok || len(hmac) != 3 {
+		t.Errorf("hmac requirement = %v", hmac)
+	}
+	if _, ok := components["securitySchemes"].(map[string]any)["hmacSignature"]; !ok {
+		t.Error("hmac scheme is missing")
+	}
+}
+
+// checkRefs fails the test if a $ref of the value does not resolve to a schema
+func checkRefs(t *testing.T, value any, schemas map[string]any) {
+	t.Helper()
+
+	switch v := value.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"].(string); ok {
+			if _, ok := schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; !ok {
+				t.Errorf("unresolved %s", ref)
+			}
+		}
+		for _, child := range v {
+			checkRefs(t, child, schemas)
+		}
+	case []any:
+		for _, child := range v {
+			checkRefs(t, child, schemas)
+		}
+	}
+}
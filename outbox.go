@@ -0,0 +1,244 @@
+package zsched
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+const (
+	// outboxPollInterval is the interval between two relays of the outbox when no dispatch wakes it up
+	outboxPollInterval = time.Second
+
+	// outboxBatchSize is the maximum number of dispatches relayed together
+	outboxBatchSize = 500
+
+	// outboxPublishTimeout is the maximum time the broker takes to confirm a relayed batch,
+	// or a batch of due delayed executions
+	outboxPublishTimeout = 30 * time.Second
+)
+
+// insertOutboxQuery inserts a dispatch into the outbox
+const insertOutboxQuery = `INSERT INTO outbox (state_id, task_name, body, codec, compression, created_at) VALUES ($1, $2, $3, $4, $5, $6)`
+
+// createOutboxTable creates the outbox table, sent dispatches are deleted by the cleanup after a week
+func createOutboxTable(storage storage.Storage) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS outbox (
+			id BIGSERIAL PRIMARY KEY,
+			state_id UUID,
+			task_name VARCHAR(128),
+			state JSONB,
+			body BYTEA,
+			codec VARCHAR(32),
+			compression VARCHAR(16),
+			created_at TIMESTAMPTZ,
+			sent_at TIMESTAMPTZ
+		)`,
+		`ALTER TABLE outbox
+			ADD COLUMN IF NOT EXISTS body BYTEA,
+			ADD COLUMN IF NOT EXISTS codec VARCHAR(32),
+			ADD COLUMN IF NOT EXISTS compression VARCHAR(16)`,
+		`CREATE INDEX IF NOT EXISTS outbox_unsent_idx ON outbox (id) WHERE sent_at IS NULL`,
+	}
+
+	for _, query := range queries {
+		if _, err := storage.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExecuteTx executes the task once within a transaction of the storage database, the execution is
+// written to the outbox and published by the relay only if the transaction commits.
+// The publish hooks run when the execution is written to the transaction, an error of a
+// BeforeExecute hook rejects the dispatch so the caller can roll back.
+func (t *Task[T]) ExecuteTx(tx *sql.Tx, params map[string]any, opts ...DispatchOption) error {
+	return t.executeTx(context.Background(), tx, params, opts...)
+}
+
+// executeTx writes a new execution of the task and its outbox or delayed row in the transaction
+func (t *Task[T]) executeTx(ctx context.Context, tx *sql.Tx, params map[string]any, opts ...DispatchOption) (err error) {
+	state, err := t.newDispatchState(params, opts...)
+	if err != nil {
+		return err
+	}
+	if state.IdempotencyKey != "" {
+		return fmt.Errorf("%w: idempotency keys are not supported by transactions", ErrInvalidDispatch)
+	}
+
+	state.ID = uuid.New()
+
+	span := t.executor.startPublishSpan(ctx, t, state)
+	defer func() { endSpan(span, "", err) }()
+
+	if err := t.executor.offload(state); err != nil {
+		return err
+	}
+
+	stored, err := t.executor.store(state)
+	if err != nil {
+		return err
+	}
+
+	pending, err := newPendingTask(t, state)
+	if err != nil {
+		return err
+	}
+	if err := t.executor.runBeforeExecuteHooks(t, state); err != nil {
+		t.executor.releaseParameters(state)
+		return errors.Join(errors.New("dispatch rejected by a hook"), err)
+	}
+
+	if _, err := tx.ExecContext(ctx, upsertTaskQuery, pending.args()...); err != nil {
+		return errors.Join(errors.New("failed to log execution"), err)
+	}
+
+	if now := time.Now(); state.NotBefore.After(now) {
+		_, err = tx.ExecContext(ctx, insertDelayedQuery, stored.args(state.ID, t.Name(), state.NotBefore)...)
+	} else {
+		_, err = tx.ExecContext(ctx, insertOutboxQuery, stored.args(state.ID, t.Name(), now)...)
+	}
+	if err != nil {
+		return errors.Join(errors.New("failed to write execution to the outbox"), err)
+	}
+
+	// The relay does not run them again, so they run once per dispatch like with WithOutbox
+	runHooks(t.executor.hooks, func(h PublishHook) {
+		h.OnPublish(&ExecutionEvent{Task: t, State: state, Attempt: state.Iterations})
+	})
+
+	return nil
+}
+
+// enqueue writes an execution to the outbox and wakes the relay up
+func (e *executor[T]) enqueue(task *Task[T], s *State) error {
+	stored, err := e.store(s)
+	if err != nil {
+		return err
+	}
+
+	if _, err := e.storage.Exec(insertOutboxQuery, stored.args(s.ID, task.Name(), time.Now())...); err != nil {
+		return errors.Join(errors.New("failed to write execution to the outbox"), err)
+	}
+
+	e.wakeRelay()
+	return nil
+}
+
+// wakeRelay makes the relay publish the outbox without waiting for the next poll
+func (e *executor[T]) wakeRelay() {
+	select {
+	case e.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// relayOutbox publishes the dispatches of the outbox until the engine stops
+func (e *Engine[T]) relayOutbox() {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-e.executor.outboxWake:
+		}
+
+		// Full batches are followed by another one right away
+		for {
+			relayed, err := e.relay()
+			if err != nil {
+				e.logger.WithError(err).Error("failed to relay outbox")
+				break
+			}
+			if relayed < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// relay publishes a batch of unsent dispatches and marks them sent in the same transaction.
+// Nodes relay distinct rows, a dispatch published by a relay that failed to commit is
+// published again, so delivery is at least once.
+func (e *Engine[T]) relay() (int, error) {
+	conn, err := e.storage.Connection()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(e.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		e.ctx,
+		`SELECT id, task_name, `+storedColumns+` FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`,
+		outboxBatchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]int64, 0, outboxBatchSize)
+	msgs := make([]broker.Message, 0, outboxBatchSize)
+	for rows.Next() {
+		var (
+			id       int64
+			taskName string
+			legacy   []byte
+			stored   storedState
+		)
+		if err := rows.Scan(append([]any{&id, &taskName}, stored.scan(&legacy)...)...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		// Invalid dispatches are marked sent too, so they do not block the outbox
+		ids = append(ids, id)
+
+		s, err := decodeStored(legacy, stored)
+		if err == nil {
+			var msg broker.Message
+			if msg, err = e.executor.newMessage(taskName, s); err == nil {
+				msgs = append(msgs, msg)
+				continue
+			}
+		}
+		e.logger.WithError(err).WithField("task_name", taskName).Error("dropped invalid outbox dispatch")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, outboxPublishTimeout)
+	defer cancel()
+	if err := e.executor.sendMessages(ctx, msgs); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(e.ctx, `UPDATE outbox SET sent_at = $2 WHERE id = ANY($1)`, ids, time.Now()); err != nil {
+		return 0, err
+	}
+
+	return len(ids), tx.Commit()
+}
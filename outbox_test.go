@@ -0,0 +1,171 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestExecuteTx(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []DispatchOption
+		failQuery string
+		rejectErr error
+		table     string
+		err       string
+		published int
+	}{
+		{name: "outbox", table: "outbox", published: 1},
+		{name: "delayed", opts: []DispatchOption{Delay(time.Hour)}, table: "delayed_executions", published: 1},
+		{name: "rejected by a hook", rejectErr: errors.New("quota exceeded"), err: "quota exceeded"},
+		{name: "idempotency key", opts: []DispatchOption{IdempotencyKey("key")}, err: "idempotency keys are not supported"},
+		{name: "outbox write failure", failQuery: "INSERT INTO outbox", err: "failed to write execution to the outbox"},
+		{name: "log failure", failQuery: "INSERT INTO tasks", err: "failed to log execution"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &publishHook{err: tt.rejectErr}
+			e, _, s := newTestExecutor(t, hook)
+			s.SetHandler(func(q storagetest.Query) storagetest.Result {
+				if tt.failQuery != "" && q.Contains(tt.failQuery) {
+					return storagetest.Result{Err: errors.New("storage down")}
+				}
+				return storagetest.Result{}
+			})
+
+			task := NewTask("mail", func(*Context[any]) error { return nil })
+			task.executor = e
+
+			tx, err := s.DB().Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = task.ExecuteTx(tx, map[string]any{"to": "a@b.c"}, tt.opts...)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				if err := tx.Rollback(); err != nil {
+					t.Fatal(err)
+				}
+				if len(s.Find("INSERT INTO outbox")) != 0 && tt.failQuery == "" {
+					t.Error("execution written to the outbox")
+				}
+				if hook.published != 0 {
+					t.Errorf("published events = %d, want 0", hook.published)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The execution and its dispatch are written in the transaction, nothing is published
+			queries := s.Queries()
+			if len(queries) != 3 || !queries[0].Contains("INSERT INTO tasks") || !queries[1].Contains("INSERT INTO "+tt.table) || queries[2].SQL != "COMMIT" {
+				t.Fatalf("queries = %v", queries)
+			}
+			if queries[0].Args[1] != StatusPending {
+				t.Errorf("status = %v, want %s", queries[0].Args[1], StatusPending)
+			}
+			if name := queries[1].Args[1]; name != "mail" {
+				t.Errorf("task name = %v, want mail", name)
+			}
+
+			// The publish hooks run once the execution is written to the transaction
+			if hook.before != 1 || hook.published != tt.published {
+				t.Errorf("before execute = %d, published = %d, want 1 and %d", hook.before, hook.published, tt.published)
+			}
+		})
+	}
+}
+
+// publishHook counts the publish hooks, BeforeExecute fails with err
+type publishHook struct {
+	BaseHook
+	err       error
+	before    int
+	published int
+}
+
+func (h *publishHook) BeforeExecute(AnyTask, *State) error {
+	h.before++
+	return h.err
+}
+
+func (h *publishHook) OnPublish(*ExecutionEvent) { h.published++ }
+
+func TestRelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		tasks      []string
+		publishErr error
+		published  int
+		sent       int
+		end        string
+		err        bool
+	}{
+		{name: "published then marked sent", tasks: []string{"mail", "mail"}, published: 2, sent: 2, end: "COMMIT"},
+		{name: "kept when the broker fails", tasks: []string{"mail"}, publishErr: errors.New("broker down"), end: "ROLLBACK", err: true},
+		{name: "invalid dispatch marked sent", tasks: []string{"mail", "invalid"}, published: 1, sent: 2, end: "COMMIT"},
+		{name: "nothing to relay", end: "ROLLBACK"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor, b, s := newTestExecutor(t)
+			b.publishFn = func(broker.Message) error { return tt.publishErr }
+
+			rows := make([][]any, len(tt.tasks))
+			for i, name := range tt.tasks {
+				rows[i] = append([]any{int64(i + 1), "mail"}, storedRow(t, executor, false)...)
+				if name == "invalid" {
+					rows[i][3] = []byte("not json")
+				}
+			}
+			s.SetHandler(func(q storagetest.Query) storagetest.Result {
+				if q.Contains("FROM outbox WHERE sent_at IS NULL") {
+					return storagetest.Result{Columns: []string{"id", "task_name", "state", "body", "codec", "compression"}, Rows: rows}
+				}
+				return storagetest.Result{}
+			})
+
+			e := &Engine[any]{
+				ctx:      context.Background(),
+				storage:  s,
+				broker:   b,
+				logger:   executor.logger,
+				executor: executor,
+			}
+
+			relayed, err := e.relay()
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if relayed != tt.sent {
+				t.Errorf("relayed = %d, want %d", relayed, tt.sent)
+			}
+			if got := len(b.published()); got != tt.published {
+				t.Errorf("messages = %d, want %d", got, tt.published)
+			}
+			if updates := s.Find("UPDATE outbox SET sent_at"); (len(updates) == 1) != (tt.sent > 0) {
+				t.Errorf("updates = %v, want %d rows marked sent", updates, tt.sent)
+			}
+
+			queries := s.Queries()
+			if last := queries[len(queries)-1].SQL; last != tt.end {
+				t.Errorf("last query = %s, want %s", last, tt.end)
+			}
+		})
+	}
+}
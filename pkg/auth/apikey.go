@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// APIKeyAuthenticator authenticates requests with static API keys, passed
+// either in the X-API-Key header or as a bearer token
+type APIKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]*Principal
+}
+
+// NewAPIKeyAuthenticator creates a new API key authenticator
+func NewAPIKeyAuthenticator() *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		keys: make(map[[sha256.Size]byte]*Principal),
+	}
+}
+
+// Add adds an API key with the given grants, see ParseGrant
+func (a *APIKeyAuthenticator) Add(key string, subject string, grants ...string) error {
+	principal, err := NewPrincipal(subject, grants...)
+	if err != nil {
+		return err
+	}
+
+	a.keys[sha256.Sum256([]byte(key))] = principal
+	return nil
+}
+
+// Authenticate implements Authenticator
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = bearerToken(r)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	// Keys are stored hashed and compared in constant time
+	hash := sha256.Sum256([]byte(key))
+	for h, principal := range a.keys {
+		if subtle.ConstantTimeCompare(h[:], hash[:]) == 1 {
+			return principal, nil
+		}
+	}
+
+	// A bearer token might be a JWT for another authenticator
+	if r.Header.Get("X-API-Key") == "" {
+		return nil, ErrNoCredentials
+	}
+
+	return nil, ErrInvalidCredentials
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials is returned by an authenticator when the request does not carry its credentials
+	ErrNoCredentials = errors.New("no credentials")
+
+	// ErrInvalidCredentials is returned when the credentials are present but invalid
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator authenticates an HTTP request
+type Authenticator interface {
+	// Authenticate returns the principal of the request, or ErrNoCredentials
+	// when the request does not carry credentials for this authenticator
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Permission is a level of access, each level includes the previous ones
+type Permission int
+
+const (
+	// PermissionRead allows reading tasks, executions and logs
+	PermissionRead Permission = iota + 1
+
+	// PermissionDispatch allows dispatching and retrying tasks
+	PermissionDispatch
+
+	// PermissionAdmin allows destructive operations such as purging executions
+	PermissionAdmin
+)
+
+// String returns the name of the permission
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionDispatch:
+		return "dispatch"
+	case PermissionAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// Grant gives a permission on every task, a single task or tasks with a tag
+type Grant struct {
+	// Permission is the granted permission
+	Permission Permission
+
+	// Task restricts the grant to a task name
+	Task string
+
+	// Tag restricts the grant to tasks with the given tag
+	Tag string
+}
+
+// ParseGrant parses a grant from its string representation:
+// "read", "dispatch:task:<name>", "admin:tag:<tag>" or "*" for admin on everything.
+func ParseGrant(s string) (Grant, error) {
+	if s == "*" {
+		return Grant{Permission: PermissionAdmin}, nil
+	}
+
+	parts := strings.SplitN(s, ":", 3)
+
+	var g Grant
+	switch parts[0] {
+	case "read":
+		g.Permission = PermissionRead
+	case "dispatch":
+		g.Permission = PermissionDispatch
+	case "admin":
+		g.Permission = PermissionAdmin
+	default:
+		return g, fmt.Errorf("invalid grant %q: unknown permission", s)
+	}
+
+	switch {
+	case len(parts) == 1:
+	case len(parts) == 3 && parts[1] == "task" && parts[2] != "":
+		g.Task = parts[2]
+	case len(parts) == 3 && parts[1] == "tag" && parts[2] != "":
+		g.Tag = parts[2]
+	default:
+		return g, fmt.Errorf("invalid grant %q: expected <permission>[:task|tag:<name>]", s)
+	}
+
+	return g, nil
+}
+
+// ParseGrants parses many grants, see ParseGrant
+func ParseGrants(grants ...string) ([]Grant, error) {
+	parsed := make([]Grant, 0, len(grants))
+	for _, s := range grants {
+		g, err := ParseGrant(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, g)
+	}
+	return parsed, nil
+}
+
+// Principal is an authenticated caller
+type Principal struct {
+	// Subject identifies the caller
+	Subject string
+
+	// Grants is the permissions of the caller
+	Grants []Grant
+}
+
+// NewPrincipal creates a principal from grants in their string representation
+func NewPrincipal(subject string, grants ...string) (*Principal, error) {
+	parsed, err := ParseGrants(grants...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{Subject: subject, Grants: parsed}, nil
+}
+
+// Can returns true if the principal has the permission on the task
+func (p *Principal) Can(permission Permission, task string, tags []string) bool {
+	for _, g := range p.Grants {
+		if g.Permission < permission {
+			continue
+		}
+
+		switch {
+		case g.Task == "" && g.Tag == "":
+			return true
+		case g.Task != "" && g.Task == task:
+			return true
+		case g.Tag != "" && slices.Contains(tags, g.Tag):
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAll returns true if the principal has the permission on every task
+func (p *Principal) CanAll(permission Permission) bool {
+	for _, g := range p.Grants {
+		if g.Permission >= permission && g.Task == "" && g.Tag == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticate tries every authenticator in order and returns the first principal found
+func Authenticate(r *http.Request, authenticators ...Authenticator) (*Principal, error) {
+	for _, a := range authenticators {
+		principal, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return principal, nil
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// bearerToken returns the bearer token of the request, if any
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// HeaderKeyID is the header carrying the id of the HMAC key
+	HeaderKeyID = "X-Zsched-Key"
+
+	// HeaderTimestamp is the header carrying the unix timestamp of the signature
+	HeaderTimestamp = "X-Zsched-Timestamp"
+
+	// HeaderSignature is the header carrying the hex encoded HMAC-SHA256 signature
+	HeaderSignature = "X-Zsched-Signature"
+
+	// defaultMaxBodySize is the default size of the largest body verified
+	defaultMaxBodySize = 10 << 20
+)
+
+type hmacKey struct {
+	secret    []byte
+	principal *Principal
+}
+
+// HMACAuthenticator authenticates requests signed with a shared secret, see SignRequest
+type HMACAuthenticator struct {
+	keys        map[string]hmacKey
+	maxSkew     time.Duration
+	maxBodySize int64
+}
+
+// WithMaxBodySize sets the size of the largest body verified, larger requests
+// are rejected with an *http.MaxBytesError, default is 10 MiB
+func WithMaxBodySize(size int64) func(*HMACAuthenticator) {
+	return func(a *HMACAuthenticator) {
+		a.maxBodySize = size
+	}
+}
+
+// NewHMACAuthenticator creates a new HMAC authenticator, signatures older than
+// maxSkew are rejected to prevent replays
+func NewHMACAuthenticator(maxSkew time.Duration, opts ...func(*HMACAuthenticator)) *HMACAuthenticator {
+	a := &HMACAuthenticator{
+		keys:        make(map[string]hmacKey),
+		maxSkew:     maxSkew,
+		maxBodySize: defaultMaxBodySize,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// Add adds a signing key with the given grants, see ParseGrant
+func (a *HMACAuthenticator) Add(keyID string, secret []byte, grants ...string) error {
+	principal, err := NewPrincipal(keyID, grants...)
+	if err != nil {
+		return err
+	}
+
+	a.keys[keyID] = hmacKey{secret: secret, principal: principal}
+	return nil
+}
+
+// Authenticate implements Authenticator
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	keyID := r.Header.Get(HeaderKeyID)
+	if keyID == "" {
+		return nil, ErrNoCredentials
+	}
+
+	key, ok := a.keys[keyID]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, ErrInvalidCredentials
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(HeaderSignature))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(nil, r.Body, a.maxBodySize)
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := sign(key.secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return key.principal, nil
+}
+
+// SignRequest signs a request for the HMAC authenticator. The signature covers
+// the method, the request URI, the timestamp and the body.
+func SignRequest(r *http.Request, keyID string, secret []byte) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	r.Header.Set(HeaderKeyID, keyID)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(HeaderSignature, hex.EncodeToString(sign(secret, r.Method, r.URL.RequestURI(), timestamp, body)))
+
+	return nil
+}
+
+// sign computes the signature of a request
+func sign(secret []byte, method, uri string, timestamp int64, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + strconv.FormatInt(timestamp, 10) + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return mac.Sum(nil)
+}
+
+// readBody reads the body of the request and restores it for the next readers
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
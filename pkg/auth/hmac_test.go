@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"to":"a@b.c"}`)
+
+	// The canonical string is the method, the request URI, the timestamp and the hex SHA-256 of the body
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("POST\n/tasks/mail?delay=5\n1700000000\n" + hex.EncodeToString(bodyHash[:])))
+
+	if got := sign(secret, "POST", "/tasks/mail?delay=5", 1700000000, body); !hmac.Equal(got, mac.Sum(nil)) {
+		t.Errorf("sign = %x, want %x", got, mac.Sum(nil))
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	a := NewHMACAuthenticator(time.Minute, WithMaxBodySize(64))
+	if err := a.Add("billing", secret, "dispatch:tag:billing"); err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(method, target, body string) *http.Request {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if err := SignRequest(r, "billing", secret); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		wantErr error
+	}{
+		{
+			name:    "signed",
+			request: func() *http.Request { return signed("POST", "/tasks/mail", `{"to":"a@b.c"}`) },
+		},
+		{
+			name: "no credentials",
+			request: func() *http.Request {
+				return httptest.NewRequest("POST", "/tasks/mail", nil)
+			},
+			wantErr: ErrNoCredentials,
+		},
+		{
+			name: "unknown key",
+			request: func() *http.Request {
+				r := signed("POST", "/tasks/mail", `{}`)
+				r.Header.Set(HeaderKeyID, "other")
+				return r
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name: "tampered body",
+			request: func() *http.Request {
+				r := signed("POST", "/tasks/mail", `{"to":"a@b.c"}`)
+				r.Body = io.NopCloser(strings.NewReader(`{"to":"x@y.z"}`))
+				return r
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name: "tampered query",
+			request: func() *http.Request {
+				r := signed("POST", "/tasks/mail?delay=5", `{}`)
+				r.URL.RawQuery = "delay=500"
+				return r
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name: "expired timestamp",
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/tasks/mail", strings.NewReader(`{}`))
+				timestamp := time.Now().Add(-time.Hour).Unix()
+				r.Header.Set(HeaderKeyID, "billing")
+				r.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+				r.Header.Set(HeaderSignature, hex.EncodeToString(sign(secret, "POST", "/tasks/mail", timestamp, []byte(`{}`))))
+				return r
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name: "body too large",
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/tasks/mail", strings.NewReader(strings.Repeat("x", 65)))
+				r.Header.Set(HeaderKeyID, "billing")
+				r.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+				r.Header.Set(HeaderSignature, "00")
+				return r
+			},
+			wantErr: &http.MaxBytesError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.request()
+			principal, err := a.Authenticate(r)
+
+			var tooLarge *http.MaxBytesError
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("err = %v", err)
+			case errors.As(tt.wantErr, &tooLarge):
+				if !errors.As(err, &tooLarge) {
+					t.Fatalf("err = %v, want a MaxBytesError", err)
+				}
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if principal.Subject != "billing" {
+				t.Errorf("principal = %+v", principal)
+			}
+			// The body is restored for the handlers
+			if body, _ := io.ReadAll(r.Body); string(body) != `{"to":"a@b.c"}` {
+				t.Errorf("body = %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// encodeSegment encodes a JWT segment
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signES signs a token with an ECDSA key, hashing with hash
+func signES(t *testing.T, key *ecdsa.PrivateKey, hash crypto.Hash, header, claims map[string]any) string {
+	t.Helper()
+	input := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+
+	h := hash.New()
+	h.Write([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// signHS signs a token with a symmetric key
+func signHS(t *testing.T, secret []byte, header, claims map[string]any) string {
+	t.Helper()
+	input := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ecJWK returns the JWK of an ECDSA public key
+func ecJWK(kid, crv string, key *ecdsa.PublicKey) map[string]any {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	coord := func(v *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(v.FillBytes(make([]byte, size)))
+	}
+	return map[string]any{"kty": "EC", "kid": kid, "crv": crv, "x": coord(key.X), "y": coord(key.Y)}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	jwks, _ := json.Marshal(map[string]any{"keys": []any{
+		ecJWK("p256", "P-256", &p256.PublicKey),
+		ecJWK("p384", "P-384", &p384.PublicKey),
+		map[string]any{"kty": "oct", "kid": "hs", "k": base64.RawURLEncoding.EncodeToString(secret)},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewJWTAuthenticator(path, WithIssuer("issuer"), WithAudience("zsched"), WithLeeway(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "iss": "issuer", "aud": []string{"zsched"}, "exp": now + 60, "scope": "openid read dispatch:task:mail"}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "ES256", token: signES(t, p256, crypto.SHA256, map[string]any{"alg": "ES256", "kid": "p256"}, claims(nil))},
+		{name: "ES384", token: signES(t, p384, crypto.SHA384, map[string]any{"alg": "ES384", "kid": "p384"}, claims(nil))},
+		{name: "HS256", token: signHS(t, secret, map[string]any{"alg": "HS256", "kid": "hs"}, claims(nil))},
+		{name: "ES256 on a P-384 key", token: signES(t, p384, crypto.SHA256, map[string]any{"alg": "ES256", "kid": "p384"}, claims(nil)), wantErr: true},
+		{name: "ES384 on a P-256 key", token: signES(t, p256, crypto.SHA384, map[string]any{"alg": "ES384", "kid": "p256"}, claims(nil)), wantErr: true},
+		{name: "HS256 on an EC key", token: signHS(t, secret, map[string]any{"alg": "HS256", "kid": "p256"}, claims(nil)), wantErr: true},
+		{name: "none", token: encodeSegment(t, map[string]any{"alg": "none", "kid": "hs"}) + "." + encodeSegment(t, claims(nil)) + ".", wantErr: true},
+		{name: "unknown kid", token: signHS(t, secret, map[string]any{"alg": "HS256", "kid": "other"}, claims(nil)), wantErr: true},
+		{name: "missing exp", token: signHS(t, secret, map[string]any{"alg": "HS256", "kid": "hs"}, claims(map[string]any{"exp": nil})), wantErr: true},
+		{name: "expired", token: signHS(t, secret, map[string]any{"alg": "HS256", "kid": "hs"}, claims(map[string]any{"exp": now - 60})), wantErr: true},
+		{name: "not valid yet", token: signHS(t, secret, map[string]any{"alg": "HS256", "kid": "hs"}, claims(map[string]any{"nbf": now + 60})), wantErr: true},
+		{name: "wrong issuer", token: signHS(t, secret, map[string]any{"alg": "HS256", "kid": "hs"}, claims(map[string]any{"iss": "other"})), wantErr: true},
+		{name: "wrong audience", token: signHS(t, secret, map[string]any{"alg": "HS256", "kid": "hs"}, claims(map[string]any{"aud": "other"})), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/tasks", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			principal, err := a.Authenticate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if principal.Subject != "alice" || len(principal.Grants) != 2 {
+				t.Errorf("principal = %+v", principal)
+			}
+		})
+	}
+}
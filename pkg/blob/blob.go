@@ -0,0 +1,24 @@
+package blob
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a blob does not exist, or has been cleaned up
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps the large parameters offloaded from the messages
+type Store interface {
+	// Put stores a blob under a key, replacing any existing one
+	Put(key string, data []byte) error
+
+	// Get returns the blob of a key
+	Get(key string) ([]byte, error)
+
+	// Delete deletes the blob of a key, deleting a missing blob is not an error
+	Delete(key string) error
+
+	// DeleteBefore deletes the blobs stored before the given time and returns their number
+	DeleteBefore(before time.Time) (int, error)
+}
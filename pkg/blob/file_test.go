@@ -0,0 +1,96 @@
+package blob
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		delete bool
+		err    error
+	}{
+		{name: "stored", key: "blob"},
+		{name: "deleted", key: "blob", delete: true, err: ErrNotFound},
+		{name: "missing", key: "other", err: ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewFileStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put("blob", []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+			if tt.delete {
+				if err := s.Delete(tt.key); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := s.Get(tt.key)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && string(data) != "data" {
+				t.Errorf("data = %q", data)
+			}
+		})
+	}
+}
+
+func TestFileStoreDelete(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting twice, or a blob never stored, is not an error
+	for _, key := range []string{"blob", "blob", "missing"} {
+		if err := s.Delete(key); err != nil {
+			t.Errorf("delete %s: %v", key, err)
+		}
+	}
+	if err := s.Delete("../escape"); err == nil {
+		t.Error("invalid key deleted")
+	}
+}
+
+func TestFileStoreDeleteBefore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"old", "new"} {
+		if err := s.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.DeleteBefore(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted = %d, want 1", deleted)
+	}
+	if _, err := s.Get("old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("old blob: err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get("new"); err != nil {
+		t.Errorf("new blob: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package blob
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+// StorageStore stores the blobs in the blobs table of the storage
+type StorageStore struct {
+	storage storage.Storage
+}
+
+// NewStorageStore creates a storage store and its table
+func NewStorageStore(s storage.Storage) (*StorageStore, error) {
+	_, err := s.Exec(`
+		CREATE TABLE IF NOT EXISTS blobs (
+			key VARCHAR(255) PRIMARY KEY,
+			data BYTEA,
+			created_at TIMESTAMPTZ
+		)
+	`)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to create blobs table"), err)
+	}
+
+	return &StorageStore{storage: s}, nil
+}
+
+func (s *StorageStore) Put(key string, data []byte) error {
+	_, err := s.storage.Exec(
+		`
+		INSERT INTO blobs (key, data, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key)
+		DO UPDATE SET data = $2, created_at = $3
+		`,
+		key,
+		data,
+		time.Now(),
+	)
+	return err
+}
+
+func (s *StorageStore) Get(key string) ([]byte, error) {
+	var data []byte
+	err := s.storage.QueryRow(`SELECT data FROM blobs WHERE key = $1`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+func (s *StorageStore) Delete(key string) error {
+	_, err := s.storage.Exec(`DELETE FROM blobs WHERE key = $1`, key)
+	return err
+}
+
+func (s *StorageStore) DeleteBefore(before time.Time) (int, error) {
+	res, err := s.storage.Exec(`DELETE FROM blobs WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
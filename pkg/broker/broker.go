@@ -1,5 +1,14 @@
 package broker
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrRequeue is returned by the handlers of Consume and ConsumeMessages to return
+// the message to its queue instead of discarding it
+var ErrRequeue = errors.New("message requeued")
+
 type Broker interface {
 	// Publish publishes a message to the message broker
 	Publish(body []byte, routingKey ...string) error
@@ -10,3 +19,61 @@ type Broker interface {
 	// Close closes the connection to the message broker
 	Close() error
 }
+
+// Message is a message published with its properties
+type Message struct {
+	// Queue is the destination queue
+	Queue string
+
+	// Body is the payload of the message
+	Body []byte
+
+	// Priority is the priority of the message, higher priorities are consumed first
+	Priority uint8
+
+	// MessageID identifies the message
+	MessageID string
+
+	// CorrelationID correlates the message with the request that caused it
+	CorrelationID string
+
+	// Headers is the custom headers of the message
+	Headers map[string]string
+
+	// ContentType is the MIME type of the body, empty is JSON
+	ContentType string
+}
+
+// MessagePublisher is implemented by brokers supporting message properties,
+// brokers without it receive the body through Publish and ignore the properties
+type MessagePublisher interface {
+	// PublishMessage publishes a message to its queue
+	PublishMessage(msg Message) error
+}
+
+// MessageConsumer is implemented by brokers delivering the properties of the messages,
+// brokers without it are consumed through Consume with the body only
+type MessageConsumer interface {
+	// ConsumeMessages consumes messages with their properties from the message broker
+	ConsumeMessages(queue string, autoAck bool, concurrency int, handler func(msg Message) error) error
+}
+
+// BatchPublisher is implemented by brokers able to publish messages in batches,
+// brokers without it publish the messages of a batch one by one
+type BatchPublisher interface {
+	// PublishBatch publishes the messages and returns once the broker confirmed every one of them
+	PublishBatch(ctx context.Context, msgs []Message) error
+}
+
+// Declarer is implemented by brokers able to create queues without consuming them,
+// so messages published before any consumer started are kept
+type Declarer interface {
+	// Declare creates the queues if they do not exist
+	Declare(queues ...string) error
+}
+
+// Pinger is implemented by brokers able to check their connectivity
+type Pinger interface {
+	// Ping returns an error if the message broker is not reachable
+	Ping() error
+}
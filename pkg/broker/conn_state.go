@@ -0,0 +1,68 @@
+package broker
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// errNotConnected is the state of a connection that has not been dialed yet
+var errNotConnected = errors.New("not connected to the broker")
+
+// connState tracks the state of the managed connection from its socket, as
+// go-rabbitmq reconnects transparently without exposing it
+type connState struct {
+	mu  sync.Mutex
+	err error
+}
+
+func newConnState() *connState {
+	return &connState{err: errNotConnected}
+}
+
+// Err returns nil while the connection is up, else the error that closed it
+func (s *connState) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *connState) set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// dial dials the sockets of the managed connection and tracks them
+func (s *connState) dial(network, addr string) (net.Conn, error) {
+	conn, err := amqp.DefaultDial(30*time.Second)(network, addr)
+	if err != nil {
+		s.set(err)
+		return nil, err
+	}
+
+	s.set(nil)
+	return &trackedConn{Conn: conn, state: s}, nil
+}
+
+// trackedConn reports the failures of a socket to its connState
+type trackedConn struct {
+	net.Conn
+	state *connState
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.state.set(errors.Join(errors.New("lost connection to the broker"), err))
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.state.set(errors.New("connection to the broker is closed"))
+	return c.Conn.Close()
+}
@@ -0,0 +1,44 @@
+package broker
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnState(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	s := newConnState()
+	if s.Err() == nil {
+		t.Fatal("state is up before dialing")
+	}
+
+	conn, err := s.dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("state after dial = %v", err)
+	}
+
+	// The server closing the socket is seen by the next read
+	(<-accepted).Close()
+	conn.Read(make([]byte, 1))
+	if s.Err() == nil {
+		t.Error("state is up after the server closed the connection")
+	}
+
+	if _, err := s.dial("tcp", "127.0.0.1:1"); err == nil || s.Err() == nil {
+		t.Errorf("state after failed dial = %v", s.Err())
+	}
+}
@@ -1,17 +1,48 @@
 package broker
 
 import (
+	"context"
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/wagslane/go-rabbitmq"
 )
 
 type RabbitMQBroker struct {
-	connection *rabbitmq.Conn
-	publisher  *rabbitmq.Publisher
-	consumers  []*rabbitmq.Consumer
+	url         string
+	connection  *rabbitmq.Conn
+	state       *connState
+	publisher   *rabbitmq.Publisher
+	consumers   []*rabbitmq.Consumer
+	maxPriority uint8
+
+	// confirmPublisher publishes the batches, in confirm mode
+	confirmPublisher *rabbitmq.Publisher
+
+	// confirmTimeout is the maximum time a publish waits for its confirmation, zero does not wait
+	confirmTimeout time.Duration
+}
+
+// WithPublisherConfirms makes Publish and PublishMessage wait until the broker confirmed the
+// message, they return an error if it is rejected or not confirmed within the timeout
+func WithPublisherConfirms(timeout time.Duration) func(*RabbitMQBroker) {
+	return func(b *RabbitMQBroker) {
+		b.confirmTimeout = timeout
+	}
 }
 
-func NewRabbitMQBroker(url string) (*RabbitMQBroker, error) {
-	conn, err := rabbitmq.NewConn(url)
+// WithMaxPriority sets the x-max-priority argument of the declared queues, default is zero
+// which declares queues without priorities. Existing queues must be deleted to change it.
+func WithMaxPriority(maxPriority uint8) func(*RabbitMQBroker) {
+	return func(b *RabbitMQBroker) {
+		b.maxPriority = maxPriority
+	}
+}
+
+func NewRabbitMQBroker(url string, opts ...func(*RabbitMQBroker)) (*RabbitMQBroker, error) {
+	state := newConnState()
+	conn, err := rabbitmq.NewConn(url, rabbitmq.WithConnectionOptionsConfig(rabbitmq.Config{Dial: state.dial}))
 	if err != nil {
 		return nil, err
 	}
@@ -21,27 +52,127 @@ func NewRabbitMQBroker(url string) (*RabbitMQBroker, error) {
 		return nil, err
 	}
 
-	return &RabbitMQBroker{
-		connection: conn,
-		publisher:  publisher,
-	}, nil
+	confirmPublisher, err := rabbitmq.NewPublisher(conn, rabbitmq.WithPublisherOptionsConfirm)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &RabbitMQBroker{
+		url:              url,
+		connection:       conn,
+		state:            state,
+		publisher:        publisher,
+		confirmPublisher: confirmPublisher,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b, nil
 }
 
 func (b *RabbitMQBroker) Publish(body []byte, routingKey ...string) error {
-	return b.publisher.Publish(
-		body,
-		routingKey,
-		rabbitmq.WithPublishOptionsContentType("application/json"),
-	)
+	return b.publish(body, routingKey, rabbitmq.WithPublishOptionsContentType("application/json"))
+}
+
+// PublishMessage implements MessagePublisher
+func (b *RabbitMQBroker) PublishMessage(msg Message) error {
+	return b.publish(msg.Body, []string{msg.Queue}, publishOptions(msg)...)
+}
+
+// publish publishes a message, and waits for its confirmation if publisher confirms are enabled
+func (b *RabbitMQBroker) publish(body []byte, routingKeys []string, opts ...func(*rabbitmq.PublishOptions)) error {
+	if b.confirmTimeout == 0 {
+		return b.publisher.Publish(body, routingKeys, opts...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.confirmTimeout)
+	defer cancel()
+
+	confirmations, err := b.confirmPublisher.PublishWithDeferredConfirmWithContext(ctx, body, routingKeys, opts...)
+	if err != nil {
+		return err
+	}
+
+	return waitConfirmations(ctx, confirmations)
+}
+
+// PublishBatch implements BatchPublisher, the messages are pipelined then their confirmations awaited
+func (b *RabbitMQBroker) PublishBatch(ctx context.Context, msgs []Message) error {
+	confirmations := make([]*amqp.DeferredConfirmation, 0, len(msgs))
+	for _, msg := range msgs {
+		confirmation, err := b.confirmPublisher.PublishWithDeferredConfirmWithContext(ctx, msg.Body, []string{msg.Queue}, publishOptions(msg)...)
+		if err != nil {
+			return err
+		}
+		confirmations = append(confirmations, confirmation...)
+	}
+
+	return waitConfirmations(ctx, confirmations)
+}
+
+// waitConfirmations waits until the broker confirmed every message
+func waitConfirmations(ctx context.Context, confirmations []*amqp.DeferredConfirmation) error {
+	for _, confirmation := range confirmations {
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return errors.Join(errors.New("message not confirmed by the broker"), err)
+		}
+		if !acked {
+			return errors.New("message rejected by the broker")
+		}
+	}
+
+	return nil
+}
+
+// publishOptions returns the publishing options of the properties of a message
+func publishOptions(msg Message) []func(*rabbitmq.PublishOptions) {
+	contentType := msg.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	opts := []func(*rabbitmq.PublishOptions){
+		rabbitmq.WithPublishOptionsContentType(contentType),
+		rabbitmq.WithPublishOptionsPriority(msg.Priority),
+	}
+
+	if msg.MessageID != "" {
+		opts = append(opts, rabbitmq.WithPublishOptionsMessageID(msg.MessageID))
+	}
+
+	if msg.CorrelationID != "" {
+		opts = append(opts, rabbitmq.WithPublishOptionsCorrelationID(msg.CorrelationID))
+	}
+
+	if len(msg.Headers) > 0 {
+		headers := rabbitmq.Table{}
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		opts = append(opts, rabbitmq.WithPublishOptionsHeaders(headers))
+	}
+
+	return opts
 }
 
 func (b *RabbitMQBroker) Consume(queue string, autoAck bool, concurrency int, handler func(body []byte) error) error {
+	return b.ConsumeMessages(queue, autoAck, concurrency, func(msg Message) error {
+		return handler(msg.Body)
+	})
+}
+
+// ConsumeMessages implements MessageConsumer, only the string headers are delivered
+func (b *RabbitMQBroker) ConsumeMessages(queue string, autoAck bool, concurrency int, handler func(msg Message) error) error {
 	consumer, err := rabbitmq.NewConsumer(
 		b.connection,
 		queue,
 		rabbitmq.WithConsumerOptionsConcurrency(concurrency),
 		rabbitmq.WithConsumerOptionsQOSPrefetch(concurrency),
 		rabbitmq.WithConsumerOptionsConsumerAutoAck(autoAck),
+		rabbitmq.WithConsumerOptionsQueueArgs(b.queueArgs()),
 	)
 	if err != nil {
 		return err
@@ -50,7 +181,25 @@ func (b *RabbitMQBroker) Consume(queue string, autoAck bool, concurrency int, ha
 	b.consumers = append(b.consumers, consumer)
 
 	return consumer.Run(func(d rabbitmq.Delivery) (action rabbitmq.Action) {
-		if err := handler(d.Body); err != nil {
+		msg := Message{
+			Queue:         queue,
+			Body:          d.Body,
+			Priority:      d.Priority,
+			MessageID:     d.MessageId,
+			CorrelationID: d.CorrelationId,
+			ContentType:   d.ContentType,
+			Headers:       make(map[string]string, len(d.Headers)),
+		}
+		for k, v := range d.Headers {
+			if s, ok := v.(string); ok {
+				msg.Headers[k] = s
+			}
+		}
+
+		switch err := handler(msg); {
+		case errors.Is(err, ErrRequeue):
+			return rabbitmq.NackRequeue
+		case err != nil:
 			return rabbitmq.NackDiscard
 		}
 
@@ -58,8 +207,48 @@ func (b *RabbitMQBroker) Consume(queue string, autoAck bool, concurrency int, ha
 	})
 }
 
+// Ping returns the state of the managed connection, an error while it is down or reconnecting
+func (b *RabbitMQBroker) Ping() error {
+	return b.state.Err()
+}
+
+// Declare declares the queues with the options of the consumers, on a short-lived connection
+func (b *RabbitMQBroker) Declare(queues ...string) error {
+	conn, err := amqp.DialConfig(b.url, amqp.Config{
+		Dial: amqp.DefaultDial(5 * time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for _, queue := range queues {
+		if _, err := ch.QueueDeclare(queue, false, false, false, false, amqp.Table(b.queueArgs())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queueArgs returns the arguments of the declared queues
+func (b *RabbitMQBroker) queueArgs() rabbitmq.Table {
+	args := rabbitmq.Table{}
+	if b.maxPriority > 0 {
+		args["x-max-priority"] = int32(b.maxPriority)
+	}
+	return args
+}
+
 func (b *RabbitMQBroker) Close() error {
 	b.publisher.Close()
+	b.confirmPublisher.Close()
 	for _, consumer := range b.consumers {
 		consumer.Close()
 	}
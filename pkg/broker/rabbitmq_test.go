@@ -0,0 +1,27 @@
+package broker
+
+import "testing"
+
+func TestQueueArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []func(*RabbitMQBroker)
+		maxPriority any
+	}{
+		{name: "priorities are opt-in"},
+		{name: "priorities enabled", opts: []func(*RabbitMQBroker){WithMaxPriority(10)}, maxPriority: int32(10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &RabbitMQBroker{}
+			for _, opt := range tt.opts {
+				opt(b)
+			}
+
+			if got := b.queueArgs()["x-max-priority"]; got != tt.maxPriority {
+				t.Errorf("x-max-priority = %v, want %v", got, tt.maxPriority)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec encodes the executions published to the broker
+type Codec interface {
+	// Name identifies the codec in the headers of the messages
+	Name() string
+
+	// ContentType is the MIME type of the encoded values
+	ContentType() string
+
+	// Marshal encodes a value
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes data into a value
+	Unmarshal(data []byte, v any) error
+}
+
+// Header names tagging the encoding of a message
+const (
+	HeaderCodec       = "x-zsched-codec"
+	HeaderCompression = "x-zsched-compression"
+)
+
+// codecs is the codecs every node is able to decode, whatever the codec it publishes with,
+// read by every consumer while Register may add to it
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	for _, c := range []Codec{JSON(), MessagePack(), CBOR()} {
+		codecs[c.Name()] = c
+	}
+}
+
+// Register makes a custom codec decodable by the node, it is safe to call once the
+// engine started, messages received before are rejected as of an unknown codec
+func Register(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// Lookup returns the codec with the given name
+func Lookup(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// jsonCodec encodes with encoding/json, numbers are decoded as float64
+type jsonCodec struct{}
+
+// JSON returns the JSON codec, the default one
+func JSON() Codec {
+	return jsonCodec{}
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// handleCodec encodes with a binary format of ugorji/go, integers are decoded as int64
+type handleCodec struct {
+	name        string
+	contentType string
+	handle      codec.Handle
+}
+
+func (c handleCodec) Name() string {
+	return c.name
+}
+
+func (c handleCodec) ContentType() string {
+	return c.contentType
+}
+
+func (c handleCodec) Marshal(v any) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, c.handle).Encode(v)
+	return data, err
+}
+
+func (c handleCodec) Unmarshal(data []byte, v any) error {
+	return codec.NewDecoderBytes(data, c.handle).Decode(v)
+}
+
+// mapType decodes nested objects as map[string]any, like encoding/json
+var mapType = reflect.TypeOf(map[string]any(nil))
+
+// MessagePack returns the MessagePack codec
+func MessagePack() Codec {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	h.SignedInteger = true
+	h.MapType = mapType
+
+	return handleCodec{name: "msgpack", contentType: "application/msgpack", handle: h}
+}
+
+// CBOR returns the CBOR codec
+func CBOR() Codec {
+	h := &codec.CborHandle{}
+	h.SignedInteger = true
+	h.MapType = mapType
+
+	return handleCodec{name: "cbor", contentType: "application/cbor", handle: h}
+}
@@ -0,0 +1,97 @@
+package codec
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// payload mimics an execution, with nested objects and numbers
+type payload struct {
+	ID         string         `json:"id" codec:"id"`
+	Iterations int            `json:"iterations" codec:"iterations"`
+	Parameters map[string]any `json:"parameters" codec:"parameters"`
+}
+
+func TestCodecs(t *testing.T) {
+	tests := []struct {
+		codec       Codec
+		name        string
+		contentType string
+		number      any
+	}{
+		{codec: JSON(), name: "json", contentType: "application/json", number: float64(42)},
+		{codec: MessagePack(), name: "msgpack", contentType: "application/msgpack", number: int64(42)},
+		{codec: CBOR(), name: "cbor", contentType: "application/cbor", number: int64(42)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.codec.Name() != tt.name || tt.codec.ContentType() != tt.contentType {
+				t.Errorf("name = %s, content type = %s", tt.codec.Name(), tt.codec.ContentType())
+			}
+			if c, ok := Lookup(tt.name); !ok || c.Name() != tt.name {
+				t.Errorf("codec %s is not registered", tt.name)
+			}
+
+			in := payload{
+				ID:         "id",
+				Iterations: 3,
+				Parameters: map[string]any{"n": 42, "to": "a@b.c", "nested": map[string]any{"ok": true}},
+			}
+			data, err := tt.codec.Marshal(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out payload
+			if err := tt.codec.Unmarshal(data, &out); err != nil {
+				t.Fatal(err)
+			}
+
+			// Nested objects are decoded as maps by every codec, numbers depend on the codec
+			want := payload{
+				ID:         "id",
+				Iterations: 3,
+				Parameters: map[string]any{"n": tt.number, "to": "a@b.c", "nested": map[string]any{"ok": true}},
+			}
+			if !reflect.DeepEqual(out, want) {
+				t.Errorf("decoded = %#v, want %#v", out, want)
+			}
+		})
+	}
+}
+
+// customCodec is a codec registered by the application
+type customCodec struct {
+	Codec
+}
+
+func (customCodec) Name() string { return "custom" }
+
+func TestRegister(t *testing.T) {
+	if _, ok := Lookup("custom"); ok {
+		t.Fatal("custom codec registered before Register")
+	}
+
+	// Consumers look codecs up while the application registers its own
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 1000 {
+				Lookup("custom")
+			}
+		})
+	}
+	Register(customCodec{JSON()})
+	wg.Wait()
+	t.Cleanup(func() {
+		codecsMu.Lock()
+		defer codecsMu.Unlock()
+		delete(codecs, "custom")
+	})
+
+	if c, ok := Lookup("custom"); !ok || c.Name() != "custom" {
+		t.Error("custom codec not found after Register")
+	}
+}
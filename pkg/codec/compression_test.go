@@ -0,0 +1,85 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"to": "a@b.c"}`), 100)
+
+	tests := []struct {
+		compression Compression
+		detected    Compression
+		smaller     bool
+	}{
+		{compression: None, detected: None},
+		{compression: Gzip, detected: Gzip, smaller: true},
+		{compression: Zstd, detected: Zstd, smaller: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.compression), func(t *testing.T) {
+			compressed, err := Compress(tt.compression, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.smaller && len(compressed) >= len(data) {
+				t.Errorf("compressed to %d bytes, from %d", len(compressed), len(data))
+			}
+			if got := Detect(compressed); got != tt.detected {
+				t.Errorf("detected %q, want %q", got, tt.detected)
+			}
+
+			decompressed, err := Decompress(tt.compression, compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Error("decompressed data differs")
+			}
+		})
+	}
+}
+
+func TestCompressionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{name: "compress unknown", fn: func() error { _, err := Compress("lz4", nil); return err }},
+		{name: "decompress unknown", fn: func() error { _, err := Decompress("lz4", nil); return err }},
+		{name: "decompress invalid gzip", fn: func() error { _, err := Decompress(Gzip, []byte("not gzip")); return err }},
+		{name: "decompress invalid zstd", fn: func() error { _, err := Decompress(Zstd, []byte("not zstd")); return err }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); err == nil {
+				t.Error("err = nil")
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Compression
+	}{
+		{name: "json", data: []byte(`{"id": 1}`), want: None},
+		{name: "empty", want: None},
+		{name: "gzip magic", data: []byte{0x1f, 0x8b, 0x08}, want: Gzip},
+		{name: "zstd magic", data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, want: Zstd},
+		{name: "truncated zstd magic", data: []byte{0x28, 0xb5}, want: None},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.data); got != tt.want {
+				t.Errorf("Detect = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
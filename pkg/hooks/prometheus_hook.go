@@ -1,46 +1,198 @@
 package hooks
 
 import (
+	"errors"
+	"log"
 	"net/http"
-	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/vlourme/zsched"
 	"github.com/vlourme/zsched/pkg/storage"
 )
 
+// defaultMetricsAddress is the address of the metrics server when not mounted on the API
+const defaultMetricsAddress = ":2112"
+
+// PrometheusHook exposes Prometheus metrics of the executions, the task logger and the schedules.
+// The zero value registers on the default registry and serves the metrics on ":2112", as before.
 type PrometheusHook struct {
+	// Registerer is the registry of the metrics, default is prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
+
+	// Gatherer is the source of the served metrics, default is the Registerer
+	// when it is also a Gatherer, prometheus.DefaultGatherer otherwise
+	Gatherer prometheus.Gatherer
+
+	// Address is the address of the dedicated metrics server, default is ":2112" unless
+	// MountOnAPI is set, "-" disables the server
+	Address string
+
+	// MountOnAPI serves the metrics on /metrics of the zsched API
+	MountOnAPI bool
+
 	taskCounter       *prometheus.CounterVec
 	durationHistogram *prometheus.HistogramVec
+	inFlight          *prometheus.GaugeVec
+	retryCounter      *prometheus.CounterVec
+	queueWait         *prometheus.HistogramVec
+	backlog           prometheus.Gauge
+	flushDuration     prometheus.Histogram
+	cronCounter       *prometheus.CounterVec
 }
 
 func (h *PrometheusHook) Initialize(storage storage.Storage) error {
-	h.taskCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	if h.Registerer == nil {
+		h.Registerer = prometheus.DefaultRegisterer
+	}
+
+	if h.Gatherer == nil {
+		if g, ok := h.Registerer.(prometheus.Gatherer); ok {
+			h.Gatherer = g
+		} else {
+			h.Gatherer = prometheus.DefaultGatherer
+		}
+	}
+
+	h.taskCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "scheduler_tasks_total",
 		Help: "Total number of tasks executed",
 	}, []string{"task_name", "status"})
 
-	h.durationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	h.durationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "scheduler_task_duration_seconds",
 		Help: "Duration of tasks in seconds",
 	}, []string{"task_name", "status"})
 
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		http.ListenAndServe(":2112", nil)
-	}()
+	h.inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scheduler_tasks_in_flight",
+		Help: "Number of tasks currently executing",
+	}, []string{"task_name"})
+
+	h.retryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_task_retries_total",
+		Help: "Total number of retried attempts",
+	}, []string{"task_name"})
+
+	h.queueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "scheduler_task_queue_wait_seconds",
+		Help:    "Time between the dispatch of a task and the start of an attempt",
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"task_name"})
+
+	h.backlog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "scheduler_task_logger_backlog",
+		Help: "Number of executions waiting to be written by the task logger",
+	})
+
+	h.flushDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "scheduler_task_logger_flush_duration_seconds",
+		Help: "Duration of the task logger flushes in seconds",
+	})
+
+	h.cronCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_cron_dispatches_total",
+		Help: "Total number of tasks dispatched by cron schedules",
+	}, []string{"task_name", "status"})
+
+	collectors := []prometheus.Collector{
+		h.taskCounter,
+		h.durationHistogram,
+		h.inFlight,
+		h.retryCounter,
+		h.queueWait,
+		h.backlog,
+		h.flushDuration,
+		h.cronCounter,
+	}
+	for _, c := range collectors {
+		if err := h.Registerer.Register(c); err != nil {
+			return errors.Join(errors.New("failed to register prometheus metrics"), err)
+		}
+	}
+
+	if address := h.serverAddress(); address != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", h.handler())
+		go func() {
+			if err := http.ListenAndServe(address, mux); err != nil {
+				log.Printf("failed to serve prometheus metrics on %s: %v", address, err)
+			}
+		}()
+	}
 
 	return nil
 }
 
-func (h *PrometheusHook) BeforeExecute(task zsched.AnyTask, s *zsched.State) error {
-	return nil
+// serverAddress returns the address of the dedicated metrics server, empty if disabled
+func (h *PrometheusHook) serverAddress() string {
+	switch {
+	case h.Address == "-":
+		return ""
+	case h.Address == "" && !h.MountOnAPI:
+		return defaultMetricsAddress
+	default:
+		return h.Address
+	}
 }
 
-func (h *PrometheusHook) AfterExecute(task zsched.AnyTask, s *zsched.State) error {
-	h.taskCounter.WithLabelValues(task.Name(), string(s.Status)).Inc()
-	h.durationHistogram.WithLabelValues(task.Name(), string(s.Status)).Observe(time.Since(s.StartedAt).Seconds())
-	return nil
+// MetricsHandler implements zsched.MetricsHook
+func (h *PrometheusHook) MetricsHandler() http.Handler {
+	if !h.MountOnAPI {
+		return nil
+	}
+	return h.handler()
+}
+
+// handler serves the metrics of the gatherer
+func (h *PrometheusHook) handler() http.Handler {
+	return promhttp.HandlerFor(h.Gatherer, promhttp.HandlerOpts{})
+}
+
+// OnStart implements zsched.StartHook
+func (h *PrometheusHook) OnStart(event *zsched.ExecutionEvent) {
+	h.inFlight.WithLabelValues(event.Task.Name()).Inc()
+	h.queueWait.WithLabelValues(event.Task.Name()).Observe(event.State.StartedAt.Sub(event.State.InitializedAt).Seconds())
+}
+
+// OnSuccess implements zsched.SuccessHook
+func (h *PrometheusHook) OnSuccess(event *zsched.ExecutionEvent) {
+	h.observeAttempt(event)
+}
+
+// OnRetry implements zsched.RetryHook
+func (h *PrometheusHook) OnRetry(event *zsched.ExecutionEvent) {
+	h.observeAttempt(event)
+	h.retryCounter.WithLabelValues(event.Task.Name()).Inc()
+}
+
+// OnFailure implements zsched.FailureHook
+func (h *PrometheusHook) OnFailure(event *zsched.ExecutionEvent) {
+	h.observeAttempt(event)
+}
+
+// OnFlush implements zsched.FlushHook
+func (h *PrometheusHook) OnFlush(event *zsched.FlushEvent) {
+	h.backlog.Set(float64(event.Backlog))
+	if event.Size > 0 {
+		h.flushDuration.Observe(event.Duration.Seconds())
+	}
+}
+
+// OnSchedule implements zsched.ScheduleHook
+func (h *PrometheusHook) OnSchedule(event *zsched.ScheduleEvent) {
+	status := "success"
+	if event.Error != nil {
+		status = "failed"
+	}
+	h.cronCounter.WithLabelValues(event.Task.Name(), status).Inc()
+}
+
+// observeAttempt records the end of an attempt
+func (h *PrometheusHook) observeAttempt(event *zsched.ExecutionEvent) {
+	name, status := event.Task.Name(), string(event.State.Status)
+
+	h.inFlight.WithLabelValues(name).Dec()
+	h.taskCounter.WithLabelValues(name, status).Inc()
+	h.durationHistogram.WithLabelValues(name, status).Observe(event.Duration.Seconds())
 }
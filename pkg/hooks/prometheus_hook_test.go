@@ -0,0 +1,63 @@
+package hooks
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vlourme/zsched"
+)
+
+func TestPrometheusHookServerAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    PrometheusHook
+		address string
+		mounted bool
+	}{
+		{name: "zero value", address: ":2112"},
+		{name: "mounted on the api", hook: PrometheusHook{MountOnAPI: true}, mounted: true},
+		{name: "mounted and served", hook: PrometheusHook{MountOnAPI: true, Address: ":9100"}, address: ":9100", mounted: true},
+		{name: "custom address", hook: PrometheusHook{Address: ":9100"}, address: ":9100"},
+		{name: "disabled", hook: PrometheusHook{Address: "-"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hook.serverAddress(); got != tt.address {
+				t.Errorf("address = %q, want %q", got, tt.address)
+			}
+			if mounted := tt.hook.MetricsHandler() != nil; mounted != tt.mounted {
+				t.Errorf("mounted = %v, want %v", mounted, tt.mounted)
+			}
+		})
+	}
+}
+
+func TestPrometheusHookMetrics(t *testing.T) {
+	h := &PrometheusHook{Registerer: prometheus.NewRegistry(), MountOnAPI: true}
+	if err := h.Initialize(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	task := zsched.NewTask("mail", func(*zsched.Context[any]) error { return nil })
+	state := &zsched.State{TaskID: uuid.New(), InitializedAt: time.Now(), StartedAt: time.Now()}
+	h.OnStart(&zsched.ExecutionEvent{Task: task, State: state, Attempt: 1})
+	state.Status = zsched.StatusSuccess
+	h.OnSuccess(&zsched.ExecutionEvent{Task: task, State: state, Attempt: 1, Duration: time.Second})
+
+	rec := httptest.NewRecorder()
+	h.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, metric := range []string{
+		`scheduler_tasks_total{status="success",task_name="mail"} 1`,
+		`scheduler_tasks_in_flight{task_name="mail"} 0`,
+	} {
+		if !strings.Contains(rec.Body.String(), metric) {
+			t.Errorf("metrics do not contain %s", metric)
+		}
+	}
+}
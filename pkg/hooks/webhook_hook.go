@@ -0,0 +1,451 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+// WebhookTrigger is an event of an execution notified by a webhook
+type WebhookTrigger string
+
+const (
+	// WebhookOnFailure notifies when the last attempt of an execution fails
+	WebhookOnFailure WebhookTrigger = "failure"
+
+	// WebhookOnSuccess notifies when an execution succeeds
+	WebhookOnSuccess WebhookTrigger = "success"
+
+	// WebhookOnSlow notifies when an attempt is still running after the SLA of the rule
+	WebhookOnSlow WebhookTrigger = "slow"
+)
+
+const (
+	// WebhookSignatureHeader holds the hex HMAC-SHA256 of "<timestamp>.<body>"
+	WebhookSignatureHeader = "X-Zsched-Signature"
+
+	// WebhookTimestampHeader holds the unix timestamp of the delivery
+	WebhookTimestampHeader = "X-Zsched-Timestamp"
+)
+
+// WebhookRule describes when and where a notification is sent
+type WebhookRule struct {
+	// URL is the endpoint receiving the notification
+	URL string
+
+	// Tasks restricts the rule to these task names
+	Tasks []string
+
+	// Tags restricts the rule to tasks with one of these tags,
+	// the rule matches every task when both Tasks and Tags are empty
+	Tags []string
+
+	// On is the events triggering the rule
+	On []WebhookTrigger
+
+	// SLA is the maximum duration of an attempt before WebhookOnSlow triggers
+	SLA time.Duration
+
+	// IncludeParameters sends the parameters of the execution in the payload,
+	// they are removed by default to avoid leaking them to third parties
+	IncludeParameters bool
+
+	// Template is a text/template rendering the JSON body from a WebhookPayload,
+	// the "json" function encodes a value. The payload is sent as is when empty.
+	Template string
+
+	// Secret signs the body with HMAC-SHA256 when set
+	Secret string
+
+	// Headers is added to the request, such as an authorization header
+	Headers map[string]string
+
+	template *template.Template
+}
+
+// WebhookPayload is the data of a notification
+type WebhookPayload struct {
+	Event    WebhookTrigger `json:"event"`
+	TaskName string         `json:"task_name"`
+	Tags     []string       `json:"tags"`
+	State    zsched.State   `json:"state"`
+	Attempt  int            `json:"attempt"`
+	Duration float64        `json:"duration"`
+	Error    string         `json:"error,omitempty"`
+	Time     time.Time      `json:"time"`
+}
+
+// WebhookHook notifies HTTP endpoints of executions matching its rules.
+// Deliveries are asynchronous and retried with an exponential backoff,
+// notifications are dropped when the queue is full. Pending notifications
+// are flushed when the engine stops, for at most FlushTimeout.
+type WebhookHook struct {
+	// Rules is the notification rules
+	Rules []WebhookRule
+
+	// Client is the HTTP client, default has a 10 seconds timeout
+	Client *http.Client
+
+	// MaxRetries is the maximum number of delivery retries, default is 5,
+	// a negative value disables retries
+	MaxRetries int
+
+	// Backoff is the delay before the first retry, doubled on every retry, default is 1 second
+	Backoff time.Duration
+
+	// QueueSize is the number of pending notifications, default is 100
+	QueueSize int
+
+	// Workers is the number of concurrent deliveries, default is 4
+	Workers int
+
+	// FlushTimeout is how long pending notifications are delivered
+	// once the engine stops, default is 10 seconds
+	FlushTimeout time.Duration
+
+	queue   chan webhookDelivery
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	stopped bool
+	timers  map[webhookAttempt][]*time.Timer
+}
+
+// webhookAttempt identifies a running attempt waiting for its SLA
+type webhookAttempt struct {
+	id      uuid.UUID
+	attempt int
+}
+
+// webhookDelivery is a rendered notification waiting to be sent
+type webhookDelivery struct {
+	rule *WebhookRule
+	body []byte
+}
+
+func (h *WebhookHook) Initialize(storage storage.Storage) error {
+	if h.Client == nil {
+		h.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if h.MaxRetries == 0 {
+		h.MaxRetries = 5
+	}
+	if h.Backoff == 0 {
+		h.Backoff = time.Second
+	}
+	if h.QueueSize == 0 {
+		h.QueueSize = 100
+	}
+	if h.Workers == 0 {
+		h.Workers = 4
+	}
+	if h.FlushTimeout == 0 {
+		h.FlushTimeout = 10 * time.Second
+	}
+
+	for i := range h.Rules {
+		rule := &h.Rules[i]
+		if rule.URL == "" {
+			return fmt.Errorf("webhook rule %d has no url", i)
+		}
+		if slices.Contains(rule.On, WebhookOnSlow) && rule.SLA <= 0 {
+			return fmt.Errorf("webhook rule %d triggers on slow attempts without sla", i)
+		}
+		if rule.Template == "" {
+			continue
+		}
+
+		tmpl, err := template.New(rule.URL).Funcs(template.FuncMap{"json": toJSON}).Parse(rule.Template)
+		if err != nil {
+			return errors.Join(fmt.Errorf("failed to parse template of webhook rule %d", i), err)
+		}
+		rule.template = tmpl
+	}
+
+	h.queue = make(chan webhookDelivery, h.QueueSize)
+	h.timers = make(map[webhookAttempt][]*time.Timer)
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+
+	for range h.Workers {
+		h.wg.Go(h.worker)
+	}
+
+	return nil
+}
+
+// OnStart implements zsched.StartHook, it arms the SLA timers of the attempt
+func (h *WebhookHook) OnStart(event *zsched.ExecutionEvent) {
+	tags := taskTags(event.Task)
+	state := *event.State
+	key := webhookAttempt{id: state.ID, attempt: event.Attempt}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stopped {
+		return
+	}
+
+	for i := range h.Rules {
+		rule := &h.Rules[i]
+		if !slices.Contains(rule.On, WebhookOnSlow) || !rule.matches(event.Task.Name(), tags) {
+			continue
+		}
+
+		slow := &zsched.ExecutionEvent{Task: event.Task, State: &state, Attempt: event.Attempt, Duration: rule.SLA}
+		h.timers[key] = append(h.timers[key], time.AfterFunc(rule.SLA, func() {
+			h.enqueue(rule, slow, tags, WebhookOnSlow)
+		}))
+	}
+}
+
+// OnSuccess implements zsched.SuccessHook
+func (h *WebhookHook) OnSuccess(event *zsched.ExecutionEvent) {
+	h.notify(event, WebhookOnSuccess)
+}
+
+// OnRetry implements zsched.RetryHook
+func (h *WebhookHook) OnRetry(event *zsched.ExecutionEvent) {
+	h.notify(event, "")
+}
+
+// OnFailure implements zsched.FailureHook
+func (h *WebhookHook) OnFailure(event *zsched.ExecutionEvent) {
+	h.notify(event, WebhookOnFailure)
+}
+
+// OnEngineStop implements zsched.EngineStopHook, pending notifications are
+// delivered until FlushTimeout and dropped after, later calls do nothing
+func (h *WebhookHook) OnEngineStop(event *zsched.EngineEvent) {
+	h.mu.Lock()
+	if h.stopped {
+		h.mu.Unlock()
+		return
+	}
+	h.stopped = true
+	for _, timers := range h.timers {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}
+	clear(h.timers)
+	close(h.queue)
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(h.FlushTimeout):
+		log.Printf("webhook flush timed out, dropping %d notifications", len(h.queue))
+	}
+
+	h.cancel()
+	<-done
+}
+
+// notify disarms the SLA timers of an attempt and queues the notifications
+// of the matching rules, trigger is the outcome of the execution or empty
+// when it is retried
+func (h *WebhookHook) notify(event *zsched.ExecutionEvent, trigger WebhookTrigger) {
+	key := webhookAttempt{id: event.State.ID, attempt: event.Attempt}
+
+	h.mu.Lock()
+	for _, timer := range h.timers[key] {
+		timer.Stop()
+	}
+	delete(h.timers, key)
+	h.mu.Unlock()
+
+	if trigger == "" {
+		return
+	}
+
+	tags := taskTags(event.Task)
+	for i := range h.Rules {
+		rule := &h.Rules[i]
+		if slices.Contains(rule.On, trigger) && rule.matches(event.Task.Name(), tags) {
+			h.enqueue(rule, event, tags, trigger)
+		}
+	}
+}
+
+// enqueue renders a notification and queues it for delivery
+func (h *WebhookHook) enqueue(rule *WebhookRule, event *zsched.ExecutionEvent, tags []string, trigger WebhookTrigger) {
+	payload := WebhookPayload{
+		Event:    trigger,
+		TaskName: event.Task.Name(),
+		Tags:     tags,
+		State:    *event.State,
+		Attempt:  event.Attempt,
+		Duration: event.Duration.Seconds(),
+		Time:     time.Now(),
+	}
+	if !rule.IncludeParameters {
+		payload.State.Parameters = nil
+	}
+	if event.Error != nil {
+		payload.Error = event.Error.Error()
+	}
+
+	body, err := rule.render(payload)
+	if err != nil {
+		log.Printf("failed to render webhook for %s: %v", rule.URL, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stopped {
+		log.Printf("engine stopped, dropping notification for %s", rule.URL)
+		return
+	}
+
+	select {
+	case h.queue <- webhookDelivery{rule: rule, body: body}:
+	default:
+		log.Printf("webhook queue is full, dropping notification for %s", rule.URL)
+	}
+}
+
+// worker delivers the queued notifications until the queue is closed
+func (h *WebhookHook) worker() {
+	for d := range h.queue {
+		if h.ctx.Err() != nil {
+			continue
+		}
+		if err := h.deliver(d); err != nil {
+			log.Printf("failed to deliver webhook to %s: %v", d.rule.URL, err)
+		}
+	}
+}
+
+// deliver sends a notification, retrying with an exponential backoff
+func (h *WebhookHook) deliver(d webhookDelivery) error {
+	backoff := h.Backoff
+
+	var err error
+	for attempt := 0; attempt <= max(h.MaxRetries, 0); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-h.ctx.Done():
+				return errors.Join(errors.New("engine stopped"), err)
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = h.send(d)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// send sends a notification once, returning whether a failure may be retried
+func (h *WebhookHook) send(d webhookDelivery) (bool, error) {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodPost, d.rule.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range d.rule.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if d.rule.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(d.rule.Secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(d.body)
+
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return false, nil
+}
+
+// taskTags returns the tags of a task, if any
+func taskTags(task zsched.AnyTask) []string {
+	if t, ok := task.(zsched.TaggedTask); ok {
+		return t.TaskTags()
+	}
+	return nil
+}
+
+// matches returns true if the rule applies to the task
+func (r *WebhookRule) matches(taskName string, tags []string) bool {
+	if len(r.Tasks) == 0 && len(r.Tags) == 0 {
+		return true
+	}
+
+	if slices.Contains(r.Tasks, taskName) {
+		return true
+	}
+
+	return slices.ContainsFunc(r.Tags, func(tag string) bool {
+		return slices.Contains(tags, tag)
+	})
+}
+
+// render renders the body of a notification
+func (r *WebhookRule) render(payload WebhookPayload) ([]byte, error) {
+	if r.template == nil {
+		return json.Marshal(payload)
+	}
+
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, payload); err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("template did not render valid json")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// toJSON encodes a value for templates
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
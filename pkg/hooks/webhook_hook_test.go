@@ -0,0 +1,218 @@
+package hooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched"
+)
+
+// webhookServer records the payloads it receives
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []WebhookPayload
+	calls    atomic.Int32
+	status   int
+}
+
+func newWebhookServer(t *testing.T, status int) *webhookServer {
+	srv := &webhookServer{status: status}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err == nil {
+			srv.mu.Lock()
+			srv.payloads = append(srv.payloads, payload)
+			srv.mu.Unlock()
+		}
+		w.WriteHeader(srv.status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (s *webhookServer) events() []WebhookTrigger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]WebhookTrigger, len(s.payloads))
+	for i, p := range s.payloads {
+		events[i] = p.Event
+	}
+	return events
+}
+
+func TestWebhookHookRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		calls      int32
+	}{
+		{name: "disabled", maxRetries: -1, calls: 1},
+		{name: "custom", maxRetries: 2, calls: 3},
+		{name: "default", maxRetries: 0, calls: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newWebhookServer(t, http.StatusServiceUnavailable)
+			h := &WebhookHook{
+				Rules:      []WebhookRule{{URL: srv.URL, On: []WebhookTrigger{WebhookOnFailure}}},
+				MaxRetries: tt.maxRetries,
+				Backoff:    time.Millisecond,
+			}
+			if err := h.Initialize(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			task := zsched.NewTask("mail", func(*zsched.Context[any]) error { return nil })
+			h.OnFailure(&zsched.ExecutionEvent{Task: task, State: &zsched.State{ID: uuid.New()}, Attempt: 1})
+			h.OnEngineStop(&zsched.EngineEvent{})
+			// Engines stopped twice do not close the queue again
+			h.OnEngineStop(&zsched.EngineEvent{})
+
+			if calls := srv.calls.Load(); calls != tt.calls {
+				t.Errorf("calls = %d, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestWebhookHookSLA(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		events   []WebhookTrigger
+	}{
+		{name: "within sla", duration: 0, events: []WebhookTrigger{WebhookOnSuccess}},
+		{name: "hung attempt", duration: 100 * time.Millisecond, events: []WebhookTrigger{WebhookOnSlow, WebhookOnSuccess}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newWebhookServer(t, http.StatusOK)
+			h := &WebhookHook{
+				Rules: []WebhookRule{{URL: srv.URL, On: []WebhookTrigger{WebhookOnSuccess, WebhookOnSlow}, SLA: 20 * time.Millisecond}},
+				// a single worker keeps the deliveries ordered
+				Workers: 1,
+			}
+			if err := h.Initialize(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			task := zsched.NewTask("mail", func(*zsched.Context[any]) error { return nil })
+			state := &zsched.State{ID: uuid.New()}
+			h.OnStart(&zsched.ExecutionEvent{Task: task, State: state, Attempt: 1})
+			time.Sleep(tt.duration)
+			h.OnSuccess(&zsched.ExecutionEvent{Task: task, State: state, Attempt: 1, Duration: tt.duration})
+
+			// a disarmed timer must not fire
+			time.Sleep(50 * time.Millisecond)
+			h.OnEngineStop(&zsched.EngineEvent{})
+
+			events := srv.events()
+			if len(events) != len(tt.events) {
+				t.Fatalf("events = %v, want %v", events, tt.events)
+			}
+			for i := range events {
+				if events[i] != tt.events[i] {
+					t.Errorf("events = %v, want %v", events, tt.events)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookHookParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		include bool
+	}{
+		{name: "removed by default"},
+		{name: "included", include: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newWebhookServer(t, http.StatusOK)
+			h := &WebhookHook{Rules: []WebhookRule{{URL: srv.URL, On: []WebhookTrigger{WebhookOnSuccess}, IncludeParameters: tt.include}}}
+			if err := h.Initialize(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			task := zsched.NewTask("mail", func(*zsched.Context[any]) error { return nil })
+			state := &zsched.State{ID: uuid.New(), Parameters: map[string]any{"token": "secret"}}
+			h.OnSuccess(&zsched.ExecutionEvent{Task: task, State: state, Attempt: 1})
+			h.OnEngineStop(&zsched.EngineEvent{})
+
+			if len(srv.payloads) != 1 {
+				t.Fatalf("payloads = %d, want 1", len(srv.payloads))
+			}
+			if included := srv.payloads[0].State.Parameters != nil; included != tt.include {
+				t.Errorf("parameters included = %v, want %v", included, tt.include)
+			}
+			if state.Parameters == nil {
+				t.Error("the state of the event was modified")
+			}
+		})
+	}
+}
+
+func TestWebhookHookFlush(t *testing.T) {
+	tests := []struct {
+		name          string
+		delay         time.Duration
+		flushTimeout  time.Duration
+		notifications int
+		delivered     int32
+	}{
+		{name: "flushed", delay: 10 * time.Millisecond, flushTimeout: time.Second, notifications: 5, delivered: 5},
+		{name: "timed out", delay: 200 * time.Millisecond, flushTimeout: 50 * time.Millisecond, notifications: 5, delivered: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delivered atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+					delivered.Add(1)
+				case <-r.Context().Done():
+				}
+			}))
+			defer srv.Close()
+
+			h := &WebhookHook{
+				Rules:        []WebhookRule{{URL: srv.URL, On: []WebhookTrigger{WebhookOnSuccess}}},
+				Workers:      1,
+				FlushTimeout: tt.flushTimeout,
+			}
+			if err := h.Initialize(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			task := zsched.NewTask("mail", func(*zsched.Context[any]) error { return nil })
+			for range tt.notifications {
+				h.OnSuccess(&zsched.ExecutionEvent{Task: task, State: &zsched.State{ID: uuid.New()}, Attempt: 1})
+			}
+			h.OnEngineStop(&zsched.EngineEvent{})
+
+			if got := delivered.Load(); got > tt.delivered || (tt.delay < tt.flushTimeout && got != tt.delivered) {
+				t.Errorf("delivered = %d, want %d", got, tt.delivered)
+			}
+
+			// late events are dropped instead of panicking on the closed queue
+			h.OnSuccess(&zsched.ExecutionEvent{Task: task, State: &zsched.State{ID: uuid.New()}, Attempt: 1})
+		})
+	}
+}
@@ -0,0 +1,517 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+// ErrWriterClosed is returned when writing to a closed log writer
+var ErrWriterClosed = errors.New("log writer is closed")
+
+// OverflowPolicy is the behavior of the log writer when its buffer is full
+type OverflowPolicy string
+
+const (
+	// OverflowDrop drops the log lines that do not fit in the buffer
+	OverflowDrop OverflowPolicy = "drop"
+
+	// OverflowBlock blocks the logging goroutine until the buffer has room
+	OverflowBlock OverflowPolicy = "block"
+)
+
+// insertLogQuery inserts a log line, replayed lines are ignored if already written
+const insertLogQuery = `
+	INSERT INTO logs (id, task_id, state_id, level, message, data, logged_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT DO NOTHING
+`
+
+// logRow is a log line waiting to be written to the logs table
+type logRow struct {
+	ID       string    `json:"id"`
+	TaskID   string    `json:"task_id"`
+	StateID  string    `json:"state_id"`
+	Level    string    `json:"level"`
+	Message  string    `json:"message"`
+	Data     string    `json:"data"`
+	LoggedAt time.Time `json:"logged_at"`
+}
+
+// Option configures the writer of the logs table
+type Option func(*writerConfig)
+
+// writerConfig is the configuration of a log writer
+type writerConfig struct {
+	bufferSize int
+	batchSize  int
+	interval   time.Duration
+	overflow   OverflowPolicy
+	spoolDir   string
+}
+
+// WithBufferSize sets the number of log lines buffered before the overflow policy applies, default is 10000
+func WithBufferSize(size int) Option {
+	return func(c *writerConfig) {
+		c.bufferSize = size
+	}
+}
+
+// WithBatchSize sets the number of log lines triggering a flush, default is 500
+func WithBatchSize(size int) Option {
+	return func(c *writerConfig) {
+		c.batchSize = size
+	}
+}
+
+// WithFlushInterval sets the maximum time a log line waits before being flushed, default is 1 second
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *writerConfig) {
+		c.interval = interval
+	}
+}
+
+// WithOverflowPolicy sets the behavior when the buffer is full, default is OverflowDrop
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(c *writerConfig) {
+		c.overflow = policy
+	}
+}
+
+// WithSpoolDir sets the directory keeping the log lines that failed to be written,
+// empty disables spooling. The default is "zsched-spool/<pid>" in the temporary
+// directory, so the processes of a host never replay each other's files, but the
+// lines left by a previous process are only replayed from a directory of its own.
+// The directory must not be shared by running processes.
+func WithSpoolDir(dir string) Option {
+	return func(c *writerConfig) {
+		c.spoolDir = dir
+	}
+}
+
+// logWriter writes log lines to the storage in batches, flushed when the
+// batch is full or on every interval. Batches that fail while the storage is
+// unreachable are spooled to local files and replayed once it is back, lines
+// rejected by the storage are set aside in ".invalid" files.
+type logWriter struct {
+	storage   storage.Storage
+	rows      chan logRow
+	batchSize int
+	interval  time.Duration
+	overflow  OverflowPolicy
+	spoolDir  string
+
+	dropped   atomic.Int64
+	done      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newLogWriter creates the logs table if needed, then a log writer and starts its worker
+func newLogWriter(storage storage.Storage, opts ...Option) *logWriter {
+	createLogsTable(storage)
+
+	config := writerConfig{
+		bufferSize: 10000,
+		batchSize:  500,
+		interval:   time.Second,
+		overflow:   OverflowDrop,
+		spoolDir:   defaultSpoolDir(),
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	w := &logWriter{
+		storage:   storage,
+		rows:      make(chan logRow, config.bufferSize),
+		batchSize: config.batchSize,
+		interval:  config.interval,
+		overflow:  config.overflow,
+		spoolDir:  config.spoolDir,
+		done:      make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	go w.worker()
+	return w
+}
+
+// defaultSpoolDir returns the spool directory of the process in the temporary directory
+func defaultSpoolDir() string {
+	return filepath.Join(os.TempDir(), "zsched-spool", strconv.Itoa(os.Getpid()))
+}
+
+// migrateLogsKeyQuery adds the id to the primary key of logs tables created without it,
+// where lines of an execution logged at the same time collide. Existing lines get an id.
+// The lock serializes the nodes starting together.
+const migrateLogsKeyQuery = `
+	DO $$
+	BEGIN
+		PERFORM pg_advisory_xact_lock(hashtext('zsched_logs_pkey'));
+
+		IF NOT EXISTS (
+			SELECT 1 FROM pg_index i
+			JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+			WHERE i.indrelid = 'logs'::regclass AND i.indisprimary AND a.attname = 'id'
+		) THEN
+			UPDATE logs SET id = gen_random_uuid() WHERE id IS NULL;
+			ALTER TABLE logs DROP CONSTRAINT IF EXISTS logs_pkey;
+			ALTER TABLE logs ADD PRIMARY KEY (task_id, logged_at, id);
+		END IF;
+	END
+	$$
+`
+
+// createLogsTable creates the logs table and its retention policy
+func createLogsTable(storage storage.Storage) {
+	_, err := storage.Exec(`
+		CREATE TABLE IF NOT EXISTS logs (
+			task_id UUID,
+			state_id UUID,
+			level VARCHAR(10),
+			message TEXT,
+			data JSONB,
+			logged_at TIMESTAMPTZ,
+			id UUID,
+			PRIMARY KEY (task_id, logged_at, id)
+		)
+		WITH (
+			tsdb.hypertable,
+			tsdb.partition_column='logged_at',
+			tsdb.orderby='logged_at DESC'
+		)
+	`)
+	if err != nil {
+		log.Fatalf("failed to create logs table: %v", err)
+	}
+
+	_, err = storage.Exec(`ALTER TABLE logs ADD COLUMN IF NOT EXISTS id UUID`)
+	if err != nil {
+		log.Fatalf("failed to add id to logs table: %v", err)
+	}
+
+	_, err = storage.Exec(migrateLogsKeyQuery)
+	if err != nil {
+		log.Fatalf("failed to add id to the primary key of logs table: %v", err)
+	}
+
+	_, err = storage.Exec(
+		`SELECT add_retention_policy('logs', drop_after => INTERVAL '7 days', if_not_exists => true)`,
+	)
+	if err != nil {
+		log.Fatalf("failed to create retention policy: %v", err)
+	}
+}
+
+// Write queues a log line according to the overflow policy
+func (w *logWriter) Write(row logRow) error {
+	select {
+	case <-w.done:
+		return ErrWriterClosed
+	default:
+	}
+
+	// The id is set before spooling so replayed lines are not written twice
+	if row.ID == "" {
+		row.ID = uuid.NewString()
+	}
+
+	if w.overflow == OverflowBlock {
+		select {
+		case w.rows <- row:
+			return nil
+		case <-w.done:
+			return ErrWriterClosed
+		}
+	}
+
+	select {
+	case w.rows <- row:
+	default:
+		w.dropped.Add(1)
+	}
+
+	return nil
+}
+
+// Close flushes the queued log lines and stops the worker
+func (w *logWriter) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	<-w.closed
+	return nil
+}
+
+func (w *logWriter) worker() {
+	defer close(w.closed)
+
+	interval := time.NewTicker(w.interval)
+	defer interval.Stop()
+
+	pending := make([]logRow, 0, w.batchSize)
+
+	for {
+		select {
+		case row := <-w.rows:
+			pending = append(pending, row)
+			if len(pending) >= w.batchSize {
+				w.flush(pending)
+				pending = pending[:0]
+			}
+		case <-interval.C:
+			if len(pending) > 0 {
+				w.flush(pending)
+				pending = pending[:0]
+			}
+			if dropped := w.dropped.Swap(0); dropped > 0 {
+				log.Printf("log buffer is full, dropped %d log lines", dropped)
+			}
+			w.replay()
+		case <-w.done:
+		drain:
+			for {
+				select {
+				case row := <-w.rows:
+					pending = append(pending, row)
+				default:
+					break drain
+				}
+			}
+			for batch := range slices.Chunk(pending, w.batchSize) {
+				w.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// flush writes a batch of log lines, spooling them when the storage is unreachable
+func (w *logWriter) flush(rows []logRow) {
+	rejected, err := w.write(rows)
+	if err != nil {
+		log.Printf("failed to flush logs to storage: %v", err)
+		if err := w.spool(rows); err != nil {
+			log.Printf("failed to spool %d log lines: %v", len(rows), err)
+		}
+		return
+	}
+
+	w.reject(rejected)
+}
+
+// write writes a batch of log lines, isolating the lines rejected by the storage.
+// It returns the rejected lines, or an error when the storage is unreachable.
+// Written lines may be written again, they are ignored thanks to their id.
+func (w *logWriter) write(rows []logRow) ([]logRow, error) {
+	err := w.execute(rows)
+	if err == nil {
+		return nil, nil
+	}
+	if !storage.IsDataError(err) {
+		return nil, err
+	}
+	if len(rows) == 1 {
+		log.Printf("storage rejected a log line: %v", err)
+		return rows, nil
+	}
+
+	// The batch fails as a whole, so it is split until the rejected lines are found
+	half := len(rows) / 2
+	left, err := w.write(rows[:half])
+	if err != nil {
+		return nil, err
+	}
+	right, err := w.write(rows[half:])
+	if err != nil {
+		return nil, err
+	}
+
+	return append(left, right...), nil
+}
+
+// execute writes a batch of log lines to the storage
+func (w *logWriter) execute(rows []logRow) error {
+	batch := w.storage.NewBatch()
+	for _, r := range rows {
+		if err := batch.Add(insertLogQuery, r.ID, r.TaskID, r.StateID, r.Level, r.Message, r.Data, r.LoggedAt); err != nil {
+			return err
+		}
+	}
+
+	return batch.Execute()
+}
+
+// spool saves log lines to a new spool file
+func (w *logWriter) spool(rows []logRow) error {
+	if w.spoolDir == "" {
+		return errors.New("spooling is disabled")
+	}
+
+	return writeSpool(filepath.Join(w.spoolDir, fmt.Sprintf("logs-%d.jsonl", time.Now().UnixNano())), rows)
+}
+
+// reject sets the log lines rejected by the storage aside, so they are never replayed
+// but can still be inspected
+func (w *logWriter) reject(rows []logRow) {
+	if len(rows) == 0 {
+		return
+	}
+
+	if w.spoolDir == "" {
+		log.Printf("dropped %d log lines rejected by the storage", len(rows))
+		return
+	}
+
+	path := filepath.Join(w.spoolDir, fmt.Sprintf("logs-%d.jsonl.invalid", time.Now().UnixNano()))
+	if err := writeSpool(path, rows); err != nil {
+		log.Printf("failed to set %d rejected log lines aside: %v", len(rows), err)
+		return
+	}
+
+	log.Printf("set %d log lines rejected by the storage aside in %s", len(rows), path)
+}
+
+// replay writes the spooled log lines, oldest first, until the storage is unreachable.
+// The number of replayed lines of a file is saved after every batch so an
+// interrupted file resumes where it stopped.
+func (w *logWriter) replay() {
+	if w.spoolDir == "" {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join(w.spoolDir, "logs-*.jsonl"))
+	if err != nil || len(files) == 0 {
+		return
+	}
+	slices.Sort(files)
+
+	for _, path := range files {
+		rows, err := readSpool(path)
+		if err != nil {
+			// Set the file aside so it is not read again on every interval
+			log.Printf("failed to read spooled logs %s: %v", path, err)
+			os.Rename(path, path+".invalid")
+			os.Remove(path + ".offset")
+			continue
+		}
+
+		offset := readOffset(path)
+		for offset < len(rows) {
+			batch := rows[offset:min(offset+w.batchSize, len(rows))]
+
+			// Lines spooled by older versions have no id
+			for i := range batch {
+				if batch[i].ID == "" {
+					batch[i].ID = uuid.NewString()
+				}
+			}
+
+			rejected, err := w.write(batch)
+			if err != nil {
+				return
+			}
+			w.reject(rejected)
+
+			offset += len(batch)
+			if err := writeOffset(path, offset); err != nil {
+				log.Printf("failed to save the progress of spooled logs %s: %v", path, err)
+			}
+		}
+
+		if err := errors.Join(os.Remove(path), removeIfExists(path+".offset")); err != nil {
+			log.Printf("failed to remove spooled logs %s: %v", path, err)
+		}
+	}
+}
+
+// writeSpool writes log lines to a file, the file is renamed once complete
+// so a crash never leaves a partial file to replay
+func writeSpool(path string, rows []logRow) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for _, r := range rows {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := errors.Join(bw.Flush(), f.Close()); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// readOffset returns the number of lines of a spool file already replayed
+func readOffset(path string) int {
+	data, err := os.ReadFile(path + ".offset")
+	if err != nil {
+		return 0
+	}
+
+	offset, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// writeOffset saves the number of lines of a spool file already replayed
+func writeOffset(path string, offset int) error {
+	if err := os.WriteFile(path+".offset.tmp", []byte(strconv.Itoa(offset)), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".offset.tmp", path+".offset")
+}
+
+// removeIfExists removes a file, ignoring a missing file
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// readSpool reads the log lines of a spool file
+func readSpool(path string) ([]logRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows := make([]logRow, 0)
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var r logRow
+		if err := dec.Decode(&r); err != nil {
+			return nil, err
+		}
+		rows = append(rows, r)
+	}
+
+	return rows, nil
+}
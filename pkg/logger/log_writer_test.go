@@ -0,0 +1,221 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestLogWriterIDs(t *testing.T) {
+	s := storagetest.New(nil)
+	w := newLogWriter(s, WithSpoolDir(""))
+
+	id := uuid.NewString()
+	rows := []logRow{
+		{TaskID: uuid.NewString(), StateID: uuid.NewString(), Message: "first"},
+		{TaskID: uuid.NewString(), StateID: uuid.NewString(), Message: "second"},
+		{ID: id, TaskID: uuid.NewString(), StateID: uuid.NewString(), Message: "replayed"},
+	}
+	for _, r := range rows {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	inserts := s.Find("INSERT INTO logs")
+	if len(inserts) != len(rows) {
+		t.Fatalf("inserts = %d, want %d", len(inserts), len(rows))
+	}
+
+	seen := make(map[string]bool)
+	for i, q := range inserts {
+		got, _ := q.Args[0].(string)
+		if _, err := uuid.Parse(got); err != nil {
+			t.Errorf("insert %d has id %q", i, got)
+		}
+		if seen[got] {
+			t.Errorf("insert %d reuses id %s", i, got)
+		}
+		seen[got] = true
+	}
+	if inserts[2].Args[0] != id {
+		t.Errorf("replayed id = %v, want %s", inserts[2].Args[0], id)
+	}
+}
+
+func TestLogWriterReplay(t *testing.T) {
+	outage := errors.New("connection refused")
+	invalid := &pgconn.PgError{Code: "22P02", Message: "invalid input syntax for type uuid"}
+
+	tests := []struct {
+		name     string
+		files    [][]string
+		offset   int
+		err      func(message string) error
+		written  []string
+		rejected []string
+		spooled  int
+	}{
+		{
+			name:    "replays every file",
+			files:   [][]string{{"a", "b", "c"}, {"d"}},
+			written: []string{"a", "b", "c", "d"},
+		},
+		{
+			name:  "stops while the storage is unreachable",
+			files: [][]string{{"a", "b", "c"}, {"d"}},
+			err: func(message string) error {
+				if message == "c" {
+					return outage
+				}
+				return nil
+			},
+			written: []string{"a", "b"},
+			spooled: 2,
+		},
+		{
+			name:  "sets rejected lines aside",
+			files: [][]string{{"a", "bad", "c"}, {"d"}},
+			err: func(message string) error {
+				if message == "bad" {
+					return invalid
+				}
+				return nil
+			},
+			written:  []string{"a", "c", "d"},
+			rejected: []string{"bad"},
+		},
+		{
+			name:    "resumes an interrupted file",
+			files:   [][]string{{"a", "b", "c"}},
+			offset:  2,
+			written: []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for i, messages := range tt.files {
+				rows := make([]logRow, len(messages))
+				for j, m := range messages {
+					rows[j] = logRow{ID: uuid.NewString(), Message: m}
+				}
+				path := filepath.Join(dir, fmt.Sprintf("logs-%d.jsonl", i))
+				if err := writeSpool(path, rows); err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 && tt.offset > 0 {
+					if err := writeOffset(path, tt.offset); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			written := make([]string, 0)
+			s := storagetest.New(func(q storagetest.Query) storagetest.Result {
+				message := q.Args[4].(string)
+				if tt.err != nil {
+					if err := tt.err(message); err != nil {
+						return storagetest.Result{Err: err}
+					}
+				}
+				if !slices.Contains(written, message) {
+					written = append(written, message)
+				}
+				return storagetest.Result{}
+			})
+
+			w := &logWriter{storage: s, batchSize: 2, spoolDir: dir}
+			w.replay()
+
+			if !slices.Equal(written, tt.written) {
+				t.Errorf("written = %v, want %v", written, tt.written)
+			}
+
+			rejected := make([]string, 0)
+			invalidFiles, _ := filepath.Glob(filepath.Join(dir, "*.invalid"))
+			for _, path := range invalidFiles {
+				rows, err := readSpool(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range rows {
+					rejected = append(rejected, r.Message)
+				}
+			}
+			if !slices.Equal(rejected, tt.rejected) {
+				t.Errorf("rejected = %v, want %v", rejected, tt.rejected)
+			}
+
+			spooled, _ := filepath.Glob(filepath.Join(dir, "logs-*.jsonl"))
+			if len(spooled) != tt.spooled {
+				t.Errorf("spool files = %d, want %d", len(spooled), tt.spooled)
+			}
+		})
+	}
+}
+
+func TestLogWriterResumesAfterOutage(t *testing.T) {
+	dir := t.TempDir()
+	rows := []logRow{{Message: "a"}, {Message: "b"}, {Message: "c"}}
+	if err := writeSpool(filepath.Join(dir, "logs-0.jsonl"), rows); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lines spooled by older versions have no id, they must not be written twice
+	down := true
+	s := storagetest.New(func(q storagetest.Query) storagetest.Result {
+		if q.Args[4] == "c" && down {
+			return storagetest.Result{Err: errors.New("connection refused")}
+		}
+		return storagetest.Result{}
+	})
+
+	w := &logWriter{storage: s, batchSize: 2, spoolDir: dir}
+	w.replay()
+	down = false
+	w.replay()
+
+	counts := make(map[any]int)
+	for _, q := range s.Find("INSERT INTO logs") {
+		counts[q.Args[4]]++
+	}
+	if counts["a"] != 1 || counts["b"] != 1 {
+		t.Errorf("inserts = %v, want a and b written once", counts)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("spool directory has %d files left", len(files))
+	}
+}
+
+func TestCreateLogsTable(t *testing.T) {
+	s := storagetest.New(nil)
+	createLogsTable(s)
+
+	// The id is added to the primary key of older tables once the column exists
+	queries := s.Queries()
+	column := slices.IndexFunc(queries, func(q storagetest.Query) bool { return q.Contains("ADD COLUMN IF NOT EXISTS id") })
+	key := slices.IndexFunc(queries, func(q storagetest.Query) bool { return q.Contains("ADD PRIMARY KEY (task_id, logged_at, id)") })
+	if column < 0 || key < column {
+		t.Errorf("queries = %v, want the primary key migrated after the id column", queries)
+	}
+	if key >= 0 && !queries[key].Contains("UPDATE logs SET id = gen_random_uuid() WHERE id IS NULL") {
+		t.Error("existing lines are not given an id before the primary key changes")
+	}
+}
+
+func TestDefaultSpoolDir(t *testing.T) {
+	dir := defaultSpoolDir()
+	if filepath.Base(dir) != fmt.Sprint(os.Getpid()) || filepath.Base(filepath.Dir(dir)) != "zsched-spool" {
+		t.Errorf("spool dir = %s, want zsched-spool/<pid>", dir)
+	}
+}
@@ -1,15 +1,88 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vlourme/zsched/pkg/storage"
 )
 
-type Logger = *logrus.Entry
+// Fields is a set of fields attached to log lines, logrus.Fields is accepted as is
+type Fields = map[string]any
 
-func NewLogger(storage storage.Storage) Logger {
+// Logger is the logger of the engine and of the task contexts,
+// see NewLogrusLogger and NewSlogLogger for the available backends.
+// Fields named task_id and state_id attach a line to an execution.
+type Logger interface {
+	// WithField returns a logger adding a field to every line
+	WithField(key string, value any) Logger
+
+	// WithFields returns a logger adding fields to every line
+	WithFields(fields Fields) Logger
+
+	// WithError returns a logger adding an error field to every line
+	WithError(err error) Logger
+
+	// Fields returns the fields added to every line, the map of the logrus
+	// backend is shared with the logger while other backends return a copy
+	Fields() Fields
+
+	Trace(args ...any)
+	Debug(args ...any)
+	Print(args ...any)
+	Info(args ...any)
+	Warn(args ...any)
+	Warning(args ...any)
+	Error(args ...any)
+	Fatal(args ...any)
+	Panic(args ...any)
+
+	Tracef(format string, args ...any)
+	Debugf(format string, args ...any)
+	Printf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Warningf(format string, args ...any)
+	Errorf(format string, args ...any)
+	Fatalf(format string, args ...any)
+	Panicf(format string, args ...any)
+
+	Traceln(args ...any)
+	Debugln(args ...any)
+	Println(args ...any)
+	Infoln(args ...any)
+	Warnln(args ...any)
+	Warningln(args ...any)
+	Errorln(args ...any)
+	Fatalln(args ...any)
+	Panicln(args ...any)
+}
+
+// Compatible is a logger accepted by Adapt: a Logger, a *logrus.Entry or a *logrus.Logger
+type Compatible interface {
+	Info(args ...any)
+}
+
+// Adapt returns the Logger of a compatible logger, logrus entries and loggers
+// are wrapped with NewLogrusLogger
+func Adapt(logger Compatible) (Logger, error) {
+	switch l := logger.(type) {
+	case Logger:
+		return l, nil
+	case *logrus.Entry:
+		return NewLogrusLogger(l), nil
+	case *logrus.Logger:
+		return NewLogrusLogger(logrus.NewEntry(l)), nil
+	default:
+		return nil, fmt.Errorf("unsupported logger %T", logger)
+	}
+}
+
+// NewLogger creates the default logger, backed by logrus, shipping
+// the logs of executions to the logs table of TimescaleDB storages
+func NewLogger(storage storage.Storage, opts ...Option) Logger {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.TextFormatter{
 		TimestampFormat: time.Kitchen,
@@ -17,8 +90,16 @@ func NewLogger(storage storage.Storage) Logger {
 	})
 
 	if storage.Name() == "timescaledb" {
-		logger.AddHook(NewTimescaleDBHook(storage))
+		logger.AddHook(NewTimescaleDBHook(storage, opts...))
 	}
 
-	return logrus.NewEntry(logger)
+	return NewLogrusLogger(logrus.NewEntry(logger))
+}
+
+// Close flushes and closes the sinks of the logger holding resources
+func Close(logger Logger) error {
+	if c, ok := logger.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
 }
@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAdapt(t *testing.T) {
+	entry := logrus.NewEntry(logrus.New())
+	slogLogger := NewSlogLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name   string
+		logger Compatible
+		err    bool
+	}{
+		{name: "logger", logger: slogLogger},
+		{name: "logrus entry", logger: entry},
+		{name: "logrus logger", logger: logrus.New()},
+		{name: "unsupported", logger: unsupportedLogger{}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := Adapt(tt.logger)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if !tt.err && l == nil {
+				t.Error("logger is nil")
+			}
+		})
+	}
+}
+
+// unsupportedLogger only implements Compatible
+type unsupportedLogger struct{}
+
+func (unsupportedLogger) Info(args ...any) {}
+
+func TestLoggerFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		logger Logger
+		shared bool
+	}{
+		{name: "logrus", logger: NewLogrusLogger(logrus.NewEntry(logrus.New())), shared: true},
+		{name: "slog", logger: NewSlogLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := tt.logger.WithField("task_id", "task").WithFields(Fields{"state_id": "state"})
+
+			fields := l.Fields()
+			if fields["task_id"] != "task" || fields["state_id"] != "state" {
+				t.Fatalf("fields = %v", fields)
+			}
+
+			fields["tenant"] = "acme"
+			if _, shared := l.Fields()["tenant"]; shared != tt.shared {
+				t.Errorf("shared = %v, want %v", shared, tt.shared)
+			}
+			if _, leaked := tt.logger.Fields()["task_id"]; leaked {
+				t.Error("fields leaked to the parent logger")
+			}
+		})
+	}
+}
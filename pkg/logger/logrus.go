@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"errors"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logrusLogger is the logrus backend of Logger
+type logrusLogger struct {
+	*logrus.Entry
+}
+
+// NewLogrusLogger creates a logger backed by a logrus entry
+func NewLogrusLogger(entry *logrus.Entry) Logger {
+	return &logrusLogger{entry}
+}
+
+func (l *logrusLogger) WithField(key string, value any) Logger {
+	return &logrusLogger{l.Entry.WithField(key, value)}
+}
+
+func (l *logrusLogger) WithFields(fields Fields) Logger {
+	return &logrusLogger{l.Entry.WithFields(fields)}
+}
+
+func (l *logrusLogger) WithError(err error) Logger {
+	return &logrusLogger{l.Entry.WithError(err)}
+}
+
+func (l *logrusLogger) Fields() Fields {
+	return l.Entry.Data
+}
+
+// Close closes the hooks of the logrus logger, such as TimescaleDBHook
+func (l *logrusLogger) Close() error {
+	closed := make(map[logrus.Hook]bool)
+
+	var err error
+	for _, hooks := range l.Entry.Logger.Hooks {
+		for _, hook := range hooks {
+			if c, ok := hook.(io.Closer); ok && !closed[hook] {
+				closed[hook] = true
+				err = errors.Join(err, c.Close())
+			}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,312 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"strings"
+
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+// LevelTrace is the slog level of Trace, below slog.LevelDebug
+const LevelTrace = slog.Level(-8)
+
+// slogLogger is the log/slog backend of Logger. Fatal logs at the error level
+// then exits, Panic logs at the error level then panics, like logrus.
+type slogLogger struct {
+	logger *slog.Logger
+	fields Fields
+}
+
+// NewSlogLogger creates a logger backed by a slog logger, use a
+// TimescaleDBHandler to ship the logs of executions to the logs table
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger, fields: Fields{}}
+}
+
+func (l *slogLogger) WithField(key string, value any) Logger {
+	return l.WithFields(Fields{key: value})
+}
+
+func (l *slogLogger) WithFields(fields Fields) Logger {
+	merged := maps.Clone(l.fields)
+	args := make([]any, 0, len(fields)*2)
+	for k, v := range fields {
+		merged[k] = v
+		args = append(args, k, v)
+	}
+	return &slogLogger{logger: l.logger.With(args...), fields: merged}
+}
+
+func (l *slogLogger) WithError(err error) Logger {
+	return l.WithField("error", err)
+}
+
+func (l *slogLogger) Fields() Fields {
+	return maps.Clone(l.fields)
+}
+
+func (l *slogLogger) Trace(args ...any) {
+	l.logger.Log(context.Background(), LevelTrace, fmt.Sprint(args...))
+}
+func (l *slogLogger) Debug(args ...any)   { l.logger.Debug(fmt.Sprint(args...)) }
+func (l *slogLogger) Print(args ...any)   { l.logger.Info(fmt.Sprint(args...)) }
+func (l *slogLogger) Info(args ...any)    { l.logger.Info(fmt.Sprint(args...)) }
+func (l *slogLogger) Warn(args ...any)    { l.logger.Warn(fmt.Sprint(args...)) }
+func (l *slogLogger) Warning(args ...any) { l.logger.Warn(fmt.Sprint(args...)) }
+func (l *slogLogger) Error(args ...any)   { l.logger.Error(fmt.Sprint(args...)) }
+func (l *slogLogger) Fatal(args ...any)   { l.fatal(fmt.Sprint(args...)) }
+func (l *slogLogger) Panic(args ...any)   { l.panic(fmt.Sprint(args...)) }
+
+func (l *slogLogger) Tracef(format string, args ...any) {
+	l.logger.Log(context.Background(), LevelTrace, fmt.Sprintf(format, args...))
+}
+func (l *slogLogger) Debugf(format string, args ...any) { l.logger.Debug(fmt.Sprintf(format, args...)) }
+func (l *slogLogger) Printf(format string, args ...any) { l.logger.Info(fmt.Sprintf(format, args...)) }
+func (l *slogLogger) Infof(format string, args ...any)  { l.logger.Info(fmt.Sprintf(format, args...)) }
+func (l *slogLogger) Warnf(format string, args ...any)  { l.logger.Warn(fmt.Sprintf(format, args...)) }
+func (l *slogLogger) Warningf(format string, args ...any) {
+	l.logger.Warn(fmt.Sprintf(format, args...))
+}
+func (l *slogLogger) Errorf(format string, args ...any) { l.logger.Error(fmt.Sprintf(format, args...)) }
+func (l *slogLogger) Fatalf(format string, args ...any) { l.fatal(fmt.Sprintf(format, args...)) }
+func (l *slogLogger) Panicf(format string, args ...any) { l.panic(fmt.Sprintf(format, args...)) }
+
+func (l *slogLogger) Traceln(args ...any) {
+	l.logger.Log(context.Background(), LevelTrace, sprintln(args...))
+}
+func (l *slogLogger) Debugln(args ...any)   { l.logger.Debug(sprintln(args...)) }
+func (l *slogLogger) Println(args ...any)   { l.logger.Info(sprintln(args...)) }
+func (l *slogLogger) Infoln(args ...any)    { l.logger.Info(sprintln(args...)) }
+func (l *slogLogger) Warnln(args ...any)    { l.logger.Warn(sprintln(args...)) }
+func (l *slogLogger) Warningln(args ...any) { l.logger.Warn(sprintln(args...)) }
+func (l *slogLogger) Errorln(args ...any)   { l.logger.Error(sprintln(args...)) }
+func (l *slogLogger) Fatalln(args ...any)   { l.fatal(sprintln(args...)) }
+func (l *slogLogger) Panicln(args ...any)   { l.panic(sprintln(args...)) }
+
+// fatal logs a message, flushes the handler and exits
+func (l *slogLogger) fatal(msg string) {
+	l.logger.Error(msg)
+	l.Close()
+	os.Exit(1)
+}
+
+// panic logs a message and panics with it
+func (l *slogLogger) panic(msg string) {
+	l.logger.Error(msg)
+	panic(msg)
+}
+
+// Close closes the handler of the slog logger, such as TimescaleDBHandler
+func (l *slogLogger) Close() error {
+	if c, ok := l.logger.Handler().(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// sprintln formats like fmt.Sprintln, without the trailing newline
+func sprintln(args ...any) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
+
+// TimescaleDBHandler is a slog handler shipping the logs of executions to the logs
+// table, records are passed on to the next handler. Records with task_id and
+// state_id attributes are written, from the info level. These attributes are
+// found outside of the groups opened with WithGroup.
+type TimescaleDBHandler struct {
+	next    slog.Handler
+	writer  *logWriter
+	attrs   []slog.Attr
+	groups  []string
+	taskID  string
+	stateID string
+}
+
+// NewTimescaleDBHandler creates a handler writing to the logs table, next may be nil
+func NewTimescaleDBHandler(storage storage.Storage, next slog.Handler, opts ...Option) *TimescaleDBHandler {
+	return &TimescaleDBHandler{
+		next:   next,
+		writer: newLogWriter(storage, opts...),
+	}
+}
+
+// Enabled implements slog.Handler
+func (h *TimescaleDBHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= slog.LevelInfo {
+		return true
+	}
+	return h.next != nil && h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler
+func (h *TimescaleDBHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelInfo {
+		if err := h.write(record); err != nil {
+			return err
+		}
+	}
+
+	if h.next != nil && h.next.Enabled(ctx, record.Level) {
+		return h.next.Handle(ctx, record)
+	}
+
+	return nil
+}
+
+// WithAttrs implements slog.Handler
+func (h *TimescaleDBHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := h.clone()
+	for _, a := range attrs {
+		if !c.execution(a) {
+			c.attrs = append(c.attrs, groupAttr(h.groups, a))
+		}
+	}
+	if h.next != nil {
+		c.next = h.next.WithAttrs(attrs)
+	}
+	return c
+}
+
+// WithGroup implements slog.Handler
+func (h *TimescaleDBHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	c := h.clone()
+	c.groups = append(c.groups, name)
+	if h.next != nil {
+		c.next = h.next.WithGroup(name)
+	}
+	return c
+}
+
+// Close flushes the buffered log lines
+func (h *TimescaleDBHandler) Close() error {
+	return h.writer.Close()
+}
+
+// write queues a record to the logs table if it belongs to an execution
+func (h *TimescaleDBHandler) write(record slog.Record) error {
+	c := h.clone()
+	record.Attrs(func(a slog.Attr) bool {
+		if !c.execution(a) {
+			c.attrs = append(c.attrs, groupAttr(h.groups, a))
+		}
+		return true
+	})
+
+	if c.taskID == "" || c.stateID == "" {
+		return nil
+	}
+
+	data := make(map[string]any, len(c.attrs))
+	for _, a := range c.attrs {
+		data[a.Key] = mergeValue(data[a.Key], attrValue(a.Value))
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return h.writer.Write(logRow{
+		TaskID:   c.taskID,
+		StateID:  c.stateID,
+		Level:    levelName(record.Level),
+		Message:  record.Message,
+		Data:     string(encoded),
+		LoggedAt: record.Time,
+	})
+}
+
+// levelName returns the name of a level as written by the logrus backend
+func levelName(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "error"
+	case level >= slog.LevelWarn:
+		return "warning"
+	case level >= slog.LevelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+// clone copies the handler, sharing its writer
+func (h *TimescaleDBHandler) clone() *TimescaleDBHandler {
+	return &TimescaleDBHandler{
+		next:    h.next,
+		writer:  h.writer,
+		attrs:   append([]slog.Attr{}, h.attrs...),
+		groups:  append([]string{}, h.groups...),
+		taskID:  h.taskID,
+		stateID: h.stateID,
+	}
+}
+
+// execution keeps the task_id and state_id attributes, returning true if the attribute is one of them
+func (h *TimescaleDBHandler) execution(a slog.Attr) bool {
+	switch a.Key {
+	case "task_id":
+		h.taskID = a.Value.Resolve().String()
+	case "state_id":
+		h.stateID = a.Value.Resolve().String()
+	default:
+		return false
+	}
+	return true
+}
+
+// groupAttr nests an attribute under the open groups
+func groupAttr(groups []string, a slog.Attr) slog.Attr {
+	for i := len(groups) - 1; i >= 0; i-- {
+		a = slog.Attr{Key: groups[i], Value: slog.GroupValue(a)}
+	}
+	return a
+}
+
+// mergeValue merges the attributes of a group logged in several times
+func mergeValue(existing, value any) any {
+	dst, ok := existing.(map[string]any)
+	if !ok {
+		return value
+	}
+	src, ok := value.(map[string]any)
+	if !ok {
+		return value
+	}
+
+	for k, v := range src {
+		dst[k] = mergeValue(dst[k], v)
+	}
+	return dst
+}
+
+// attrValue converts a slog value to a JSON encodable value
+func attrValue(v slog.Value) any {
+	v = v.Resolve()
+
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := make(map[string]any)
+		for _, a := range v.Group() {
+			group[a.Key] = attrValue(a.Value)
+		}
+		return group
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+		return v.Any()
+	default:
+		return v.Any()
+	}
+}
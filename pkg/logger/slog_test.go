@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+)
+
+func TestTimescaleDBHandler(t *testing.T) {
+	taskID, stateID := uuid.NewString(), uuid.NewString()
+
+	tests := []struct {
+		name    string
+		log     func(l *slog.Logger)
+		written bool
+		data    string
+	}{
+		{
+			name:    "top level",
+			log:     func(l *slog.Logger) { l.With("task_id", taskID, "state_id", stateID).Info("sent", "to", "a") },
+			written: true,
+			data:    `{"to":"a"}`,
+		},
+		{
+			name: "inside a group",
+			log: func(l *slog.Logger) {
+				l.WithGroup("app").With("task_id", taskID, "state_id", stateID).Info("sent", "to", "a")
+			},
+			written: true,
+			data:    `{"app":{"to":"a"}}`,
+		},
+		{
+			name:    "on the record",
+			log:     func(l *slog.Logger) { l.WithGroup("app").Info("sent", "task_id", taskID, "state_id", stateID) },
+			written: true,
+			data:    `{}`,
+		},
+		{
+			name: "without execution",
+			log:  func(l *slog.Logger) { l.Info("sent", "task_id", taskID) },
+		},
+		{
+			name: "below info",
+			log:  func(l *slog.Logger) { l.Debug("sent", "task_id", taskID, "state_id", stateID) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := storagetest.New(nil)
+			h := NewTimescaleDBHandler(s, nil, WithSpoolDir(""))
+			tt.log(slog.New(h))
+			h.Close()
+
+			inserts := s.Find("INSERT INTO logs")
+			if written := len(inserts) == 1; written != tt.written {
+				t.Fatalf("inserts = %d, want written %v", len(inserts), tt.written)
+			}
+			if !tt.written {
+				return
+			}
+
+			if args := inserts[0].Args; args[1] != taskID || args[2] != stateID || args[5] != tt.data {
+				t.Errorf("args = %v, want %s %s %s", args[1:6], taskID, stateID, tt.data)
+			}
+		})
+	}
+}
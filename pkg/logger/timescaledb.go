@@ -2,46 +2,20 @@ package logger
 
 import (
 	"encoding/json"
-	"errors"
-	"log"
+	"fmt"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vlourme/zsched/pkg/storage"
 )
 
+// TimescaleDBHook ships the logrus logs of executions to the logs table, without
+// blocking the tasks on database round-trips
 type TimescaleDBHook struct {
-	storage storage.Storage
+	writer *logWriter
 }
 
-func NewTimescaleDBHook(storage storage.Storage) *TimescaleDBHook {
-	_, err := storage.Exec(`
-		CREATE TABLE IF NOT EXISTS logs (
-			task_id UUID,
-			state_id UUID,
-			level VARCHAR(10),
-			message TEXT,
-			data JSONB,
-			logged_at TIMESTAMPTZ,
-			PRIMARY KEY (task_id, state_id, logged_at)
-		)
-		WITH (
-			tsdb.hypertable,
-			tsdb.partition_column='logged_at',
-			tsdb.orderby='logged_at DESC'
-		)
-	`)
-	if err != nil {
-		log.Fatalf("failed to create logs table: %v", err)
-	}
-
-	_, err = storage.Exec(
-		`SELECT add_retention_policy('logs', drop_after => INTERVAL '7 days', if_not_exists => true)`,
-	)
-	if err != nil {
-		log.Fatalf("failed to create retention policy: %v", err)
-	}
-
-	return &TimescaleDBHook{storage}
+func NewTimescaleDBHook(storage storage.Storage, opts ...Option) *TimescaleDBHook {
+	return &TimescaleDBHook{writer: newLogWriter(storage, opts...)}
 }
 
 func (h *TimescaleDBHook) Fire(entry *logrus.Entry) error {
@@ -62,16 +36,14 @@ func (h *TimescaleDBHook) Fire(entry *logrus.Entry) error {
 		return err
 	}
 
-	query := `
-		INSERT INTO logs (task_id, state_id, level, message, data, logged_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`
-	_, err = h.storage.Exec(query, taskId, stateId, entry.Level, entry.Message, data, entry.Time)
-	if err != nil {
-		return errors.Join(errors.New("failed to insert log"), err)
-	}
-
-	return err
+	return h.writer.Write(logRow{
+		TaskID:   fmt.Sprint(taskId),
+		StateID:  fmt.Sprint(stateId),
+		Level:    entry.Level.String(),
+		Message:  entry.Message,
+		Data:     string(data),
+		LoggedAt: entry.Time,
+	})
 }
 
 func (h *TimescaleDBHook) Levels() []logrus.Level {
@@ -83,3 +55,8 @@ func (h *TimescaleDBHook) Levels() []logrus.Level {
 		logrus.PanicLevel,
 	}
 }
+
+// Close flushes the buffered log lines
+func (h *TimescaleDBHook) Close() error {
+	return h.writer.Close()
+}
@@ -1,6 +1,10 @@
 package storage
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
 
 type Storage interface {
 	// DB returns the database
@@ -36,3 +40,17 @@ type Batch interface {
 	// Execute executes the batch
 	Execute() error
 }
+
+// IsDataError returns true if the database rejected the data of a query,
+// such as an invalid value or a violated constraint, rather than being unreachable.
+// Such queries fail again when retried.
+func IsDataError(err error) bool {
+	var state interface{ SQLState() string }
+	if !errors.As(err, &state) {
+		return false
+	}
+
+	// Class 22 is data exception and class 23 is integrity constraint violation
+	code := state.SQLState()
+	return strings.HasPrefix(code, "22") || strings.HasPrefix(code, "23")
+}
@@ -0,0 +1,280 @@
+// Package storagetest provides an in-memory storage recording the queries it receives,
+// answered by a handler, to test the code built on storage.Storage without a database.
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+// Query is a query received by the storage
+type Query struct {
+	// SQL is the query with its whitespace collapsed, COMMIT and ROLLBACK end transactions
+	SQL string
+
+	// Args is the arguments of the query, as passed by the caller
+	Args []any
+
+	// Batch is true for the queries executed in a batch
+	Batch bool
+}
+
+// Contains returns true if the query contains the given fragment, compared with collapsed whitespace
+func (q Query) Contains(fragment string) bool {
+	return strings.Contains(q.SQL, normalize(fragment))
+}
+
+// Result is the answer of the handler to a query
+type Result struct {
+	// Columns and Rows are the rows returned to Query and QueryRow
+	Columns []string
+	Rows    [][]any
+
+	// RowsAffected is the number of rows affected returned to Exec
+	RowsAffected int64
+
+	// Err fails the query
+	Err error
+}
+
+// Handler answers the queries, a nil handler answers every query with an empty result
+type Handler func(q Query) Result
+
+// Storage is an in-memory storage.Storage
+type Storage struct {
+	mu      sync.Mutex
+	handler Handler
+	queries []Query
+	db      *sql.DB
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// New creates a storage answering the queries with the handler
+func New(handler Handler) *Storage {
+	s := &Storage{handler: handler}
+	s.db = sql.OpenDB(connector{s})
+	return s
+}
+
+// SetHandler replaces the handler of the storage
+func (s *Storage) SetHandler(handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// Queries returns the queries received so far
+func (s *Storage) Queries() []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Query(nil), s.queries...)
+}
+
+// Find returns the queries containing the given fragment
+func (s *Storage) Find(fragment string) []Query {
+	found := make([]Query, 0)
+	for _, q := range s.Queries() {
+		if q.Contains(fragment) {
+			found = append(found, q)
+		}
+	}
+	return found
+}
+
+// Reset forgets the received queries
+func (s *Storage) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = nil
+}
+
+// handle records a query and answers it
+func (s *Storage) handle(q Query) Result {
+	s.mu.Lock()
+	s.queries = append(s.queries, q)
+	handler := s.handler
+	s.mu.Unlock()
+
+	if handler == nil {
+		return Result{}
+	}
+	return handler(q)
+}
+
+func (s *Storage) Connection() (*sql.Conn, error) {
+	return s.db.Conn(context.Background())
+}
+
+func (s *Storage) Exec(query string, args ...any) (sql.Result, error) {
+	return s.db.Exec(query, args...)
+}
+
+func (s *Storage) Query(query string, args ...any) (*sql.Rows, error) {
+	return s.db.Query(query, args...)
+}
+
+func (s *Storage) QueryRow(query string, args ...any) *sql.Row {
+	return s.db.QueryRow(query, args...)
+}
+
+func (s *Storage) NewBatch() storage.Batch {
+	return &batch{storage: s}
+}
+
+// DB returns the database of the storage, to open transactions like an application would
+func (s *Storage) DB() *sql.DB {
+	return s.db
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func (s *Storage) Name() string {
+	return "storagetest"
+}
+
+// batch executes its queries one by one on Execute
+type batch struct {
+	storage *Storage
+	queries []Query
+}
+
+func (b *batch) Add(query string, args ...any) error {
+	b.queries = append(b.queries, Query{SQL: normalize(query), Args: args, Batch: true})
+	return nil
+}
+
+func (b *batch) Size() int {
+	return len(b.queries)
+}
+
+func (b *batch) Execute() error {
+	for _, q := range b.queries {
+		if res := b.storage.handle(q); res.Err != nil {
+			return errors.Join(errors.New("failed to execute batch"), res.Err)
+		}
+	}
+	return nil
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// normalize collapses the whitespace of a query
+func normalize(query string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(query, " "))
+}
+
+// connector opens the connections of a storage
+type connector struct {
+	storage *Storage
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{storage: c.storage}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return drv{}
+}
+
+type drv struct{}
+
+func (drv) Open(string) (driver.Conn, error) {
+	return nil, errors.New("storagetest: use storagetest.New")
+}
+
+// conn passes the queries and their arguments untouched to the handler
+type conn struct {
+	storage *Storage
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("storagetest: prepared statements are not supported")
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return tx{storage: c.storage}, nil
+}
+
+func (c *conn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.storage.handle(Query{SQL: normalize(query), Args: values(args)})
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.storage.handle(Query{SQL: normalize(query), Args: values(args)})
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return &rows{columns: res.Columns, rows: res.Rows}, nil
+}
+
+// values returns the arguments of a query
+func values(args []driver.NamedValue) []any {
+	vals := make([]any, len(args))
+	for i, a := range args {
+		vals[i] = a.Value
+	}
+	return vals
+}
+
+// tx sends COMMIT and ROLLBACK to the handler
+type tx struct {
+	storage *Storage
+}
+
+func (t tx) Commit() error {
+	return t.storage.handle(Query{SQL: "COMMIT"}).Err
+}
+
+func (t tx) Rollback() error {
+	return t.storage.handle(Query{SQL: "ROLLBACK"}).Err
+}
+
+// rows returns the rows of a result
+type rows struct {
+	columns []string
+	rows    [][]any
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	for i, v := range r.rows[0] {
+		dest[i] = v
+	}
+	r.rows = r.rows[1:]
+
+	return nil
+}
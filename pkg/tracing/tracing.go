@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// Exporter is the destination of the spans
+type Exporter string
+
+const (
+	// ExporterOTLP exports spans to an OTLP/HTTP endpoint
+	ExporterOTLP Exporter = "otlp"
+
+	// ExporterStdout prints spans to the standard output, useful for local testing
+	ExporterStdout Exporter = "stdout"
+)
+
+// Config is the configuration of the tracer provider
+type Config struct {
+	// ServiceName is the name of the service reported on every span, default is "zsched"
+	ServiceName string
+
+	// Exporter is the destination of the spans
+	Exporter Exporter
+
+	// Endpoint is the host and port of the OTLP endpoint, the OTEL_EXPORTER_OTLP_*
+	// environment variables are used when empty
+	Endpoint string
+
+	// Insecure disables TLS for the OTLP endpoint
+	Insecure bool
+
+	// SampleRatio is the ratio of sampled traces, between 0 and 1, default is 1.
+	// The sampling decision of the parent is always respected.
+	SampleRatio float64
+}
+
+// NewTracerProvider creates a tracer provider exporting spans as configured
+func NewTracerProvider(ctx context.Context, config Config) (*sdktrace.TracerProvider, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch config.Exporter {
+	case ExporterOTLP:
+		opts := make([]otlptracehttp.Option, 0)
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, errors.New("unknown tracing exporter: " + string(config.Exporter))
+	}
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to create tracing exporter"), err)
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "zsched"
+	}
+
+	sampleRatio := config.SampleRatio
+	if sampleRatio == 0 {
+		sampleRatio = 1
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to create tracing resource"), err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	), nil
+}
@@ -0,0 +1,142 @@
+package zsched
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/vlourme/zsched/pkg/storage"
+)
+
+// progressInterval is the minimum time between two writes of the progress of an execution
+const progressInterval = time.Second
+
+// Progress is the progress reported by an execution
+type Progress struct {
+	// Done is the number of processed items
+	Done int64 `json:"done"`
+
+	// Total is the total number of items, zero if unknown
+	Total int64 `json:"total"`
+
+	// Message describes the current step
+	Message string `json:"message,omitempty"`
+
+	// UpdatedAt is the time of the last report
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// createProgressTables creates the progress and checkpoints tables,
+// rows are deleted a week after their last update by cleanTables
+func createProgressTables(storage storage.Storage) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS progress (
+			task_id UUID PRIMARY KEY,
+			done BIGINT,
+			total BIGINT,
+			message TEXT,
+			updated_at TIMESTAMPTZ
+		)`,
+		`CREATE TABLE IF NOT EXISTS checkpoints (
+			task_id UUID,
+			key VARCHAR(128),
+			value JSONB,
+			updated_at TIMESTAMPTZ,
+			PRIMARY KEY (task_id, key)
+		)`,
+	}
+
+	for _, query := range queries {
+		if _, err := storage.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetProgress reports the progress of the execution. It is written at most once per
+// second, the last report of an attempt is always written when the attempt ends.
+func (c *Context[T]) SetProgress(done, total int64, message string) error {
+	c.progress = &Progress{Done: done, Total: total, Message: message, UpdatedAt: time.Now()}
+
+	if time.Since(c.progressWrittenAt) < progressInterval && (total == 0 || done < total) {
+		return nil
+	}
+
+	return c.flushProgress()
+}
+
+// flushProgress writes the last reported progress if it has not been written yet
+func (c *Context[T]) flushProgress() error {
+	if c.progress == nil || !c.progress.UpdatedAt.After(c.progressWrittenAt) {
+		return nil
+	}
+
+	_, err := c.storage.Exec(
+		`
+		INSERT INTO progress (task_id, done, total, message, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (task_id)
+		DO UPDATE SET done = $2, total = $3, message = $4, updated_at = $5
+		`,
+		c.TaskID,
+		c.progress.Done,
+		c.progress.Total,
+		c.progress.Message,
+		c.progress.UpdatedAt,
+	)
+	if err != nil {
+		return errors.Join(errors.New("failed to save progress"), err)
+	}
+
+	c.progressWrittenAt = c.progress.UpdatedAt
+	return nil
+}
+
+// Checkpoint saves a JSON encodable value under the key, retries of the
+// execution read it back with LoadCheckpoint to resume their work
+func (c *Context[T]) Checkpoint(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Join(errors.New("failed to encode checkpoint"), err)
+	}
+
+	_, err = c.storage.Exec(
+		`
+		INSERT INTO checkpoints (task_id, key, value, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (task_id, key)
+		DO UPDATE SET value = $3, updated_at = $4
+		`,
+		c.TaskID,
+		key,
+		string(data),
+		time.Now(),
+	)
+	if err != nil {
+		return errors.Join(errors.New("failed to save checkpoint"), err)
+	}
+
+	return nil
+}
+
+// LoadCheckpoint decodes the last checkpoint saved under the key into value,
+// it returns false if no checkpoint has been saved by a previous attempt
+func (c *Context[T]) LoadCheckpoint(key string, value any) (bool, error) {
+	var data []byte
+	err := c.storage.QueryRow(`SELECT value FROM checkpoints WHERE task_id = $1 AND key = $2`, c.TaskID, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Join(errors.New("failed to load checkpoint"), err)
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, errors.Join(errors.New("failed to decode checkpoint"), err)
+	}
+
+	return true, nil
+}
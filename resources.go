@@ -0,0 +1,247 @@
+package zsched
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/storage"
+	"golang.org/x/sync/semaphore"
+)
+
+// resourcePollInterval is the time between two attempts to acquire a cluster-wide resource
+const resourcePollInterval = 500 * time.Millisecond
+
+// Resource is a named pool of capacity shared by the tasks requiring it
+type Resource struct {
+	// Name is the name of the resource
+	Name string `json:"name"`
+
+	// Capacity is the total weight of the executions holding the resource at once
+	Capacity int64 `json:"capacity"`
+
+	// ClusterWide shares the capacity between every node, counted in the storage
+	ClusterWide bool `json:"cluster_wide"`
+
+	sem *semaphore.Weighted
+}
+
+// ResourceRequirement is the weight of a resource held by every execution of a task
+type ResourceRequirement struct {
+	// Name is the name of the resource
+	Name string `json:"name"`
+
+	// Weight is the share of the capacity held by an execution
+	Weight int64 `json:"weight"`
+}
+
+// WithClusterWide shares the capacity of the resource between every node instead of per node
+func WithClusterWide() func(*Resource) {
+	return func(r *Resource) {
+		r.ClusterWide = true
+	}
+}
+
+// WithResource makes the executions of the task hold a weight of the resource while they run,
+// the resource is defined on the engine with DefineResource
+func WithResource(name string, weight int64) func(*taskConfig) {
+	return func(t *taskConfig) {
+		t.Resources = append(t.Resources, ResourceRequirement{Name: name, Weight: weight})
+	}
+}
+
+// DefineResource defines a resource pool with a capacity, tasks acquire it with WithResource
+func (e *Engine[T]) DefineResource(name string, capacity int64, opts ...func(*Resource)) {
+	r := &Resource{Name: name, Capacity: capacity}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.sem = semaphore.NewWeighted(capacity)
+
+	if _, ok := e.resources[name]; ok {
+		log.Printf("resource %s already defined", name)
+	}
+
+	e.resources[name] = r
+}
+
+// validateResources checks that the resources required by the tasks are defined and large enough
+func (e *Engine[T]) validateResources() error {
+	for _, task := range e.tasks {
+		for _, req := range task.Resources {
+			r, ok := e.resources[req.Name]
+			if !ok {
+				return fmt.Errorf("task %s requires undefined resource %s", task.Name(), req.Name)
+			}
+			if req.Weight <= 0 || req.Weight > r.Capacity {
+				return fmt.Errorf("task %s requires a weight of %d of resource %s, capacity is %d", task.Name(), req.Weight, req.Name, r.Capacity)
+			}
+		}
+	}
+
+	return nil
+}
+
+// createResourceTables creates the tables of the cluster-wide resources and sets their capacity
+func createResourceTables(storage storage.Storage, resources map[string]*Resource) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS resources (
+			name VARCHAR(128) PRIMARY KEY,
+			capacity BIGINT,
+			used BIGINT
+		)`,
+		`CREATE TABLE IF NOT EXISTS resource_leases (
+			lease_id UUID PRIMARY KEY,
+			name VARCHAR(128),
+			task_id UUID,
+			node_id VARCHAR(64),
+			weight BIGINT,
+			heartbeat_at TIMESTAMPTZ
+		)`,
+	}
+
+	for _, query := range queries {
+		if _, err := storage.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range resources {
+		if !r.ClusterWide {
+			continue
+		}
+
+		_, err := storage.Exec(
+			`
+			INSERT INTO resources (name, capacity, used)
+			VALUES ($1, $2, 0)
+			ON CONFLICT (name)
+			DO UPDATE SET capacity = $2
+			`,
+			r.Name,
+			r.Capacity,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// acquireResources waits until the execution holds the resources of the task, they are
+// acquired in name order so two tasks never wait for each other
+func (e *executor[T]) acquireResources(task *Task[T], s *State) (func(), error) {
+	reqs := slices.SortedFunc(slices.Values(task.Resources), func(a, b ResourceRequirement) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	releases := make([]func(), 0, len(reqs))
+	release := func() {
+		for _, r := range slices.Backward(releases) {
+			r()
+		}
+	}
+
+	for _, req := range reqs {
+		r := e.resources[req.Name]
+
+		if err := r.sem.Acquire(e.ctx, req.Weight); err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, func() { r.sem.Release(req.Weight) })
+
+		if !r.ClusterWide {
+			continue
+		}
+
+		leaseID, err := e.acquireLease(r, req.Weight, s)
+		if err != nil {
+			release()
+			return nil, errors.Join(fmt.Errorf("failed to acquire resource %s", r.Name), err)
+		}
+		releases = append(releases, func() { e.releaseLease(leaseID) })
+	}
+
+	return release, nil
+}
+
+// acquireLease waits until the weight of a cluster-wide resource is available and leases it,
+// storage errors are retried so executions wait for the storage like for the capacity
+func (e *executor[T]) acquireLease(r *Resource, weight int64, s *State) (uuid.UUID, error) {
+	leaseID := uuid.New()
+
+	for {
+		res, err := e.storage.Exec(
+			`
+			WITH acquired AS (
+				UPDATE resources SET used = used + $2
+				WHERE name = $1 AND used + $2 <= capacity
+				RETURNING name
+			)
+			INSERT INTO resource_leases (lease_id, name, task_id, node_id, weight, heartbeat_at)
+			SELECT $3, name, $4, $5, $2, $6 FROM acquired
+			`,
+			r.Name,
+			weight,
+			leaseID,
+			s.TaskID,
+			e.nodeID,
+			time.Now(),
+		)
+		if err != nil {
+			log.Printf("failed to lease resource %s, retrying: %v", r.Name, err)
+		} else if n, err := res.RowsAffected(); err == nil && n > 0 {
+			return leaseID, nil
+		}
+
+		select {
+		case <-e.ctx.Done():
+			return uuid.Nil, e.ctx.Err()
+		case <-time.After(resourcePollInterval):
+		}
+	}
+}
+
+// releaseLease gives the weight of a lease back, unless it has already been reaped
+func (e *executor[T]) releaseLease(leaseID uuid.UUID) {
+	_, err := e.storage.Exec(
+		`
+		WITH released AS (
+			DELETE FROM resource_leases WHERE lease_id = $1 RETURNING name, weight
+		)
+		UPDATE resources SET used = resources.used - released.weight
+		FROM released
+		WHERE resources.name = released.name
+		`,
+		leaseID,
+	)
+	if err != nil {
+		log.Printf("failed to release resource lease: %v", err)
+	}
+}
+
+// refreshLeases refreshes the leases of the node and gives back the weight of the leases of dead nodes
+func (e *Engine[T]) refreshLeases(now time.Time) error {
+	if _, err := e.storage.Exec(`UPDATE resource_leases SET heartbeat_at = $2 WHERE node_id = $1`, e.nodeID, now); err != nil {
+		return err
+	}
+
+	_, err := e.storage.Exec(
+		`
+		WITH deleted AS (
+			DELETE FROM resource_leases WHERE heartbeat_at < $1 RETURNING name, weight
+		)
+		UPDATE resources SET used = resources.used - expired.weight
+		FROM (SELECT name, SUM(weight) AS weight FROM deleted GROUP BY name) AS expired
+		WHERE resources.name = expired.name
+		`,
+		now.Add(-e.heartbeatInterval*nodeTimeoutHeartbeats),
+	)
+	return err
+}
@@ -0,0 +1,86 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/storage/storagetest"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestHandleResources(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxRetries  int
+		clusterWide bool
+		saturated   bool
+		storageErrs int32
+		cancel      bool
+		err         error
+		executed    bool
+		published   int
+	}{
+		{name: "acquired", maxRetries: 3, executed: true},
+		{name: "cluster-wide", maxRetries: 3, clusterWide: true, executed: true},
+		{name: "storage errors are retried", maxRetries: 3, clusterWide: true, storageErrs: 2, executed: true},
+		{name: "shutdown while saturated", maxRetries: 3, saturated: true, cancel: true, err: broker.ErrRequeue},
+		{name: "shutdown while the storage is down", maxRetries: 3, clusterWide: true, storageErrs: 1000, cancel: true, err: broker.ErrRequeue},
+		{name: "shutdown of an auto-acknowledged task", maxRetries: 0, saturated: true, cancel: true, published: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, b, s := newTestExecutor(t)
+
+			var failures atomic.Int32
+			s.SetHandler(func(q storagetest.Query) storagetest.Result {
+				if q.Contains("INSERT INTO resource_leases") {
+					if failures.Add(1) <= tt.storageErrs {
+						return storagetest.Result{Err: errors.New("connection refused")}
+					}
+					return storagetest.Result{RowsAffected: 1}
+				}
+				return storagetest.Result{}
+			})
+
+			r := &Resource{Name: "gpu", Capacity: 1, ClusterWide: tt.clusterWide, sem: semaphore.NewWeighted(1)}
+			if tt.saturated {
+				r.sem.Acquire(context.Background(), 1)
+			}
+			e.resources = map[string]*Resource{"gpu": r}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			e.ctx = ctx
+			if tt.cancel {
+				time.AfterFunc(50*time.Millisecond, cancel)
+			}
+
+			var executed bool
+			task := NewTask("render", func(*Context[any]) error {
+				executed = true
+				return nil
+			}, WithMaxRetries(tt.maxRetries), WithResource("gpu", 1))
+
+			msg, err := e.newMessage(task.Name(), &State{ID: uuid.New(), TaskID: uuid.New()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := e.handle(task, nil, msg); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if executed != tt.executed {
+				t.Errorf("executed = %v, want %v", executed, tt.executed)
+			}
+			if got := len(b.published()); got != tt.published {
+				t.Errorf("published = %d, want %d", got, tt.published)
+			}
+		})
+	}
+}
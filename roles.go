@@ -0,0 +1,45 @@
+package zsched
+
+import "slices"
+
+// Role is a responsibility of the engine, an engine has every role by default
+type Role string
+
+const (
+	// Scheduler dispatches the cron schedules of the tasks, run it on a single node
+	Scheduler Role = "scheduler"
+
+	// Worker consumes and executes the tasks selected with WithWorkerTasks and WithWorkerTags
+	Worker Role = "worker"
+
+	// API serves the REST API when an address is set with WithAPI
+	API Role = "api"
+)
+
+// hasRole returns true if the engine has the role
+func (e *Engine[T]) hasRole(role Role) bool {
+	return len(e.roles) == 0 || slices.Contains(e.roles, role)
+}
+
+// activeRoles returns the roles of the engine
+func (e *Engine[T]) activeRoles() []Role {
+	if len(e.roles) == 0 {
+		return []Role{Scheduler, Worker, API}
+	}
+	return e.roles
+}
+
+// consumes returns true if the engine executes the task
+func (e *Engine[T]) consumes(task *Task[T]) bool {
+	if !e.hasRole(Worker) {
+		return false
+	}
+
+	if len(e.workerTasks) == 0 && len(e.workerTags) == 0 {
+		return true
+	}
+
+	return slices.Contains(e.workerTasks, task.Name()) || slices.ContainsFunc(task.Tags, func(tag string) bool {
+		return slices.Contains(e.workerTags, tag)
+	})
+}
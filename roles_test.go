@@ -0,0 +1,67 @@
+package zsched
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConsumes(t *testing.T) {
+	mail := NewTask("mail", func(*Context[any]) error { return nil }, WithTags("critical"))
+	export := NewTask("export", func(*Context[any]) error { return nil })
+
+	tests := []struct {
+		name        string
+		roles       []Role
+		workerTasks []string
+		workerTags  []string
+		consumes    map[string]bool
+	}{
+		{name: "every role", consumes: map[string]bool{"mail": true, "export": true}},
+		{name: "scheduler only", roles: []Role{Scheduler}, consumes: map[string]bool{}},
+		{name: "api and worker", roles: []Role{API, Worker}, consumes: map[string]bool{"mail": true, "export": true}},
+		{name: "selected task", workerTasks: []string{"export"}, consumes: map[string]bool{"export": true}},
+		{name: "selected tag", workerTags: []string{"critical"}, consumes: map[string]bool{"mail": true}},
+		{name: "selected task and tag", workerTasks: []string{"export"}, workerTags: []string{"critical"}, consumes: map[string]bool{"mail": true, "export": true}},
+		{name: "selection without worker role", roles: []Role{API}, workerTasks: []string{"export"}, consumes: map[string]bool{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Engine[any]{roles: tt.roles, workerTasks: tt.workerTasks, workerTags: tt.workerTags}
+
+			for _, task := range []*Task[any]{mail, export} {
+				if got := e.consumes(task); got != tt.consumes[task.Name()] {
+					t.Errorf("consumes(%s) = %v, want %v", task.Name(), got, tt.consumes[task.Name()])
+				}
+			}
+		})
+	}
+}
+
+func TestActiveRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		roles  []Role
+		active []Role
+	}{
+		{name: "default", active: []Role{Scheduler, Worker, API}},
+		{name: "selected", roles: []Role{Worker}, active: []Role{Worker}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Engine[any]{roles: tt.roles}
+
+			active := e.activeRoles()
+			if len(active) != len(tt.active) {
+				t.Fatalf("roles = %v, want %v", active, tt.active)
+			}
+			for _, role := range []Role{Scheduler, Worker, API} {
+				want := slices.Contains(tt.active, role)
+				if e.hasRole(role) != want {
+					t.Errorf("hasRole(%s) = %v, want %v", role, !want, want)
+				}
+			}
+		})
+	}
+}
@@ -14,6 +14,8 @@ const (
 	StatusRunning stateStatus = "running"
 	StatusSuccess stateStatus = "success"
 	StatusFailed  stateStatus = "failed"
+	StatusLost    stateStatus = "lost"
+	StatusExpired stateStatus = "expired"
 )
 
 // State is the State of the task
@@ -43,6 +45,44 @@ type State struct {
 
 	// LastError is the last error of the task
 	LastError string `json:"last_error"`
+
+	// NodeID is the id of the node that ran the last attempt
+	NodeID string `json:"node_id,omitempty"`
+
+	// Final is set by the executor once it stops retrying the execution
+	Final bool `json:"final,omitempty"`
+
+	// Priority is the priority of the execution, higher priorities are consumed first
+	Priority uint8 `json:"priority,omitempty"`
+
+	// NotBefore is the time before which the execution is not published, set by Delay and ETA
+	NotBefore time.Time `json:"not_before,omitzero"`
+
+	// ExpiresAt is the time after which the execution is dropped unexecuted, set by TTL and ExpiresAt
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+
+	// IdempotencyKey deduplicates the dispatches of the task with the same key
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Headers is the custom headers of the dispatch, also sent as message headers
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// CorrelationID correlates the execution with the request that dispatched it
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// Queue is the queue of the execution, empty is the queue named after the task
+	Queue string `json:"queue,omitempty"`
+
+	// FairnessKey is the key sharing the concurrency of the task with WithFairness
+	FairnessKey string `json:"fairness_key,omitempty"`
+
+	// TraceContext is the W3C trace context of the dispatch
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// expired returns true if the execution expired before being executed
+func (s *State) expired() bool {
+	return !s.ExpiresAt.IsZero() && time.Now().After(s.ExpiresAt)
 }
 
 // newState creates a new state for the task
@@ -68,7 +108,7 @@ func deserializeState(body []byte) (*State, error) {
 	return &state, nil
 }
 
-// Serialize serializes the state to a byte array
+// Serialize serializes the state to JSON
 func (s *State) Serialize() ([]byte, error) {
 	return json.Marshal(s)
 }
@@ -96,7 +136,7 @@ func (s *State) GetStr(name string, defaultValue ...string) string {
 
 // GetFloat returns the float value of the parameter by name
 func (s *State) GetFloat(name string, defaultValue ...float64) float64 {
-	value, ok := s.Parameters[name].(float64)
+	value, ok := toFloat(s.Parameters[name])
 	if !ok {
 		if len(defaultValue) > 0 {
 			return defaultValue[0]
@@ -118,12 +158,17 @@ func (s *State) GetBool(name string, defaultValue ...bool) bool {
 	return value
 }
 
-// GetInt returns the int value of the parameter by name
+// GetInt returns the int value of the parameter by name, binary codecs decode integers
+// without going through float64
 func (s *State) GetInt(name string, defaultValue ...int) int {
-	if len(defaultValue) > 0 {
-		return int(s.GetFloat(name, float64(defaultValue[0])))
+	value, ok := toInt(s.Parameters[name])
+	if !ok {
+		if len(defaultValue) > 0 {
+			return defaultValue[0]
+		}
+		return 0
 	}
-	return int(s.GetFloat(name))
+	return value
 }
 
 // GetAny returns the any value of the parameter by name
@@ -137,3 +182,43 @@ func (s *State) GetAny(name string, defaultValue ...any) any {
 	}
 	return value
 }
+
+// toFloat converts a decoded number to a float64
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// toInt converts a decoded number to an int, floats are truncated
+func toInt(value any) (int, bool) {
+	switch v := value.(type) {
+	case int64:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	case int:
+		return v, true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i), true
+		}
+	}
+
+	f, ok := toFloat(value)
+	return int(f), ok
+}
@@ -1,7 +1,9 @@
 package zsched
 
 import (
+	"context"
 	"regexp"
+	"time"
 )
 
 // nameRegex is the regex to validate the task name
@@ -25,6 +27,9 @@ type taskConfig struct {
 	// collector is the collector for the task
 	collector *Collector `json:"-"`
 
+	// middlewares is the middlewares of the task, of type Middleware[T]
+	middlewares []any `json:"-"`
+
 	// Concurrency is the number of concurrent tasks to run
 	Concurrency int `json:"concurrency"`
 
@@ -39,6 +44,21 @@ type taskConfig struct {
 
 	// Tags is the tags for the task
 	Tags []string `json:"tags"`
+
+	// ParameterSchema is the JSON schema of the task parameters
+	ParameterSchema map[string]any `json:"parameter_schema,omitempty"`
+
+	// Timeout is the maximum duration of an attempt, zero means no timeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// Queues is the additional queues consumed by the task, targeted with the Queue dispatch option
+	Queues []string `json:"queues"`
+
+	// Resources is the resources held by the executions while they run
+	Resources []ResourceRequirement `json:"resources"`
+
+	// Fairness shares the concurrency between the keys of the executions, nil consumes in dispatch order
+	Fairness *Fairness `json:"fairness,omitempty"`
 }
 
 type Task[T any] struct {
@@ -67,6 +87,8 @@ func NewTask[T any](name string, action taskAction[T], opts ...func(*taskConfig)
 			Schedules:         make([]taskSchedule, 0),
 			DefaultParameters: map[string]any{},
 			Tags:              make([]string, 0),
+			Queues:            make([]string, 0),
+			Resources:         make([]ResourceRequirement, 0),
 		},
 	}
 
@@ -74,14 +96,21 @@ func NewTask[T any](name string, action taskAction[T], opts ...func(*taskConfig)
 		opt(&t.taskConfig)
 	}
 
+	taskMiddlewares(t)
+
 	return t
 }
 
 // Execute executes one or multiple executions of the task
 func (t *Task[T]) Execute(params ...map[string]any) error {
+	return t.ExecuteContext(context.Background(), params...)
+}
+
+// ExecuteContext executes one or multiple executions of the task,
+// the trace context of ctx is propagated to the executions
+func (t *Task[T]) ExecuteContext(ctx context.Context, params ...map[string]any) error {
 	for _, p := range params {
-		state := newState(p)
-		if err := t.executor.Publish(t, state); err != nil {
+		if _, err := t.dispatch(ctx, p); err != nil {
 			return err
 		}
 	}
@@ -95,6 +124,11 @@ func (t *Task[T]) Name() string {
 	return nameRegex.ReplaceAllString(t.TaskName, "")
 }
 
+// TaskTags returns the tags of the task, hooks read it through TaggedTask
+func (t *Task[T]) TaskTags() []string {
+	return t.Tags
+}
+
 // Collector returns the collector for the task
 func (t *Task[T]) Collector() *Collector {
 	return t.collector
@@ -140,6 +174,30 @@ func WithDefaultParameters(parameters map[string]any) func(*taskConfig) {
 	}
 }
 
+// WithParameterSchema sets the JSON schema of the task parameters, used to document
+// the task in the OpenAPI specification. It is inferred from the default parameters when not set.
+func WithParameterSchema(schema map[string]any) func(*taskConfig) {
+	return func(t *taskConfig) {
+		t.ParameterSchema = schema
+	}
+}
+
+// WithTimeout sets the maximum duration of an attempt. The task context is done
+// once the timeout is exceeded, the action is expected to return on its own.
+func WithTimeout(timeout time.Duration) func(*taskConfig) {
+	return func(t *taskConfig) {
+		t.Timeout = timeout
+	}
+}
+
+// WithQueues makes the task consume additional queues, each with the concurrency of the task.
+// Executions are sent to one of them with the Queue dispatch option.
+func WithQueues(queues ...string) func(*taskConfig) {
+	return func(t *taskConfig) {
+		t.Queues = append(t.Queues, queues...)
+	}
+}
+
 // WithTags sets the tags for the task
 func WithTags(tags ...string) func(*taskConfig) {
 	return func(t *taskConfig) {
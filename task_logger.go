@@ -11,8 +11,28 @@ import (
 type taskLogger[T any] struct {
 	storage storage.Storage
 	pending chan pendingTask
+	hooks   []Hook
 }
 
+// upsertTaskQuery inserts or updates an execution in the tasks table
+const upsertTaskQuery = `
+	INSERT INTO tasks (task_id, status, task_name, parent_id, state, iterations, published_at, started_at, ended_at, last_error, node_id, final)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	ON CONFLICT (task_id, published_at)
+	DO UPDATE SET
+		status = $2,
+		task_name = $3,
+		parent_id = $4,
+		state = $5,
+		iterations = $6,
+		published_at = $7,
+		started_at = $8,
+		ended_at = $9,
+		last_error = $10,
+		node_id = $11,
+		final = $12
+`
+
 type pendingTask struct {
 	TaskID        uuid.UUID
 	Status        stateStatus
@@ -24,9 +44,29 @@ type pendingTask struct {
 	StartedAt     time.Time
 	EndedAt       time.Time
 	LastError     string
+	NodeID        string
+	Final         bool
 }
 
-func NewTaskLogger[T any](storage storage.Storage) (*taskLogger[T], error) {
+// args returns the arguments of upsertTaskQuery
+func (p pendingTask) args() []any {
+	return []any{
+		p.TaskID,
+		p.Status,
+		p.TaskName,
+		p.ParentID,
+		p.Parameters,
+		p.Iterations,
+		p.InitializedAt,
+		p.StartedAt,
+		p.EndedAt,
+		p.LastError,
+		p.NodeID,
+		p.Final,
+	}
+}
+
+func NewTaskLogger[T any](storage storage.Storage, hooks ...Hook) (*taskLogger[T], error) {
 	_, err := storage.Exec(`
 		CREATE TABLE IF NOT EXISTS tasks (
 			task_id UUID,
@@ -50,6 +90,16 @@ func NewTaskLogger[T any](storage storage.Storage) (*taskLogger[T], error) {
 		log.Fatalf("failed to create task logs table: %v", err)
 	}
 
+	_, err = storage.Exec(`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS node_id VARCHAR(64)`)
+	if err != nil {
+		log.Fatalf("failed to add node column to task logs table: %v", err)
+	}
+
+	_, err = storage.Exec(`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS final BOOLEAN`)
+	if err != nil {
+		log.Fatalf("failed to add final column to task logs table: %v", err)
+	}
+
 	_, err = storage.Exec(
 		`SELECT add_retention_policy('tasks', drop_after => INTERVAL '7 days', if_not_exists => true)`,
 	)
@@ -60,6 +110,7 @@ func NewTaskLogger[T any](storage storage.Storage) (*taskLogger[T], error) {
 	tl := &taskLogger[T]{
 		storage: storage,
 		pending: make(chan pendingTask, 1000),
+		hooks:   hooks,
 	}
 	go tl.worker()
 	return tl, nil
@@ -73,33 +124,7 @@ func (h *taskLogger[T]) worker() {
 	for {
 		select {
 		case pending := <-h.pending:
-			batch.Add(
-				`
-				INSERT INTO tasks (task_id, status, task_name, parent_id, state, iterations, published_at, started_at, ended_at, last_error)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-				ON CONFLICT (task_id, published_at)
-				DO UPDATE SET
-					status = $2,
-					task_name = $3,
-					parent_id = $4,
-					state = $5,
-					iterations = $6,
-					published_at = $7,
-					started_at = $8,
-					ended_at = $9,
-					last_error = $10
-				`,
-				pending.TaskID,
-				pending.Status,
-				pending.TaskName,
-				pending.ParentID,
-				pending.Parameters,
-				pending.Iterations,
-				pending.InitializedAt,
-				pending.StartedAt,
-				pending.EndedAt,
-				pending.LastError,
-			)
+			batch.Add(upsertTaskQuery, pending.args()...)
 
 			if batch.Size() >= 5000 {
 				select {
@@ -113,21 +138,61 @@ func (h *taskLogger[T]) worker() {
 			default:
 			}
 		case <-shouldFlush:
-			if err := batch.Execute(); err != nil {
+			start := time.Now()
+			size := batch.Size()
+			err := batch.Execute()
+			if err != nil {
 				log.Printf("failed to flush tasks to storage: %v", err)
 			}
 			batch = h.storage.NewBatch()
+
+			event := &FlushEvent{Size: size, Duration: time.Since(start), Backlog: len(h.pending), Error: err}
+			runHooks(h.hooks, func(h FlushHook) { h.OnFlush(event) })
 		}
 	}
 }
 
+// Backlog returns the number of executions waiting to be flushed and the capacity of the queue
+func (h *taskLogger[T]) Backlog() (int, int) {
+	return len(h.pending), cap(h.pending)
+}
+
 func (h *taskLogger[T]) LogTasks(task *Task[T], state *State) error {
-	parameters, err := state.EncodeParameters()
+	pending, err := newPendingTask(task, state)
 	if err != nil {
 		log.Printf("failed to encode parameters: %v", err)
 		return err
 	}
 
+	h.pending <- pending
+
+	return nil
+}
+
+// LogTasksBatch writes executions to the tasks table in a single batch, without
+// going through the queue of the worker
+func (h *taskLogger[T]) LogTasksBatch(task *Task[T], states []*State) error {
+	batch := h.storage.NewBatch()
+	for _, state := range states {
+		pending, err := newPendingTask(task, state)
+		if err != nil {
+			return err
+		}
+		if err := batch.Add(upsertTaskQuery, pending.args()...); err != nil {
+			return err
+		}
+	}
+
+	return batch.Execute()
+}
+
+// newPendingTask creates the row of an execution in the tasks table
+func newPendingTask[T any](task *Task[T], state *State) (pendingTask, error) {
+	parameters, err := state.EncodeParameters()
+	if err != nil {
+		return pendingTask{}, err
+	}
+
 	pending := pendingTask{
 		TaskID:        state.TaskID,
 		Status:        state.Status,
@@ -137,13 +202,14 @@ func (h *taskLogger[T]) LogTasks(task *Task[T], state *State) error {
 		Iterations:    state.Iterations,
 		InitializedAt: state.InitializedAt,
 		StartedAt:     state.StartedAt,
+		LastError:     state.LastError,
+		NodeID:        state.NodeID,
+		Final:         state.Final,
 	}
 
-	if state.Status == StatusSuccess || state.Status == StatusFailed {
+	if state.Status == StatusSuccess || state.Status == StatusFailed || state.Status == StatusLost || state.Status == StatusExpired {
 		pending.EndedAt = time.Now()
 	}
 
-	h.pending <- pending
-
-	return nil
+	return pending, nil
 }
@@ -0,0 +1,112 @@
+package zsched
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer used by the executor
+const tracerName = "github.com/vlourme/zsched"
+
+// propagator propagates the W3C trace context through the state of executions
+var propagator = propagation.TraceContext{}
+
+// retryKey is the context key of the previous attempt of a retried execution
+type retryKey struct{}
+
+// retryContext returns the context publishing a retry: the publish span joins the trace of the
+// original dispatch and links to the span of the previous attempt, if any
+func (e *executor[T]) retryContext(state *State, previous trace.Span) context.Context {
+	ctx := propagator.Extract(e.ctx, propagation.MapCarrier(state.TraceContext))
+
+	var link trace.Link
+	if previous != nil {
+		link = trace.Link{SpanContext: previous.SpanContext()}
+	}
+
+	return context.WithValue(ctx, retryKey{}, link)
+}
+
+// startPublishSpan starts the producer span of a dispatch and injects its context into the state,
+// retries keep the trace context of the original dispatch so every attempt shares its parent
+func (e *executor[T]) startPublishSpan(ctx context.Context, task *Task[T], state *State) trace.Span {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.operation.type", "send"),
+			attribute.String("messaging.destination.name", task.Name()),
+			attribute.String("zsched.task.name", task.Name()),
+			attribute.String("zsched.execution.id", state.TaskID.String()),
+			attribute.Int("zsched.attempt", state.Iterations),
+		),
+	}
+
+	link, retry := ctx.Value(retryKey{}).(trace.Link)
+	if retry && link.SpanContext.IsValid() {
+		opts = append(opts, trace.WithLinks(link))
+	}
+
+	ctx, span := e.tracer.Start(ctx, "publish "+task.Name(), opts...)
+
+	if !retry || len(state.TraceContext) == 0 {
+		state.TraceContext = make(map[string]string)
+		propagator.Inject(ctx, propagation.MapCarrier(state.TraceContext))
+	}
+
+	return span
+}
+
+// startPublishBatchSpan starts the producer span of a batch of dispatches, it returns
+// the trace context shared by the executions of the batch
+func (e *executor[T]) startPublishBatchSpan(ctx context.Context, task *Task[T], size int) (trace.Span, map[string]string) {
+	ctx, span := e.tracer.Start(ctx, "publish "+task.Name(),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.operation.type", "send"),
+			attribute.String("messaging.destination.name", task.Name()),
+			attribute.Int("messaging.batch.message_count", size),
+			attribute.String("zsched.task.name", task.Name()),
+		),
+	)
+
+	traceContext := make(map[string]string)
+	propagator.Inject(ctx, propagation.MapCarrier(traceContext))
+
+	return span, traceContext
+}
+
+// startConsumeSpan starts the consumer span of an attempt, child of the publish span
+func (e *executor[T]) startConsumeSpan(task *Task[T], state *State) (context.Context, trace.Span) {
+	ctx := propagator.Extract(e.ctx, propagation.MapCarrier(state.TraceContext))
+
+	return e.tracer.Start(ctx, task.Name(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("messaging.destination.name", task.Name()),
+			attribute.String("zsched.task.name", task.Name()),
+			attribute.String("zsched.execution.id", state.TaskID.String()),
+			attribute.Int("zsched.attempt", state.Iterations),
+		),
+	)
+}
+
+// endSpan records the outcome of an operation and ends the span
+func endSpan(span trace.Span, status stateStatus, err error) {
+	if status != "" {
+		span.SetAttributes(attribute.String("zsched.status", string(status)))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	span.End()
+}
@@ -0,0 +1,67 @@
+package zsched
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/google/uuid"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRetryTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	e, b, _ := newTestExecutor(t)
+	e.tracer = provider.Tracer(tracerName)
+
+	task := NewTask("mail", func(*Context[any]) error { return errors.New("boom") }, WithMaxRetries(3))
+	s := &State{TaskID: uuid.New(), Parameters: map[string]any{}}
+	if err := e.Publish(context.Background(), task, s); err != nil {
+		t.Fatal(err)
+	}
+	original := maps.Clone(s.TraceContext)
+
+	if err := e.execute(task, s); err == nil {
+		t.Fatal("execute did not fail")
+	}
+
+	published := b.published()
+	if len(published) != 2 {
+		t.Fatalf("published = %d messages", len(published))
+	}
+	retried, err := e.decode(published[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(retried.TraceContext, original) {
+		t.Errorf("retry trace context = %v, want %v", retried.TraceContext, original)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("spans = %d, want publish, attempt and retry publish", len(spans))
+	}
+	publish, attempt, retry := spans[0], spans[2], spans[1]
+	if retry.Name() != "publish mail" || attempt.Name() != "mail" {
+		t.Fatalf("spans = %s, %s, %s", spans[0].Name(), spans[1].Name(), spans[2].Name())
+	}
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{name: "attempt is a child of the publish", ok: attempt.Parent().SpanID() == publish.SpanContext().SpanID()},
+		{name: "retry stays in the trace", ok: retry.SpanContext().TraceID() == publish.SpanContext().TraceID()},
+		{name: "retry is a child of the publish", ok: retry.Parent().SpanID() == publish.SpanContext().SpanID()},
+		{name: "retry links to the attempt", ok: len(retry.Links()) == 1 && retry.Links()[0].SpanContext.SpanID() == attempt.SpanContext().SpanID()},
+	}
+	for _, tt := range tests {
+		if !tt.ok {
+			t.Error(tt.name)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/**
+ * Headers authenticating the dashboard against the Zsched API
+ * @returns The authorization headers, empty when no API key is configured
+ */
+export const authHeaders = (): Record<string, string> =>
+  process.env.ZSCHED_API_KEY
+    ? { Authorization: `Bearer ${process.env.ZSCHED_API_KEY}` }
+    : {};
+
+/**
+ * Make a request to the Zsched API
+ * @param url - The URL to make the request to
+ * @param options - The options for the request
+ * @returns The response from the request
+ */
+export const api = async <T>(
+  url: string,
+  options?: RequestInit
+): Promise<T> => {
+  const response = await fetch(process.env.ZSCHED_URL + url, {
+    ...(options || {}),
+    headers: {
+      "Content-Type": "application/json",
+      ...authHeaders(),
+      ...(options?.headers || {}),
+    },
+  });
+
+  if (!response.ok) {
+    throw new Response(await response.text(), { status: response.status });
+  }
+
+  return response.json() as T;
+};
+
+/**
+ * Build a query string from the given parameters, skipping empty values
+ * @param params - The query parameters
+ * @returns The query string, prefixed with "?" when not empty
+ */
+export const query = (
+  params: Record<string, string | number | undefined | null>
+) => {
+  const search = new URLSearchParams();
+  for (const [key, value] of Object.entries(params)) {
+    if (value !== undefined && value !== null && value !== "") {
+      search.set(key, String(value));
+    }
+  }
+
+  const str = search.toString();
+  return str ? `?${str}` : "";
+};
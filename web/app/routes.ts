@@ -12,4 +12,5 @@ export default [
     route("tasks/:name", "routes/task.tsx"),
     route("logs/:task_id", "routes/logs.tsx"),
   ]),
+  route("logs/:task_id/stream", "routes/logs-stream.ts"),
 ] satisfies RouteConfig;
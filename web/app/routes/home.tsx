@@ -9,9 +9,9 @@ import {
   CardDescription,
   CardTitle,
 } from "~/components/ui/card";
-import { pool } from "~/lib/db";
 import { formatDuration } from "~/lib/formatters";
 import { request } from "~/lib/lavinmq";
+import { api, query } from "~/lib/zsched";
 import type { MQOverview } from "~/types/mq-overview";
 import type { Route } from "./+types/home";
 
@@ -25,33 +25,33 @@ export function meta({}: Route.MetaArgs) {
   ];
 }
 
+/**
+ * Count the executions of the last 24 hours, up to the maximum page size of the API
+ * @param status - The status of the counted executions, all when empty
+ * @returns The number of executions, "1000+" past the page size
+ */
+const countExecutions = async (status?: string) => {
+  const since = new Date(Date.now() - 24 * 60 * 60 * 1000).toISOString();
+  const { executions } = await api<any>(
+    `/executions${query({ since, status, limit: 1000 })}`
+  );
+
+  return executions.length >= 1000 ? "1000+" : executions.length;
+};
+
 export async function loader() {
   const [overview, executions, successes, errors] = await Promise.all([
     request<MQOverview>("/api/overview"),
-    pool.query(`
-      SELECT count(*) as c 
-      FROM tasks 
-      WHERE started_at > NOW() - INTERVAL '24 hours'
-    `),
-    pool.query(`
-      SELECT count(*) as c 
-      FROM tasks 
-      WHERE status = 'success'
-        AND started_at > NOW() - INTERVAL '24 hours'
-    `),
-    pool.query(`
-      SELECT count(*) as c 
-      FROM tasks 
-      WHERE status = 'failed'
-        AND started_at > NOW() - INTERVAL '24 hours'
-    `),
+    countExecutions(),
+    countExecutions("success"),
+    countExecutions("failed"),
   ]);
 
   return {
     overview: overview,
-    executions: executions.rows[0].c || 0,
-    successes: successes.rows[0].c || 0,
-    errors: errors.rows[0].c || 0,
+    executions: executions,
+    successes: successes,
+    errors: errors,
   };
 }
 
@@ -0,0 +1,24 @@
+import { authHeaders } from "~/lib/zsched";
+import type { Route } from "./+types/logs-stream";
+
+/**
+ * Proxy the execution log stream of the Zsched API to the browser
+ */
+export async function loader({ params, request }: Route.LoaderArgs) {
+  const since = new URL(request.url).searchParams.get("since");
+  const response = await fetch(
+    `${process.env.ZSCHED_URL}/executions/${params.task_id}/logs/stream${
+      since ? `?since=${encodeURIComponent(since)}` : ""
+    }`,
+    { signal: request.signal, headers: authHeaders() }
+  );
+
+  return new Response(response.body, {
+    status: response.status,
+    headers: {
+      "Content-Type": "text/event-stream",
+      "Cache-Control": "no-cache",
+      Connection: "keep-alive",
+    },
+  });
+}
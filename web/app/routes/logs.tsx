@@ -1,5 +1,6 @@
 import { Editor } from "@monaco-editor/react";
-import { Form, redirect, useLoaderData } from "react-router";
+import { useEffect, useState } from "react";
+import { Form, redirect, useLoaderData, useParams } from "react-router";
 import { Button } from "~/components/ui/button";
 import {
   Card,
@@ -16,7 +17,7 @@ import {
   TableHeader,
   TableRow,
 } from "~/components/ui/table";
-import { pool } from "~/lib/db";
+import { api } from "~/lib/zsched";
 import type { Route } from "./+types/logs";
 
 export function meta({}: Route.MetaArgs) {
@@ -30,26 +31,21 @@ export function meta({}: Route.MetaArgs) {
 }
 
 export async function loader({ params }: Route.LoaderArgs) {
-  const [task, logs] = await Promise.all([
-    pool.query(`SELECT task_name, state FROM tasks WHERE task_id = $1`, [
-      params.task_id,
-    ]),
-    pool.query(
-      `SELECT task_id, state_id, level, message, data, logged_at FROM logs WHERE task_id = $1 ORDER BY logged_at DESC
-      `,
-      [params.task_id]
-    ),
-  ]);
+  try {
+    const [execution, logs] = await Promise.all([
+      api<any>(`/executions/${params.task_id}`),
+      api<any[]>(`/executions/${params.task_id}/logs`),
+    ]);
 
-  if (task.rows.length === 0) {
+    return {
+      task: execution.task_name,
+      parameters: execution.parameters,
+      progress: execution.progress,
+      logs: logs,
+    };
+  } catch {
     return redirect("/tasks");
   }
-
-  return {
-    task: task.rows[0].task_name,
-    parameters: task.rows[0].state,
-    logs: logs.rows,
-  };
 }
 
 export const handle = {
@@ -57,19 +53,32 @@ export const handle = {
   group: "tasks",
 };
 
-export async function action({ request, params }: Route.ActionArgs) {
-  const formData = await request.formData();
-  const taskName = formData.get("task_name");
-  const parameters = formData.get("parameters");
-
-  await fetch(process.env.ZSCHED_URL + "/tasks/" + taskName, {
-    method: "POST",
-    body: parameters as string,
-  });
+export async function action({ params }: Route.ActionArgs) {
+  await api(`/executions/${params.task_id}/retry`, { method: "POST" });
 }
 
 export default function Logs() {
-  const { task, logs, parameters } = useLoaderData<typeof loader>();
+  const { task, logs: initialLogs, parameters, progress } =
+    useLoaderData<typeof loader>();
+  const { task_id } = useParams();
+  const [logs, setLogs] = useState(initialLogs);
+
+  useEffect(() => {
+    setLogs(initialLogs);
+
+    const since = initialLogs[0]?.logged_at;
+    const source = new EventSource(
+      `/logs/${task_id}/stream${since ? `?since=${encodeURIComponent(since)}` : ""}`
+    );
+    source.addEventListener("log", (event) => {
+      const log = JSON.parse(event.data);
+      setLogs((logs) => [log, ...logs]);
+    });
+    source.addEventListener("end", () => source.close());
+    source.addEventListener("error", () => source.close());
+
+    return () => source.close();
+  }, [task_id, initialLogs]);
 
   return (
     <>
@@ -79,15 +88,16 @@ export default function Logs() {
             <CardTitle>{task} task parameters</CardTitle>
             <CardDescription>
               This task was started with the following parameters.
+              {progress && (
+                <span className="block mt-1">
+                  Progress: {progress.done}
+                  {progress.total > 0 && ` / ${progress.total}`}
+                  {progress.message && ` (${progress.message})`}
+                </span>
+              )}
             </CardDescription>
           </div>
           <Form method="post">
-            <input
-              type="hidden"
-              name="parameters"
-              value={JSON.stringify(parameters)}
-            />
-            <input type="hidden" name="task_name" value={task} />
             <Button variant="outline" size="sm" type="submit">
               Dispatch again
             </Button>
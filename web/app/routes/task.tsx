@@ -4,6 +4,8 @@ import {
   CheckIcon,
   ClockIcon,
   Loader2Icon,
+  TimerOffIcon,
+  UnplugIcon,
 } from "lucide-react";
 import {
   Form,
@@ -26,9 +28,9 @@ import {
   TableHeader,
   TableRow,
 } from "~/components/ui/table";
-import { pool } from "~/lib/db";
 import { formatDuration } from "~/lib/formatters";
 import { request } from "~/lib/lavinmq";
+import { api, query } from "~/lib/zsched";
 import type { Route } from "./+types/task";
 
 export function meta({}: Route.MetaArgs) {
@@ -49,17 +51,11 @@ export async function action({ request: req, params }: Route.ActionArgs) {
   }
 
   if (formData.get("action") === "purge") {
-    await Promise.all([
-      request<any>(
-        `/api/queues/${encodeURIComponent(vhost)}/${params.name}/contents`,
-        { method: "DELETE" },
-        "text"
-      ),
-      pool.query(
-        `DELETE FROM tasks WHERE task_name = $1 AND status IN ('pending', 'running')`,
-        [params.name]
-      ),
-    ]);
+    await request<any>(
+      `/api/queues/${encodeURIComponent(vhost)}/${params.name}/contents`,
+      { method: "DELETE" },
+      "text"
+    );
     return;
   }
 
@@ -82,7 +78,7 @@ export async function action({ request: req, params }: Route.ActionArgs) {
 
   const parameters = formData.get("parameters");
 
-  await fetch(process.env.ZSCHED_URL + "/tasks/" + params.name, {
+  await api(`/tasks/${params.name}`, {
     method: "POST",
     body: parameters as string,
   });
@@ -97,54 +93,20 @@ export async function loader({ params, request: req }: Route.LoaderArgs) {
     return redirect("/tasks");
   }
 
-  let after = null;
-  if (searchParams.get("after")) {
-    after = new Date(parseInt(searchParams.get("after") ?? "0"));
-  }
+  const before = searchParams.get("before");
 
-  const [task, stats, executions, queues] = await Promise.all([
-    fetch(process.env.ZSCHED_URL + "/tasks/" + params.name).then((res) =>
-      res.json()
-    ),
-    pool.query(
-      `
-      SELECT 
-        count(*) as total_exec,
-        MAX(started_at) as last_exec,
-        sum(CASE WHEN status = 'success' THEN 1 ELSE 0 END) as total_success,
-        sum(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) as total_err
-      FROM tasks
-      WHERE task_name = $1 ${after ? `AND published_at <= $2` : ""}
-      `,
-      ([params.name] as any[]).concat(after ? [after] : [])
-    ),
-    pool.query(
-      `
-      SELECT 
-        task_id, 
-        status,
-        parent_id, 
-        published_at,
-        started_at, 
-        ended_at,
-        published_at as cursor, 
-        last_error, 
-        iterations,
-        EXTRACT(EPOCH FROM (ended_at - started_at)) as duration
-      FROM tasks
-      WHERE task_name = $1 ${after ? `AND published_at <= $2` : ""}
-      ORDER BY published_at DESC
-      LIMIT 100
-      `,
-      ([params.name] as any[]).concat(after ? [after] : [])
+  const [task, executions, queues] = await Promise.all([
+    api<any>(`/tasks/${params.name}`),
+    api<any>(
+      `/executions${query({ task_name: params.name, before, limit: 100 })}`
     ),
     request<any>(`/api/queues/${encodeURIComponent(vhost)}/${params.name}`),
   ]);
 
   return {
     task: task,
-    stats: stats.rows[0],
-    executions: executions.rows,
+    executions: executions.executions,
+    cursor: executions.next_cursor,
     queue: queues,
   };
 }
@@ -164,6 +126,10 @@ export function StatusIcon({ status }: { status: string }) {
       return <CheckIcon className="size-4 text-green-500" />;
     case "failed":
       return <AlertCircleIcon className="size-4 text-red-500" />;
+    case "lost":
+      return <UnplugIcon className="size-4 text-orange-500" />;
+    case "expired":
+      return <TimerOffIcon className="size-4 text-gray-400" />;
   }
 }
 
@@ -222,7 +188,8 @@ export function ExecutionRow({ execution }: { execution: any }) {
 }
 
 export default function Task() {
-  const { task, stats, executions, queue } = useLoaderData<typeof loader>();
+  const { task, executions, cursor, queue } =
+    useLoaderData<typeof loader>();
   const [searchParams, setSearchParams] = useSearchParams();
 
   return (
@@ -253,26 +220,14 @@ export default function Task() {
                   {task.max_retries === -1 ? "∞" : task.max_retries}
                 </p>
               </div>
-              <div className="flex flex-col w-36 gap-1">
-                <p className="text-sm text-muted-foreground">
-                  Total executions
-                </p>
-                <p className="text-sm">{stats.total_exec}</p>
-              </div>
               <div className="flex flex-col w-36 gap-1">
                 <p className="text-sm text-muted-foreground">Last execution</p>
                 <p className="text-sm">
-                  {new Date(stats.last_exec).toLocaleString()}
+                  {executions.length > 0
+                    ? new Date(executions[0].published_at).toLocaleString()
+                    : "-"}
                 </p>
               </div>
-              <div className="flex flex-col w-36 gap-1">
-                <p className="text-sm text-muted-foreground">Total successes</p>
-                <p className="text-sm">{stats.total_success ?? 0}</p>
-              </div>
-              <div className="flex flex-col w-36 gap-1">
-                <p className="text-sm text-muted-foreground">Total errors</p>
-                <p className="text-sm">{stats.total_err ?? 0}</p>
-              </div>
               <div className="flex flex-col w-36 gap-1">
                 <p className="text-sm text-muted-foreground">Pending</p>
                 <p className="text-sm">
@@ -323,9 +278,9 @@ export default function Task() {
         <div className="md:flex-1"></div>
 
         <p>
-          {executions.length} out of {stats.total_exec} executions
+          {executions.length} executions
         </p>
-        {executions.length == 100 ? (
+        {cursor ? (
           <Button
             size="sm"
             variant="outline"
@@ -333,7 +288,7 @@ export default function Task() {
               setSearchParams(
                 {
                   ...Object.fromEntries(searchParams.entries()),
-                  after: executions[executions.length - 1]?.cursor.getTime(),
+                  before: cursor,
                 },
                 { replace: true }
               );
@@ -18,6 +18,7 @@ import {
 } from "~/components/ui/table";
 import { stringToColor } from "~/lib/color";
 import { request } from "~/lib/lavinmq";
+import { api } from "~/lib/zsched";
 import type { Queue } from "~/types/mq-queues";
 import type { Route } from "./+types/tasks";
 
@@ -54,7 +55,7 @@ export function meta({}: Route.MetaArgs) {
 
 export async function loader() {
   const [tasks, queues] = await Promise.all([
-    fetch(process.env.ZSCHED_URL + "/tasks").then((res) => res.json()),
+    api<any[]>("/tasks"),
     request<Queue[]>("/api/queues"),
   ]);
   return {
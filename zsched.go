@@ -1,17 +1,59 @@
 package zsched
 
 import (
+	"context"
 	"errors"
 	"log"
+	"maps"
 	"net/http"
+	"runtime/debug"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron/v3"
+	"github.com/vlourme/zsched/pkg/auth"
+	"github.com/vlourme/zsched/pkg/blob"
 	"github.com/vlourme/zsched/pkg/broker"
+	"github.com/vlourme/zsched/pkg/codec"
 	"github.com/vlourme/zsched/pkg/logger"
 	"github.com/vlourme/zsched/pkg/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// modulePath is the import path of this module
+const modulePath = "github.com/vlourme/zsched"
+
+// Version returns the version of zsched the binary has been built with
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+
+	return "unknown"
+}
+
+// scheduleEntry maps a cron entry to its task
+type scheduleEntry struct {
+	taskName string
+	schedule string
+}
+
 // Engine is the main engine for Zsched scheduler
 type Engine[T any] struct {
 	broker      broker.Broker
@@ -24,6 +66,37 @@ type Engine[T any] struct {
 	userContext T
 	executor    *executor[T]
 	apiAddress  string
+	events      *EventBus
+	startedAt   time.Time
+	ctx         context.Context
+	cancel      context.CancelFunc
+	schedules   map[cron.EntryID]scheduleEntry
+
+	authenticators []auth.Authenticator
+	middlewares    []Middleware[T]
+	tracerProvider trace.TracerProvider
+	tracerShutdown func(context.Context) error
+
+	roles       []Role
+	workerTasks []string
+	workerTags  []string
+
+	nodeID            string
+	heartbeatInterval time.Duration
+	reaperTimeout     time.Duration
+	reaperRedispatch  bool
+
+	resources map[string]*Resource
+
+	outbox bool
+
+	codec                codec.Codec
+	compression          codec.Compression
+	compressionThreshold int
+
+	blobs               blob.Store
+	claimCheckThreshold int
+	claimCheckRetention time.Duration
 }
 
 // Register registers new tasks to the scheduler
@@ -37,67 +110,219 @@ func (e *Engine[T]) Register(task ...*Task[T]) {
 	}
 }
 
+// Events returns the event bus of the engine, hooks may publish their own events to it
+func (e *Engine[T]) Events() *EventBus {
+	return e.events
+}
+
 // Start starts the engine, this function is blocking until the engine is stopped
 func (e *Engine[T]) Start() error {
 	e.logger.Info("Starting engine...")
+	e.startedAt = time.Now()
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	e.schedules = make(map[cron.EntryID]scheduleEntry)
 
-	taskLogger, err := NewTaskLogger[T](e.storage)
+	taskLogger, err := NewTaskLogger[T](e.storage, e.hooks...)
 	if err != nil {
 		return errors.Join(errors.New("failed to create task logger"), err)
 	}
 
+	if e.tracerProvider == nil {
+		e.tracerProvider = otel.GetTracerProvider()
+	}
+
+	if err := createProgressTables(e.storage); err != nil {
+		return errors.Join(errors.New("failed to create progress tables"), err)
+	}
+
+	if err := createHeartbeatTables(e.storage); err != nil {
+		return errors.Join(errors.New("failed to create heartbeat tables"), err)
+	}
+
+	if err := e.validateResources(); err != nil {
+		return err
+	}
+
+	if err := createResourceTables(e.storage, e.resources); err != nil {
+		return errors.Join(errors.New("failed to create resource tables"), err)
+	}
+
+	if err := createDispatchTables(e.storage); err != nil {
+		return errors.Join(errors.New("failed to create dispatch tables"), err)
+	}
+
+	if err := createOutboxTable(e.storage); err != nil {
+		return errors.Join(errors.New("failed to create outbox table"), err)
+	}
+
+	if err := createFairnessTable(e.storage); err != nil {
+		return errors.Join(errors.New("failed to create fairness table"), err)
+	}
+
 	e.executor = &executor[T]{
 		taskLogger:  taskLogger,
 		broker:      e.broker,
+		storage:     e.storage,
 		logger:      e.logger,
 		hooks:       e.hooks,
 		userContext: e.userContext,
+		ctx:         e.ctx,
+		middlewares: e.middlewares,
+		tracer:      e.tracerProvider.Tracer(tracerName),
+		nodeID:      e.nodeID,
+		slotTimeout: e.heartbeatInterval * nodeTimeoutHeartbeats,
+		resources:   e.resources,
+		outbox:      e.outbox,
+		outboxWake:  make(chan struct{}, 1),
+
+		codec:                e.codec,
+		compression:          e.compression,
+		compressionThreshold: e.compressionThreshold,
+
+		blobs:               e.blobs,
+		claimCheckThreshold: e.claimCheckThreshold,
+		claimCheckRetention: e.claimCheckRetention,
 	}
 
+	// Queues of the tasks consumed elsewhere are declared so their messages are kept
+	if declarer, ok := e.broker.(broker.Declarer); ok {
+		queues := make([]string, 0)
+		for _, task := range e.tasks {
+			if !e.consumes(task) {
+				queues = append(queues, task.Name())
+				queues = append(queues, task.Queues...)
+			}
+		}
+		if len(queues) > 0 {
+			if err := declarer.Declare(queues...); err != nil {
+				return errors.Join(errors.New("failed to declare queues"), err)
+			}
+		}
+	}
+
+	consumers := 0
 	for _, task := range e.tasks {
 		task.executor = e.executor
 
-		for _, schedule := range task.Schedules {
-			_, err := e.cron.AddFunc(schedule.Schedule, func() {
-				if err := task.Execute(schedule.Parameters); err != nil {
-					e.logger.WithError(err).WithField("task_name", task.Name()).Error("failed to execute task")
-				}
-			})
-			if err != nil {
-				return errors.Join(errors.New("failed to add schedule for task"), err)
+		if e.hasRole(Scheduler) {
+			if err := e.schedule(task); err != nil {
+				return err
 			}
 		}
 
+		if !e.consumes(task) {
+			continue
+		}
+
+		consumers++
 		e.wg.Go(func() {
-			e.executor.Consume(task)
+			if err := e.executor.Consume(task); err != nil {
+				e.logger.WithError(err).WithField("task_name", task.Name()).Error("consumer stopped")
+			}
 		})
 	}
 
-	if e.apiAddress != "" {
-		router := newRouter(e.tasks, e.storage)
+	if e.apiAddress != "" && e.hasRole(API) {
+		router := newRouter(e)
 		e.logger.WithField("listen_addr", e.apiAddress).Info("Starting API server...")
 		go http.ListenAndServe(e.apiAddress, router)
 	}
 
 	e.cron.Start()
+
+	if e.hasRole(Scheduler) {
+		go e.dispatchDelayed()
+		go e.cleanTables()
+
+		if e.blobs != nil {
+			go e.cleanBlobs()
+		}
+	}
+
+	// The outbox is relayed even without WithOutbox, for the executions of ExecuteTx
+	go e.relayOutbox()
+
+	go e.heartbeat()
+
+	runHooks(e.hooks, func(h EngineStartHook) {
+		h.OnEngineStart(&EngineEvent{Tasks: slices.Sorted(maps.Keys(e.tasks))})
+	})
+
+	// Without consumers, the engine runs until it is closed
+	if consumers == 0 {
+		<-e.ctx.Done()
+	}
+
 	e.wg.Wait()
 
 	return nil
 }
 
-// Close closes the engine
+// schedule adds the cron schedules of the task
+func (e *Engine[T]) schedule(task *Task[T]) error {
+	for _, schedule := range task.Schedules {
+		id, err := e.cron.AddFunc(schedule.Schedule, func() {
+			err := task.Execute(schedule.Parameters)
+			if err != nil {
+				e.logger.WithError(err).WithField("task_name", task.Name()).Error("failed to execute task")
+			}
+
+			event := &ScheduleEvent{Task: task, Schedule: schedule.Schedule, Error: err}
+			runHooks(e.hooks, func(h ScheduleHook) { h.OnSchedule(event) })
+		})
+		if err != nil {
+			return errors.Join(errors.New("failed to add schedule for task"), err)
+		}
+		e.schedules[id] = scheduleEntry{taskName: task.Name(), schedule: schedule.Schedule}
+	}
+
+	return nil
+}
+
+// Close closes the engine, running executions are canceled
 func (e *Engine[T]) Close() error {
+	if e.cancel != nil {
+		e.cancel()
+	}
+
+	e.cron.Stop()
+
+	runHooks(e.hooks, func(h EngineStopHook) {
+		h.OnEngineStop(&EngineEvent{
+			Tasks:  slices.Sorted(maps.Keys(e.tasks)),
+			Uptime: time.Since(e.startedAt),
+		})
+	})
+
+	// Flush the remaining spans of the provider created by WithTracing
+	if e.tracerShutdown != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := e.tracerShutdown(ctx); err != nil {
+			e.logger.WithError(err).Error("failed to shutdown tracer provider")
+		}
+	}
+
 	err := e.broker.Close()
 	if err != nil {
 		return err
 	}
 
+	if e.executor != nil {
+		if err := e.unregisterNode(); err != nil {
+			e.logger.WithError(err).Error("failed to unregister node")
+		}
+	}
+
+	// Logs are flushed before the storage is closed
+	if err := logger.Close(e.logger); err != nil {
+		e.logger.WithError(err).Error("failed to close logger")
+	}
+
 	err = e.storage.Close()
 	if err != nil {
 		return err
 	}
 
-	e.cron.Stop()
-
 	return nil
 }